@@ -0,0 +1,37 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+// ParseAge parses a Go duration, also accepting days and weeks such as "7d" or "2w"
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	unit := map[byte]time.Duration{'d': day, 'w': week}[s[len(s)-1]]
+	if unit == 0 {
+		return time.ParseDuration(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return time.Duration(n * float64(unit)), nil
+}
+
+// createdAt is the creation time of a submission
+func createdAt(createdUTC float64) time.Time {
+	return time.Unix(int64(createdUTC), 0)
+}
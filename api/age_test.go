@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, true},
+		{"36h", 36 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"2w", 14 * 24 * time.Hour, true},
+		{"1.5d", 36 * time.Hour, true},
+		{" 2w ", 14 * 24 * time.Hour, true},
+		{"w", 0, false},
+		{"-1d", 0, false},
+		{"2 weeks", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseAge(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseAge(%q) = %v, %v, want %v and ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTwoWeeksMaxAgeCutsOffAtFourteenDays(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("subreddit.submissions.maxAge", "2w")
+	cfg := defaultConfig()
+	if cfg.MaxAge != 14*24*time.Hour {
+		t.Fatalf("maxAge is %v, want 14 days", cfg.MaxAge)
+	}
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	inside, outside := post("inside", "https://i.redd.it/inside.png"), post("outside", "https://i.redd.it/outside.png")
+	inside.DateCreated = float64(now.Add(-14*24*time.Hour + time.Minute).Unix())
+	outside.DateCreated = float64(now.Add(-14*24*time.Hour - time.Minute).Unix())
+
+	r := NewRedditFromConfig(&Config{Limit: 10, Sort: HotSubmissions, MaxAge: cfg.MaxAge, AllowedExtensions: []string{"png"}})
+	r.Logger = nil
+	r.Clock = fixedClock(now)
+	r.API = fakeAPI{[]*submission{inside, outside}}
+	r.current = r.subreddits()[0]
+
+	out := make(chan *submission, 2)
+	_, err := r.listSubmissions(context.Background(), out, make(chan struct{}), newListingProgress(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var listed []string
+	for p := range out {
+		listed = append(listed, p.ID)
+	}
+	if len(listed) != 1 || listed[0] != "inside" {
+		t.Errorf("listed %v, want the submission of the last 14 days", listed)
+	}
+}
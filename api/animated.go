@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+var errBadGIF = errors.New("malformed gif")
+
+// isAnimatedGIF walks the GIF blocks, without decoding them, until a second frame shows up
+func isAnimatedGIF(filename string) (bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+
+	// header and logical screen descriptor
+	var head [13]byte
+	_, err = io.ReadFull(br, head[:])
+	if err != nil {
+		return false, err
+	}
+	if string(head[:3]) != "GIF" {
+		return false, errBadGIF
+	}
+	err = skipColorTable(br, head[10])
+	if err != nil {
+		return false, err
+	}
+
+	frames := 0
+	for {
+		introducer, err := br.ReadByte()
+		if err != nil {
+			return false, err
+		}
+
+		switch introducer {
+		case 0x21: // extension: label then sub-blocks
+			_, err = br.ReadByte()
+			if err == nil {
+				err = skipSubBlocks(br)
+			}
+		case 0x2C: // image descriptor, local color table, LZW code size then sub-blocks
+			frames++
+			if frames > 1 {
+				return true, nil
+			}
+			var desc [9]byte
+			_, err = io.ReadFull(br, desc[:])
+			if err == nil {
+				err = skipColorTable(br, desc[8])
+			}
+			if err == nil {
+				_, err = br.ReadByte()
+			}
+			if err == nil {
+				err = skipSubBlocks(br)
+			}
+		case 0x3B: // trailer
+			return false, nil
+		default:
+			return false, errBadGIF
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// skipColorTable skips the color table a GIF packed field declares, if any
+func skipColorTable(br *bufio.Reader, packed byte) error {
+	if packed&0x80 == 0 {
+		return nil
+	}
+	_, err := br.Discard(3 << (uint(packed&0x07) + 1))
+	return err
+}
+
+func skipSubBlocks(br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		_, err = br.Discard(int(size))
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// testGIF encodes a width x height GIF of frames frames
+func testGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		for p := range frame.Pix {
+			frame.Pix[p] = uint8(p + i)
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, anim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnimatedRouting(t *testing.T) {
+	images := map[string][]byte{
+		"landscape.gif": testGIF(t, 60, 40, 2),
+		"portrait.gif":  testGIF(t, 40, 60, 3),
+		"still.gif":     testGIF(t, 60, 40, 1),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		byOrientation bool
+		want          map[string]string
+	}{
+		{"by orientation", true, map[string]string{
+			"landscape.gif": "animated/hori/landscape.gif",
+			"portrait.gif":  "animated/vert/portrait.gif",
+			"still.gif":     "hori/still.gif",
+		}},
+		{"together", false, map[string]string{
+			"landscape.gif": "animated/landscape.gif",
+			"portrait.gif":  "animated/portrait.gif",
+			"still.gif":     "hori/still.gif",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			var posts []*submission
+			for name := range images {
+				posts = append(posts, post(name, srv.URL+"/"+name))
+			}
+			r := newTestReddit(&Config{Animated: true, AnimatedByOrientation: tt.byOrientation,
+				AllowedExtensions: []string{"gif"}}, srv, posts...)
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != len(images) {
+				t.Fatalf("saved %+v, want every image", saved)
+			}
+			for _, d := range saved {
+				name := filepath.Base(d.Path)
+				if filepath.ToSlash(d.Path) != tt.want[name] {
+					t.Errorf("%s saved to %s, want %s", name, d.Path, tt.want[name])
+				}
+			}
+		})
+	}
+}
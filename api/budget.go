@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrRequestBudgetExhausted is returned once a run issued its maximum number of requests
+var ErrRequestBudgetExhausted = errors.New("request budget exhausted")
+
+// requestBudget counts the HTTP requests of a run, a max of 0 means unlimited
+type requestBudget struct {
+	max  int64
+	used int64
+}
+
+// take charges a request, failing once the budget is spent
+func (b *requestBudget) take() error {
+	if b.max <= 0 {
+		return nil
+	}
+	if atomic.AddInt64(&b.used, 1) > b.max {
+		return ErrRequestBudgetExhausted
+	}
+	return nil
+}
+
+// exhausted reports whether a request was refused
+func (b *requestBudget) exhausted() bool {
+	return b.max > 0 && atomic.LoadInt64(&b.used) > b.max
+}
+
+func (b *requestBudget) reset() {
+	atomic.StoreInt64(&b.used, 0)
+}
+
+// budgetTransport charges every request going through it to a budget
+type budgetTransport struct {
+	budget *requestBudget
+	base   http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.budget.take()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunsHaltAtTheRequestBudget(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 60, 40, 1)
+	var served int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&served, 1)
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for i := 0; i < 10; i++ {
+		posts = append(posts, post(fmt.Sprint(i), fmt.Sprintf("%s/%d.png", srv.URL, i)))
+	}
+	// the client of the config is kept so that the requests are charged to the budget
+	r := newTestReddit(&Config{MaxRequests: 3}, nil, posts...)
+	// one download at a time, so that none is cancelled in flight by the exhausted budget
+	r.Concurrency = 1
+
+	_, err := r.FetchSubmissionsResults()
+	if err != ErrRequestBudgetExhausted {
+		t.Fatalf("got %v, want %v", err, ErrRequestBudgetExhausted)
+	}
+	if served := atomic.LoadInt32(&served); served != 3 {
+		t.Errorf("served %d requests, want 3", served)
+	}
+}
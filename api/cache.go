@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// cacheEntry is what an image host told about an image when it was last downloaded
+type cacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Complete is set once the image was downloaded whole, a partial download may be resumed
+	Complete bool `json:"complete,omitempty"`
+}
+
+// validator identifies the version of the image for If-Range, a strong ETag or else the date
+func (e cacheEntry) validator() string {
+	if e.ETag != "" && !strings.HasPrefix(e.ETag, "W/") {
+		return e.ETag
+	}
+	return e.LastModified
+}
+
+// conditional makes req answered by 304 Not Modified when the image did not change
+func (e cacheEntry) conditional(req *http.Request) {
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+// validators returns the entry of an image from the response of its host
+func validators(resp *http.Response) cacheEntry {
+	return cacheEntry{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// httpCache keeps the entries of the images by link. A nil httpCache is empty and stays so.
+type httpCache struct {
+	// path is where the entries are saved, they are only kept for the run when empty
+	path string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// loadHTTPCache reads the entries saved at path, a missing file is an empty cache
+func loadHTTPCache(path string) (*httpCache, error) {
+	c := &httpCache{path: path, entries: map[string]cacheEntry{}}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &c.entries)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// get returns the entry of link, the zero entry when there is none
+func (c *httpCache) get(link string) cacheEntry {
+	if c == nil {
+		return cacheEntry{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[link]
+}
+
+// put replaces the entry of link, hosts without validators leave nothing to cache
+func (c *httpCache) put(link string, e cacheEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.ETag == "" && e.LastModified == "" {
+		delete(c.entries, link)
+		return
+	}
+	c.entries[link] = e
+}
+
+// complete records that the image at link was downloaded whole
+func (c *httpCache) complete(link string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[link]
+	if !ok {
+		return
+	}
+	e.Complete = true
+	c.entries[link] = e
+}
+
+// save replaces the file at path with the entries
+func (c *httpCache) save() error {
+	if c == nil || c.path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
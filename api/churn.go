@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/lucbarr/earthpornbot/logging"
+)
+
+// loadRunHashes reads the perceptual hashes saved by the previous run, none when missing
+func loadRunHashes(path string) ([]uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hexes []string
+	err = json.Unmarshal(data, &hexes)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]uint64, 0, len(hexes))
+	for _, h := range hexes {
+		hash, err := strconv.ParseUint(h, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// saveRunHashes replaces the saved hashes with the current run's
+func saveRunHashes(path string, hashes []uint64) error {
+	hexes := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		hexes = append(hexes, strconv.FormatUint(h, 16))
+	}
+
+	data, err := json.Marshal(hexes)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// churn is the share, from 0 to 1, of current images matching none of the previous ones
+func churn(previous, current []uint64, threshold int) float64 {
+	if len(current) == 0 {
+		return 0
+	}
+
+	changed := 0
+	for _, c := range current {
+		matched := false
+		for _, p := range previous {
+			if hammingDistance(c, p) <= threshold {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			changed++
+		}
+	}
+	return float64(changed) / float64(len(current))
+}
+
+// reportChurn logs how much the images changed since the previous run and saves the current ones
+func reportChurn(path string, current []uint64, threshold int, logger *logging.Logger) error {
+	previous, err := loadRunHashes(path)
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		logger.Info("churn since the previous run", "newPercent", fmt.Sprintf("%.1f", churn(previous, current, threshold)*100), "images", len(current))
+	}
+	return saveRunHashes(path, current)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/lucbarr/earthpornbot/logging"
+)
+
+func TestChurn(t *testing.T) {
+	tests := []struct {
+		name              string
+		previous, current []uint64
+		want              float64
+	}{
+		{"first run", nil, []uint64{1, 2}, 1},
+		{"same images", []uint64{1, 2}, []uint64{2, 1}, 0},
+		{"half new", []uint64{1, 2}, []uint64{1, 0xff00}, 0.5},
+		// 3 differs from 1 by a single bit
+		{"near copies", []uint64{1}, []uint64{3, 0xff00, 0xff0000, 0xff000000}, 0.75},
+		{"no images", []uint64{1}, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := churn(tt.previous, tt.current, 1); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChurnBetweenRuns(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"a.png": patternPNG(t, 90, 60, false),
+		"b.png": patternPNG(t, 90, 60, true),
+		"c.png": testPNG(t, 60, 40, 3),
+		// a bigger copy of a
+		"d.png": patternPNG(t, 120, 80, false),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	runs := [][]string{{"a", "b"}, {"c", "d"}}
+	var out bytes.Buffer
+	for _, ids := range runs {
+		var posts []*submission
+		for _, id := range ids {
+			posts = append(posts, post(id, srv.URL+"/"+id+".png"))
+		}
+		r := newTestReddit(&Config{Churn: true, ChurnFile: "churn.json", DedupeThreshold: 4}, srv, posts...)
+		logger, err := logging.New(&out, logging.Info, logging.Text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Logger = logger
+		err = r.FetchSubmissions()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reports []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "churn since the previous run") {
+			reports = append(reports, line)
+		}
+	}
+	if len(reports) != 1 || !strings.Contains(reports[0], "newPercent=50.0 images=2") {
+		t.Errorf("reported %q, want half of the second run new", reports)
+	}
+}
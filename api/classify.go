@@ -0,0 +1,280 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	imageproc "github.com/lucbarr/earthpornbot/image"
+	"github.com/lucbarr/earthpornbot/storage"
+)
+
+// ResolutionTier is a named folder for images whose longer side has at least MinLongSide pixels
+type ResolutionTier struct {
+	Name        string
+	MinLongSide int `mapstructure:"minLongSide"`
+}
+
+// sortResolutionTiers orders tiers from the largest threshold to the smallest
+func sortResolutionTiers(tiers []ResolutionTier) {
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].MinLongSide > tiers[j].MinLongSide
+	})
+}
+
+// resolutionTier returns the first tier the image fits in, tiers must be sorted.
+// Images smaller than every tier go to "sub-<smallest tier>".
+func resolutionTier(tiers []ResolutionTier, width, height int) string {
+	longSide := width
+	if height > longSide {
+		longSide = height
+	}
+
+	for _, tier := range tiers {
+		if longSide >= tier.MinLongSide {
+			return tier.Name
+		}
+	}
+	return "sub-" + tiers[len(tiers)-1].Name
+}
+
+// DisplayAspect is a display aspect ratio images can be routed to, Name is like "16x9"
+type DisplayAspect struct {
+	Name  string
+	Ratio float64
+}
+
+// parseDisplayAspect parses a "<width>x<height>" aspect such as "21x9"
+func parseDisplayAspect(name string) (DisplayAspect, error) {
+	parts := strings.Split(name, "x")
+	if len(parts) != 2 {
+		return DisplayAspect{}, fmt.Errorf("invalid display aspect %q, expected <width>x<height>", name)
+	}
+	w, errW := strconv.ParseFloat(parts[0], 64)
+	h, errH := strconv.ParseFloat(parts[1], 64)
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return DisplayAspect{}, fmt.Errorf("invalid display aspect %q, expected <width>x<height>", name)
+	}
+	return DisplayAspect{Name: name, Ratio: w / h}, nil
+}
+
+// displayAspectFolder returns the aspect nearest to ratio, or "other" when none is within tolerance
+func displayAspectFolder(aspects []DisplayAspect, tolerance, ratio float64) string {
+	folder := "other"
+	best := math.Inf(1)
+	for _, aspect := range aspects {
+		diff := math.Abs(aspect.Ratio - ratio)
+		if diff <= tolerance && diff < best {
+			folder = aspect.Name
+			best = diff
+		}
+	}
+	return folder
+}
+
+// Resolution is an exact image size, such as a display resolution
+type Resolution = imageproc.Resolution
+
+// matchesResolution reports whether an image has exactly one of the resolutions,
+// in either orientation
+func matchesResolution(resolutions []Resolution, width, height int) bool {
+	for _, res := range resolutions {
+		if (res.Width == width && res.Height == height) || (res.Width == height && res.Height == width) {
+			return true
+		}
+	}
+	return false
+}
+
+// errSameContent is returned by place when an image with the same content hash was already saved
+var errSameContent = errors.New("same content as an already saved image")
+
+// animatedDir holds the animated images
+const animatedDir = "animated"
+
+// placement is where the classification put an image
+type placement struct {
+	path        string
+	orientation string
+	aspectRatio float64
+	// tier is empty when the resolution tiers are disabled
+	tier string
+}
+
+// place classifies the image at src and moves it into its folder as filename, the quota
+// is only taken by an image that made it there
+func (r *Reddit) place(src, filename string, codec imageCodec, width, height int) (*placement, error) {
+	p, err := r.classify(src, filename, codec, width, height)
+	if err != nil {
+		return nil, err
+	}
+	err = r.rename(src, p.path)
+	if err != nil {
+		return nil, err
+	}
+	if r.quota != nil && !r.quota.take(p.orientation) {
+		os.Remove(p.path)
+		return nil, errQuotaFull
+	}
+	err = r.upload(p.path)
+	if err != nil {
+		if r.quota != nil {
+			r.quota.release(p.orientation)
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// classify returns where the image at src goes as filename. Without src, as in dry runs, the
+// steps reading the file are skipped.
+func (r *Reddit) classify(src, filename string, codec imageCodec, width, height int) (*placement, error) {
+	classifyWidth, classifyHeight := width, height
+	if src != "" && r.cfg.UseExifCrop && codec == JPEG {
+		classifyWidth, classifyHeight = croppedDimensions(src, width, height)
+	}
+	if src != "" && r.cfg.ContentAware {
+		var err error
+		classifyWidth, classifyHeight, err = contentDimensions(src, classifyWidth, classifyHeight)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := &placement{aspectRatio: float64(classifyWidth) / float64(classifyHeight)}
+	if p.aspectRatio > 1.0 {
+		p.orientation = "hori"
+	} else {
+		p.orientation = "vert"
+	}
+
+	dir := p.orientation
+	if len(r.cfg.DisplayAspects) > 0 {
+		dir = displayAspectFolder(r.cfg.DisplayAspects, r.cfg.DisplayAspectTolerance, p.aspectRatio)
+	}
+	if src != "" && r.cfg.ColorTemperature {
+		temperature, err := colorTemperature(src)
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(dir, temperature)
+	}
+	if len(r.cfg.ResolutionTiers) > 0 {
+		p.tier = resolutionTier(r.cfg.ResolutionTiers, width, height)
+		dir = filepath.Join(dir, p.tier)
+	}
+
+	if src != "" && r.cfg.DetectHDR {
+		hdr, err := isHDR(src, codec)
+		if err != nil {
+			return nil, err
+		}
+		if hdr {
+			dir = filepath.Join(hdrDir, dir)
+		}
+	}
+
+	if src != "" && r.cfg.Animated && codec == GIF {
+		animated, err := isAnimatedGIF(src)
+		if err != nil {
+			return nil, err
+		}
+		if animated && r.cfg.AnimatedByOrientation {
+			dir = filepath.Join(animatedDir, dir)
+		} else if animated {
+			dir = animatedDir
+		}
+	}
+	p.path = r.outputPath(dir, filename)
+
+	// names derived from the content are the same for identical images
+	if r.cfg.HashNames {
+		_, err := os.Stat(p.path)
+		if err == nil {
+			return nil, errSameContent
+		}
+		if r.output != nil {
+			stored, err := r.output.Exists(filepath.ToSlash(p.path))
+			if err != nil {
+				return nil, err
+			}
+			if stored {
+				return nil, errSameContent
+			}
+		}
+	}
+	// a dry run moves nothing, its images take the quota as soon as they are classified
+	if src == "" && r.quota != nil && !r.quota.take(p.orientation) {
+		return nil, errQuotaFull
+	}
+	return p, nil
+}
+
+// move renames src to path, then copies it to the output storage and the mirrors
+func (r *Reddit) move(src, path string) error {
+	err := r.rename(src, path)
+	if err != nil {
+		return err
+	}
+	return r.upload(path)
+}
+
+// rename moves src to path. Names derived from the content are claimed before, identical
+// images downloaded at once race for theirs and all but the first get errSameContent.
+func (r *Reddit) rename(src, path string) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if r.cfg.HashNames {
+		claim, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			return errSameContent
+		}
+		if err != nil {
+			return err
+		}
+		claim.Close()
+	}
+	err = os.Rename(src, path)
+	if err != nil && r.cfg.HashNames {
+		os.Remove(path)
+	}
+	return err
+}
+
+// upload copies the image at path to the output storage and the mirrors, it is removed
+// when a copy fails, and once copied when it is kept in the output storage only
+func (r *Reddit) upload(path string) error {
+	for _, s := range []storage.Backend{r.output, r.mirrors} {
+		if s == nil {
+			continue
+		}
+		err := mirror(s, path)
+		if err != nil {
+			os.Remove(path)
+			return err
+		}
+	}
+	r.dropLocalCopy(path)
+	return nil
+}
+
+// dropLocalCopy removes the file at path, once stored, unless the local copies are kept
+func (r *Reddit) dropLocalCopy(path string) {
+	if r.output != nil && !r.keepLocal {
+		os.Remove(path)
+	}
+}
+
+// withinAspect reports whether the aspect ratio of an image is between min and max,
+// a zero bound is unbounded
+func withinAspect(width, height int, min, max float64) bool {
+	ratio := float64(width) / float64(height)
+	return (min <= 0 || ratio >= min) && (max <= 0 || ratio <= max)
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lucbarr/earthpornbot/storage"
+	"github.com/lucbarr/earthpornbot/store"
+)
+
+// failingBackend refuses every object
+type failingBackend struct{}
+
+func (failingBackend) Put(key string, src io.ReadSeeker) error      { return errors.New("unavailable") }
+func (failingBackend) Exists(key string) (bool, error)              { return false, nil }
+func (failingBackend) Open(key string) (io.ReadCloser, error)       { return nil, storage.ErrNotFound }
+func (failingBackend) List(prefix string) ([]storage.Object, error) { return nil, nil }
+func (failingBackend) Delete(key string) error                      { return nil }
+
+func TestRenameClaimsHashNames(t *testing.T) {
+	defer inTempDir(t)()
+	r := NewRedditFromConfig(&Config{HashNames: true})
+	r.Logger = nil
+	for _, name := range []string{"a.png", "b.png"} {
+		err := ioutil.WriteFile(name, []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// both copies went past the check of classify before either was moved
+	err := r.rename("a.png", "hori/0123.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.rename("b.png", "hori/0123.png")
+	if err != errSameContent {
+		t.Fatalf("got %v for the second copy, want errSameContent", err)
+	}
+	data, err := ioutil.ReadFile("hori/0123.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a.png" {
+		t.Errorf("the first copy was replaced by %q", data)
+	}
+}
+
+func TestPlaceTakesTheQuotaOnceMoved(t *testing.T) {
+	defer inTempDir(t)()
+	r := NewRedditFromConfig(&Config{HorizontalLimit: 1})
+	r.Logger = nil
+	r.current = r.subreddits()[0]
+	r.quota = newOrientationQuota(1, 0)
+	r.mirrors = failingBackend{}
+	err := ioutil.WriteFile("a.png", testPNG(t, 30, 20, 1), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.place("a.png", "a.png", PNG, 30, 20)
+	if err == nil {
+		t.Fatal("placed an image its mirror refused")
+	}
+	r.mirrors = nil
+	err = ioutil.WriteFile("b.png", testPNG(t, 30, 20, 2), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.place("b.png", "b.png", PNG, 30, 20)
+	if err != nil {
+		t.Fatalf("the failed image kept its slot: %v", err)
+	}
+}
+
+func TestImagesLandInTheirResolutionTier(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"big":   testPNG(t, 80, 50, 1),
+		"tall":  testPNG(t, 30, 80, 1),
+		"mid":   testPNG(t, 50, 30, 1),
+		"small": testPNG(t, 30, 20, 1),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[strings.TrimSuffix(path.Base(req.URL.Path), ".png")])
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for name := range images {
+		posts = append(posts, post(name, srv.URL+"/"+name+".png"))
+	}
+	r := newTestReddit(&Config{ResolutionTiers: []ResolutionTier{{"4k", 60}, {"1080p", 40}}}, srv, posts...)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"big":   filepath.Join("hori", "4k", "big.png"),
+		"tall":  filepath.Join("vert", "4k", "tall.png"),
+		"mid":   filepath.Join("hori", "1080p", "mid.png"),
+		"small": filepath.Join("hori", "sub-1080p", "small.png"),
+	}
+	if len(saved) != len(want) {
+		t.Fatalf("saved %+v, want %d images", saved, len(want))
+	}
+	for _, d := range saved {
+		name := strings.TrimSuffix(filepath.Base(d.Path), ".png")
+		if d.Path != want[name] {
+			t.Errorf("%s saved to %s, want %s", name, d.Path, want[name])
+		}
+	}
+}
+
+func TestParseDisplayAspect(t *testing.T) {
+	tests := []struct {
+		name  string
+		ratio float64
+		ok    bool
+	}{
+		{"16x9", 16.0 / 9, true},
+		{"9x16", 9.0 / 16, true},
+		{"21x9", 21.0 / 9, true},
+		{"2.39x1", 2.39, true},
+		{"16:9", 0, false},
+		{"16x0", 0, false},
+		{"x9", 0, false},
+	}
+	for _, tt := range tests {
+		aspect, err := parseDisplayAspect(tt.name)
+		if (err == nil) != tt.ok || aspect.Ratio != tt.ratio {
+			t.Errorf("parseDisplayAspect(%q) = %+v, %v, want ratio %v and ok %v", tt.name, aspect, err, tt.ratio, tt.ok)
+		}
+	}
+}
+
+func TestDisplayAspectFolders(t *testing.T) {
+	var aspects []DisplayAspect
+	for _, name := range []string{"16x9", "9x16", "21x9", "16x10"} {
+		aspect, err := parseDisplayAspect(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aspects = append(aspects, aspect)
+	}
+	tests := []struct {
+		width, height int
+		folder        string
+	}{
+		{1920, 1080, "16x9"},
+		{1080, 1920, "9x16"},
+		{3440, 1440, "21x9"},
+		{1920, 1200, "16x10"},
+		// between 16x9 and 16x10, nearer the latter
+		{1700, 1040, "16x10"},
+		{1000, 1000, "other"},
+		{6000, 1000, "other"},
+	}
+	for _, tt := range tests {
+		ratio := float64(tt.width) / float64(tt.height)
+		if got := displayAspectFolder(aspects, 0.1, ratio); got != tt.folder {
+			t.Errorf("%dx%d went to %s, want %s", tt.width, tt.height, got, tt.folder)
+		}
+	}
+}
+
+func TestExactResolutions(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"match":   testPNG(t, 64, 36, 1),
+		"rotated": testPNG(t, 36, 64, 1),
+		"other":   testPNG(t, 64, 40, 1),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[strings.TrimSuffix(path.Base(req.URL.Path), ".png")])
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{ExactResolutions: []Resolution{{Width: 64, Height: 36}, {Width: 32, Height: 18}}}, srv,
+		post("match", srv.URL+"/match.png"),
+		post("rotated", srv.URL+"/rotated.png"),
+		post("other", srv.URL+"/other.png"),
+	)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, d := range saved {
+		got[filepath.Base(d.Path)] = true
+	}
+	if len(got) != 2 || !got["match.png"] || !got["rotated.png"] {
+		t.Errorf("saved %v, want the matching images in either orientation", got)
+	}
+	if _, err := os.Stat("other.png"); !os.IsNotExist(err) {
+		t.Errorf("the rejected image is left: %v", err)
+	}
+}
+
+func TestExtremeAspectsAreSkipped(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"landscape": testPNG(t, 60, 40, 1),
+		"portrait":  testPNG(t, 40, 60, 1),
+		"panorama":  testPNG(t, 200, 20, 1),
+		"infograph": testPNG(t, 20, 120, 1),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[strings.TrimSuffix(path.Base(req.URL.Path), ".png")])
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for name := range images {
+		posts = append(posts, post(name, srv.URL+"/"+name+".png"))
+	}
+	r := newTestReddit(&Config{SanityAspectMin: 0.4, SanityAspectMax: 3, Store: "store.jsonl"}, srv, posts...)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, d := range saved {
+		got[filepath.Base(d.Path)] = true
+	}
+	if len(got) != 2 || !got["landscape.png"] || !got["portrait.png"] {
+		t.Errorf("saved %v, want the landscape and the portrait", got)
+	}
+
+	s, err := store.OpenFile("store.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, id := range []string{"panorama", "infograph"} {
+		rec, err := s.Get(id)
+		if err != nil || rec.Skipped != "not a wallpaper" {
+			t.Errorf("%s recorded as %+v, %v, want skipped as not a wallpaper", id, rec, err)
+		}
+	}
+}
+
+func TestWithinAspect(t *testing.T) {
+	tests := []struct {
+		width, height int
+		min, max      float64
+		within        bool
+	}{
+		{60, 40, 0, 0, true},
+		{300, 10, 0, 0, true},
+		{300, 10, 0, 3, false},
+		{30, 10, 0, 3, true},
+		{10, 30, 0.4, 0, false},
+		{20, 40, 0.4, 3, true},
+	}
+	for _, tt := range tests {
+		if got := withinAspect(tt.width, tt.height, tt.min, tt.max); got != tt.within {
+			t.Errorf("%dx%d within [%v, %v]: got %v, want %v", tt.width, tt.height, tt.min, tt.max, got, tt.within)
+		}
+	}
+}
+
+func TestOrientationLimits(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{}
+	var posts []*submission
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("%d.png", i)
+		if i%2 == 0 {
+			images[name] = testPNG(t, 60, 40, byte(i))
+		} else {
+			images[name] = testPNG(t, 40, 60, byte(i))
+		}
+		posts = append(posts, post(fmt.Sprint(i), "/"+name))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+	for _, p := range posts {
+		p.URL = srv.URL + p.URL
+	}
+
+	r := newTestReddit(&Config{HorizontalLimit: 3, VerticalLimit: 2}, srv, posts...)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := map[string]int{}
+	for _, d := range saved {
+		count[d.Orientation]++
+	}
+	if count["hori"] != 3 || count["vert"] != 2 {
+		t.Errorf("saved %v, want 3 hori and 2 vert", count)
+	}
+	for dir, want := range map[string]int{"hori": 3, "vert": 2} {
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != want {
+			t.Errorf("%s holds %v, want %d images", dir, files, want)
+		}
+	}
+}
+
+func TestHashNamesStoreIdenticalContentOnce(t *testing.T) {
+	defer inTempDir(t)()
+	same, other := testPNG(t, 60, 40, 1), testPNG(t, 60, 40, 2)
+	images := map[string][]byte{"a.png": same, "b.png": same, "c.png": other}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{HashNames: true}, srv,
+		post("a", srv.URL+"/a.png"), post("b", srv.URL+"/b.png"), post("c", srv.URL+"/c.png"))
+	_, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join("hori", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, data := range [][]byte{same, other} {
+		want = append(want, filepath.Join("hori", fmt.Sprintf("%x.png", sha256.Sum256(data))))
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("saved %v, want %v", files, want)
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import "time"
+
+// Clock tells the time to the time dependent features, so they can run at a fixed time
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the wall clock
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// redditClock tells the time of the Clock of r as it is when asked, so the clients created
+// along with r follow when it is replaced
+type redditClock struct {
+	r *Reddit
+}
+
+func (c redditClock) Now() time.Time {
+	return c.r.Clock.Now()
+}
+
+func (c redditClock) After(d time.Duration) <-chan time.Time {
+	return c.r.Clock.After(d)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedClock is always at the same time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// After fires at once for d <= 0 only, as the time never comes otherwise
+func (c fixedClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- time.Time(c)
+	}
+	return ch
+}
+
+func TestMaxAgeFollowsTheClock(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	recent, old := post("recent", "https://i.redd.it/recent.png"), post("old", "https://i.redd.it/old.png")
+	recent.DateCreated = float64(now.Add(-time.Hour).Unix())
+	old.DateCreated = float64(now.Add(-3 * 24 * time.Hour).Unix())
+
+	r := NewRedditFromConfig(&Config{Limit: 10, Sort: HotSubmissions, MaxAge: 24 * time.Hour, AllowedExtensions: []string{"png"}})
+	r.Logger = nil
+	r.Clock = fixedClock(now)
+	r.API = fakeAPI{[]*submission{recent, old}}
+	r.current = r.subreddits()[0]
+
+	out := make(chan *submission, 2)
+	_, err := r.listSubmissions(context.Background(), out, make(chan struct{}), newListingProgress(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var listed []string
+	for p := range out {
+		listed = append(listed, p.ID)
+	}
+	if len(listed) != 1 || listed[0] != "recent" {
+		t.Errorf("listed %v, want the submission of the last day", listed)
+	}
+}
+
+func TestTwitterSignaturesFollowTheClock(t *testing.T) {
+	r := NewRedditFromConfig(&Config{Twitter: TwitterConfig{ConsumerKey: "key", AccessToken: "token"}})
+	r.Logger = nil
+	r.Clock = fixedClock(time.Unix(1500000000, 0))
+	req, err := http.NewRequest(http.MethodPost, twitterTweetURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.twitterPublisher().(twitterPublisher).signer.sign(req)
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth, `oauth_timestamp="1500000000"`) {
+		t.Errorf("signed %s", auth)
+	}
+}
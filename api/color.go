@@ -0,0 +1,91 @@
+package api
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"os"
+)
+
+// colorSamples is roughly how many pixels are sampled looking for the dominant color
+const colorSamples = 64 * 64
+
+// neutralSaturation is the saturation under which the dominant color counts as neutral
+const neutralSaturation = 0.2
+
+// colorTemperature returns "warm", "cool" or "neutral" from the hue of the dominant color
+// of the image at filename
+func colorTemperature(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", err
+	}
+	return temperatureOf(dominantColor(img)), nil
+}
+
+// dominantColor averages the most populated bucket of a coarse color histogram
+func dominantColor(img image.Image) color.RGBA {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	var buckets [16 * 16 * 16]bucket
+
+	bounds := img.Bounds()
+	step := max1(int(math.Sqrt(float64(bounds.Dx()*bounds.Dy()) / colorSamples)))
+	best := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			i := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			b := &buckets[i]
+			b.count++
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+			if b.count > buckets[best].count {
+				best = i
+			}
+		}
+	}
+
+	b := buckets[best]
+	if b.count == 0 {
+		return color.RGBA{}
+	}
+	return color.RGBA{uint8(b.r / b.count), uint8(b.g / b.count), uint8(b.b / b.count), 0xff}
+}
+
+// temperatureOf buckets a color by its hue, reds to yellows are warm and greens to blues cool
+func temperatureOf(c color.RGBA) string {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	hi := math.Max(r, math.Max(g, b))
+	lo := math.Min(r, math.Min(g, b))
+	if hi == 0 || (hi-lo)/hi < neutralSaturation {
+		return "neutral"
+	}
+
+	var hue float64
+	switch hi {
+	case r:
+		hue = math.Mod((g-b)/(hi-lo), 6) * 60
+	case g:
+		hue = ((b-r)/(hi-lo) + 2) * 60
+	default:
+		hue = ((r-g)/(hi-lo) + 4) * 60
+	}
+	if hue < 0 {
+		hue += 360
+	}
+
+	if hue < 75 || hue >= 300 {
+		return "warm"
+	}
+	return "cool"
+}
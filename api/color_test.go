@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// solidPNG is a width x height PNG filled with c
+func solidPNG(t *testing.T, width, height int, c color.RGBA) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTemperatureOf(t *testing.T) {
+	tests := []struct {
+		c    color.RGBA
+		want string
+	}{
+		{color.RGBA{0xe0, 0x70, 0x20, 0xff}, "warm"},
+		{color.RGBA{0xd0, 0xc0, 0x30, 0xff}, "warm"},
+		{color.RGBA{0xc0, 0x20, 0x80, 0xff}, "warm"},
+		{color.RGBA{0x20, 0x60, 0xd0, 0xff}, "cool"},
+		{color.RGBA{0x30, 0xa0, 0x40, 0xff}, "cool"},
+		{color.RGBA{0x80, 0x80, 0x80, 0xff}, "neutral"},
+		{color.RGBA{0x90, 0x88, 0x80, 0xff}, "neutral"},
+		{color.RGBA{0, 0, 0, 0xff}, "neutral"},
+	}
+	for _, tt := range tests {
+		if got := temperatureOf(tt.c); got != tt.want {
+			t.Errorf("temperatureOf(%v) = %s, want %s", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestColorTemperatureComposesWithTheOrientation(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"sunset.png":  solidPNG(t, 60, 40, color.RGBA{0xe0, 0x70, 0x20, 0xff}),
+		"glacier.png": solidPNG(t, 40, 60, color.RGBA{0x20, 0x60, 0xd0, 0xff}),
+		"fog.png":     solidPNG(t, 60, 40, color.RGBA{0x80, 0x80, 0x80, 0xff}),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{ColorTemperature: true}, srv,
+		post("sunset", srv.URL+"/sunset.png"), post("glacier", srv.URL+"/glacier.png"), post("fog", srv.URL+"/fog.png"))
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"sunset.png":  filepath.Join("hori", "warm", "sunset.png"),
+		"glacier.png": filepath.Join("vert", "cool", "glacier.png"),
+		"fog.png":     filepath.Join("hori", "neutral", "fog.png"),
+	}
+	if len(saved) != len(want) {
+		t.Fatalf("saved %+v, want every image", saved)
+	}
+	for _, d := range saved {
+		if name := filepath.Base(d.Path); d.Path != want[name] {
+			t.Errorf("%s saved to %s, want %s", name, d.Path, want[name])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"image"
+	"os"
+)
+
+// contentGrid is the size of the long side of the downscaled luma grid used for content analysis
+const contentGrid = 64
+
+// contentDetailRatio is the share of the busiest line variance under which a line counts as border
+const contentDetailRatio = 0.05
+
+// contentDimensions returns the dimensions of the region where the image detail is, ignoring
+// uniform borders such as letterboxing, or the full dimensions when the whole image is uniform
+func contentDimensions(filename string, width, height int) (int, int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	b := img.Bounds()
+	cols, rows := contentGrid, contentGrid
+	if b.Dx() > b.Dy() {
+		rows = max1(contentGrid * b.Dy() / b.Dx())
+	} else {
+		cols = max1(contentGrid * b.Dx() / b.Dy())
+	}
+
+	grid := make([][]float64, rows)
+	for y := range grid {
+		grid[y] = make([]float64, cols)
+		for x := range grid[y] {
+			grid[y][x] = averageLuma(img, image.Rect(
+				b.Min.X+x*b.Dx()/cols,
+				b.Min.Y+y*b.Dy()/rows,
+				b.Min.X+(x+1)*b.Dx()/cols,
+				b.Min.Y+(y+1)*b.Dy()/rows,
+			))
+		}
+	}
+
+	rowVar := make([]float64, rows)
+	for y := 0; y < rows; y++ {
+		rowVar[y] = variance(cols, func(i int) float64 { return grid[y][i] })
+	}
+	colVar := make([]float64, cols)
+	for x := 0; x < cols; x++ {
+		colVar[x] = variance(rows, func(i int) float64 { return grid[i][x] })
+	}
+
+	top, bottom, okRows := detailSpan(rowVar)
+	left, right, okCols := detailSpan(colVar)
+	if !okRows || !okCols {
+		return width, height, nil
+	}
+
+	return width * (right - left + 1) / cols, height * (bottom - top + 1) / rows, nil
+}
+
+// detailSpan returns the first and last lines whose variance stands out of the borders
+func detailSpan(vars []float64) (int, int, bool) {
+	var peak float64
+	for _, v := range vars {
+		if v > peak {
+			peak = v
+		}
+	}
+	if peak == 0 {
+		return 0, 0, false
+	}
+
+	first, last := -1, -1
+	for i, v := range vars {
+		if v >= peak*contentDetailRatio {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	return first, last, true
+}
+
+func variance(n int, at func(int) float64) float64 {
+	var sum, sumSq float64
+	for i := 0; i < n; i++ {
+		v := at(i)
+		sum += v
+		sumSq += v * v
+	}
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
+
+func max1(v int) int {
+	if v < 1 {
+		return 1
+	}
+	return v
+}
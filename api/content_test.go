@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// pillarboxedPNG encodes a width x height image black but for a noisy band of band pixels
+// in the middle
+func pillarboxedPNG(t *testing.T, width, height, band int) []byte {
+	t.Helper()
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	left := (width - band) / 2
+	for y := 0; y < height; y++ {
+		for x := left; x < left+band; x++ {
+			img.SetGray(x, y, color.Gray{uint8(rnd.Intn(256))})
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestContentAwareOrientation(t *testing.T) {
+	images := map[string][]byte{
+		"pillarboxed.png": pillarboxedPNG(t, 240, 160, 80),
+		"uniform.png":     pillarboxedPNG(t, 240, 160, 0),
+		"full.png":        pillarboxedPNG(t, 240, 160, 240),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		contentAware bool
+		want         map[string]string
+	}{
+		{false, map[string]string{"pillarboxed.png": "hori", "uniform.png": "hori", "full.png": "hori"}},
+		{true, map[string]string{"pillarboxed.png": "vert", "uniform.png": "hori", "full.png": "hori"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("content aware %v", tt.contentAware), func(t *testing.T) {
+			defer inTempDir(t)()
+			var posts []*submission
+			for name := range images {
+				posts = append(posts, post(name, srv.URL+"/"+name))
+			}
+			r := newTestReddit(&Config{ContentAware: tt.contentAware}, srv, posts...)
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != len(images) {
+				t.Fatalf("saved %+v, want every image", saved)
+			}
+			for _, d := range saved {
+				name := filepath.Base(d.Path)
+				if d.Path != filepath.Join(tt.want[name], name) {
+					t.Errorf("%s saved to %s, want %s", name, d.Path, tt.want[name])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// xmpHeader prefixes the XMP packet inside a JPEG APP1 segment
+var xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// xmpCropField matches crop settings left by raw editors, as attributes or elements
+var xmpCropField = regexp.MustCompile(`crs:(HasCrop|CropTop|CropLeft|CropBottom|CropRight)(?:="([^"]*)"|>([^<]*)<)`)
+
+// croppedDimensions returns the dimensions of the intended crop declared in the XMP
+// metadata of a JPEG, or the full dimensions when there is none
+func croppedDimensions(filename string, width, height int) (int, int) {
+	packet, err := readXMP(filename)
+	if err != nil || packet == nil {
+		return width, height
+	}
+
+	fields := map[string]string{}
+	for _, m := range xmpCropField.FindAllSubmatch(packet, -1) {
+		value := m[2]
+		if len(value) == 0 {
+			value = m[3]
+		}
+		fields[string(m[1])] = string(value)
+	}
+	if fields["HasCrop"] != "True" && fields["HasCrop"] != "true" {
+		return width, height
+	}
+
+	edge := func(name string, fallback float64) float64 {
+		v, err := strconv.ParseFloat(fields[name], 64)
+		if err != nil || v < 0 || v > 1 {
+			return fallback
+		}
+		return v
+	}
+	left, right := edge("CropLeft", 0), edge("CropRight", 1)
+	top, bottom := edge("CropTop", 0), edge("CropBottom", 1)
+	if right <= left || bottom <= top {
+		return width, height
+	}
+
+	return int(float64(width) * (right - left)), int(float64(height) * (bottom - top))
+}
+
+// readXMP walks the JPEG segments until the image data and returns the XMP packet, if any
+func readXMP(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	var soi [2]byte
+	_, err = io.ReadFull(br, soi[:])
+	if err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, err
+	}
+
+	for {
+		var marker [4]byte
+		_, err = io.ReadFull(br, marker[:])
+		if err != nil {
+			return nil, err
+		}
+		// start of scan, the metadata segments are all before it
+		if marker[0] != 0xFF || marker[1] == 0xDA {
+			return nil, nil
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, nil
+		}
+		segment := make([]byte, length)
+		_, err = io.ReadFull(br, segment)
+		if err != nil {
+			return nil, err
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, xmpHeader) {
+			return segment[len(xmpHeader):], nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// croppedJPEG encodes a width x height JPEG whose XMP declares the crop packet
+func croppedJPEG(t *testing.T, width, height int, packet string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := buf.Bytes()
+
+	segment := append(append([]byte(nil), xmpHeader...), packet...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	// right after the start of image marker
+	return append(append(append([]byte{0xFF, 0xD8}, app1...), segment...), img[2:]...)
+}
+
+func TestExifCropDecidesTheOrientation(t *testing.T) {
+	const packet = `<x:xmpmeta><rdf:Description crs:HasCrop="True" crs:CropLeft="0.3" crs:CropRight="0.7">` +
+		`<crs:CropTop>0</crs:CropTop><crs:CropBottom>1</crs:CropBottom></rdf:Description></x:xmpmeta>`
+	tests := []struct {
+		name   string
+		packet string
+		crop   bool
+		path   string
+	}{
+		{"cropped portrait", packet, true, filepath.Join("vert", "a.jpg")},
+		{"crop disabled", packet, false, filepath.Join("hori", "a.jpg")},
+		{"no crop", `<x:xmpmeta crs:HasCrop="False" crs:CropLeft="0.3" crs:CropRight="0.7"/>`, true, filepath.Join("hori", "a.jpg")},
+		{"invalid crop", `<x:xmpmeta crs:HasCrop="True" crs:CropLeft="0.7" crs:CropRight="0.3"/>`, true, filepath.Join("hori", "a.jpg")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			img := croppedJPEG(t, 60, 40, tt.packet)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write(img)
+			}))
+			defer srv.Close()
+
+			r := newTestReddit(&Config{UseExifCrop: tt.crop, AllowedExtensions: []string{"jpg"}}, srv,
+				post("a", srv.URL+"/a.jpg"))
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 1 || saved[0].Path != tt.path {
+				t.Errorf("saved %+v, want %s", saved, tt.path)
+			}
+		})
+	}
+}
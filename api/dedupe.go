@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// duplicate is an image perceptually similar to an earlier one
+type duplicate struct {
+	URL string `json:"url"`
+	// SimilarTo is the path, or the link when downloaded by the same run, of the earlier image
+	SimilarTo string `json:"similarTo"`
+	// Distance is the number of differing bits between the two hashes
+	Distance int `json:"distance"`
+	// Kept tells the image was saved anyway
+	Kept bool `json:"kept,omitempty"`
+}
+
+// loadStoredHashes adds the perceptual hashes of the store, if any, to idx
+func (r *Reddit) loadStoredHashes(idx *index) error {
+	if r.records == nil {
+		return nil
+	}
+	records, err := r.records.Records()
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		err = idx.addHash(rec.PHash, rec.Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDuplicates replaces the report at path with the duplicates of the run
+func writeDuplicates(path string, duplicates []duplicate) error {
+	if duplicates == nil {
+		duplicates = []duplicate{}
+	}
+	data, err := json.MarshalIndent(duplicates, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
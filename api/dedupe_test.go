@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lucbarr/earthpornbot/store"
+)
+
+// patternPNG encodes a pattern of gray blocks scaled to width x height, so sizes of the same
+// pattern hash alike, mirrored flips it left to right
+func patternPNG(t *testing.T, width, height int, mirrored bool) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			bx, by := x*9/width, y*8/height
+			if mirrored {
+				bx = 8 - bx
+			}
+			img.SetGray(x, y, color.Gray{uint8((bx*37 + by*53) * 7 % 256)})
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNearDuplicatesOfEarlierRunsAreSkipped(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"original.png": patternPNG(t, 90, 60, false),
+		"copy.png":     patternPNG(t, 120, 80, false),
+		"other.png":    patternPNG(t, 90, 60, true),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	cfg := func() *Config {
+		return &Config{Dedupe: true, DedupeThreshold: 4, Index: "index.jsonl", DedupeReport: "duplicates.json"}
+	}
+	saved, err := newTestReddit(cfg(), srv, post("original", srv.URL+"/original.png")).FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 {
+		t.Fatalf("the first run saved %+v, want the original", saved)
+	}
+
+	saved, err = newTestReddit(cfg(), srv,
+		post("copy", srv.URL+"/copy.png"),
+		post("other", srv.URL+"/other.png"),
+	).FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || filepath.Base(saved[0].Path) != "other.png" {
+		t.Errorf("the second run saved %+v, want only the other image", saved)
+	}
+
+	data, err := ioutil.ReadFile("duplicates.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var duplicates []duplicate
+	err = json.Unmarshal(data, &duplicates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 1 || duplicates[0].URL != srv.URL+"/copy.png" ||
+		duplicates[0].SimilarTo != filepath.Join("hori", "original.png") {
+		t.Errorf("reported %+v, want the copy of hori/original.png", duplicates)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		// saved are the images saved, reported those reported, with whether they were kept
+		saved    []string
+		reported map[string]bool
+	}{
+		{"skipped", Config{DedupeThreshold: 4}, []string{"original.png", "other.png"}, map[string]bool{"copy.png": false}},
+		{"kept", Config{DedupeThreshold: 4, DedupeKeep: true}, []string{"copy.png", "original.png", "other.png"}, map[string]bool{"copy.png": true}},
+		{"exact threshold", Config{DedupeThreshold: 0}, []string{"original.png", "other.png"}, map[string]bool{"copy.png": false}},
+		{"mirrors within the threshold", Config{DedupeThreshold: 64}, []string{"original.png"}, map[string]bool{"copy.png": false, "other.png": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			images := map[string][]byte{
+				"original.png": patternPNG(t, 90, 60, false),
+				"copy.png":     patternPNG(t, 90, 60, false),
+				"other.png":    patternPNG(t, 90, 60, true),
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write(images[path.Base(req.URL.Path)])
+			}))
+			defer srv.Close()
+
+			cfg := tt.cfg
+			cfg.Dedupe, cfg.DedupeReport = true, "duplicates.json"
+			r := newTestReddit(&cfg, srv,
+				post("original", srv.URL+"/original.png"),
+				post("copy", srv.URL+"/copy.png"),
+				post("other", srv.URL+"/other.png"),
+			)
+			// in the listing order, the original is claimed first
+			r.Concurrency = 1
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, s := range saved {
+				names = append(names, filepath.Base(s.Path))
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.saved) {
+				t.Errorf("saved %v, want %v", names, tt.saved)
+			}
+
+			data, err := ioutil.ReadFile("duplicates.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var duplicates []duplicate
+			err = json.Unmarshal(data, &duplicates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reported := map[string]bool{}
+			for _, d := range duplicates {
+				if d.SimilarTo != srv.URL+"/original.png" {
+					t.Errorf("%s is reported similar to %s, want the link of the original", d.URL, d.SimilarTo)
+				}
+				reported[path.Base(d.URL)] = d.Kept
+			}
+			if !reflect.DeepEqual(reported, tt.reported) {
+				t.Errorf("reported %v, want %v", reported, tt.reported)
+			}
+		})
+	}
+}
+
+func TestHashesAreKeptInTheStore(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"original.png": patternPNG(t, 90, 60, false),
+		"copy.png":     patternPNG(t, 120, 80, false),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	// without an index, the earlier images are known from the store alone
+	cfg := func() *Config {
+		return &Config{Dedupe: true, DedupeThreshold: 4, Store: "store.jsonl"}
+	}
+	saved, err := newTestReddit(cfg(), srv, post("original", srv.URL+"/original.png")).FetchSubmissionsResults()
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("the first run saved %+v, %v, want the original", saved, err)
+	}
+	s, err := store.OpenFile("store.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := s.Get("original")
+	s.Close()
+	if err != nil || rec.PHash == "" {
+		t.Fatalf("stored %+v, %v, want the perceptual hash", rec, err)
+	}
+
+	saved, err = newTestReddit(cfg(), srv, post("copy", srv.URL+"/copy.png")).FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 0 {
+		t.Errorf("the second run saved %+v, want the copy skipped", saved)
+	}
+}
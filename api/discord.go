@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// postBestToDiscord posts to the webhook the download of the highest scored submission Discord
+// accepts the size of, nothing when there is none. The images only in the output storage are
+// read from there.
+func (r *Reddit) postBestToDiscord(ctx context.Context, downloads []download) error {
+	byScore := append([]download(nil), downloads...)
+	sort.SliceStable(byScore, func(i, j int) bool {
+		return byScore[i].submission.Score > byScore[j].submission.Score
+	})
+	for _, d := range byScore {
+		size, err := r.imageSize(d.path)
+		if err != nil {
+			return err
+		}
+		if size > maxDiscordImageBytes {
+			r.Logger.Info("skipping image", "path", d.path, "publisher", "discord", "reason", errTooLarge)
+			continue
+		}
+		content := fmt.Sprintf("%s\n%s", d.submission.Title, d.submission.FullPermalink())
+		release, err := r.work.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		err = r.postImageToDiscord(ctx, d.path, content)
+		release()
+		if err != nil {
+			return fmt.Errorf("%s: %v", d.path, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// postImageToDiscord posts the image at path, on disk or in the output storage, to the webhook
+func (r *Reddit) postImageToDiscord(ctx context.Context, path, content string) error {
+	local, done, err := r.localCopy(path)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return postToDiscord(ctx, r.client, r.cfg.DiscordWebhook, local, content)
+}
+
+// maxDiscordImageBytes is the largest attachment Discord accepts without a boosted server
+const maxDiscordImageBytes = 10 << 20
+
+// discordMessageLength is the longest content of a Discord message
+const discordMessageLength = 2000
+
+// discordPublisher posts the images to a Discord webhook
+type discordPublisher struct {
+	name    string
+	client  *http.Client
+	webhook string
+}
+
+func (d discordPublisher) Name() string {
+	return d.name
+}
+
+func (d discordPublisher) Publish(ctx context.Context, rec ManifestRecord) error {
+	err := checkSize(rec.Path, maxDiscordImageBytes)
+	if err != nil {
+		return err
+	}
+	return postToDiscord(ctx, d.client, d.webhook, rec.Path, caption(rec, discordMessageLength, len(rec.Permalink)))
+}
+
+// postToDiscord uploads the image at path as an attachment to a Discord webhook, along with content
+func postToDiscord(ctx context.Context, client *http.Client, webhook, path, content string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	payload, err := json.Marshal(map[string]string{
+		"content": content,
+	})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	err = w.WriteField("payload_json", string(payload))
+	if err != nil {
+		return err
+	}
+	part, err := w.CreateFormFile("files[0]", filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("discord webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBestImageFallsBackUnderTheDiscordLimit(t *testing.T) {
+	defer inTempDir(t)()
+	var posted []string
+	var content string
+	var attached []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		file, header, err := req.FormFile("files[0]")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer file.Close()
+		posted = append(posted, header.Filename)
+		attached, err = ioutil.ReadAll(file)
+		if err != nil {
+			t.Error(err)
+		}
+		var payload struct {
+			Content string `json:"content"`
+		}
+		err = json.Unmarshal([]byte(req.FormValue("payload_json")), &payload)
+		if err != nil {
+			t.Error(err)
+		}
+		content = payload.Content
+	}))
+	defer srv.Close()
+
+	huge, err := os.Create("huge.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = huge.Truncate(maxDiscordImageBytes + 1)
+	huge.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := testPNG(t, 30, 20, 1)
+	err = ioutil.WriteFile("small.png", small, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRedditFromConfig(&Config{DiscordWebhook: srv.URL})
+	r.Logger = nil
+	best, second := post("huge", ""), post("small", "")
+	best.Score, second.Score = 100, 10
+	second.Title, second.Permalink = "Lake Bled [OC]", "/r/EarthPorn/comments/small/"
+	err = r.postBestToDiscord(context.Background(), []download{
+		{submission: second, path: "small.png"},
+		{submission: best, path: "huge.png"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 || posted[0] != "small.png" {
+		t.Errorf("posted %v, want the best image small enough", posted)
+	}
+	if !bytes.Equal(attached, small) {
+		t.Errorf("attached %d bytes, want the %d of the image", len(attached), len(small))
+	}
+	if !strings.Contains(content, second.Title) || !strings.Contains(content, second.FullPermalink()) {
+		t.Errorf("content %q, want the title and the permalink", content)
+	}
+}
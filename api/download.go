@@ -0,0 +1,521 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucbarr/earthpornbot/store"
+)
+
+// download is an image saved by the current run
+type download struct {
+	submission  *submission
+	path        string
+	width       int
+	height      int
+	orientation string
+	// size is the number of bytes transferred
+	size int64
+}
+
+// statusError is returned when an image host answers with a non 2xx status
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d", e.url, e.code)
+}
+
+// fetchImage downloads and classifies the image of post, a nil download means it was skipped
+func (r *Reddit) fetchImage(ctx context.Context, post *submission, idx *index) (dl *download, err error) {
+	url := post.URL
+	release, err := r.hosts.acquire(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	// the host slot is taken first, so no slot of the global budget waits on a busy host
+	releaseWork, err := r.work.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseWork()
+
+	filename := downloadName(url)
+	if filename == "" {
+		return nil, fmt.Errorf("%s: no file name in the link", url)
+	}
+	if r.seen != nil && r.seen.has(url) {
+		r.Logger.Info("skipping image", "url", url, "reason", "already downloaded")
+		return nil, nil
+	}
+
+	// a malformed image may panic a decoder, it only fails its own download
+	defer func() {
+		if p := recover(); p != nil {
+			r.Logger.Error("recovered decoding", "url", url, "panic", fmt.Sprint(p))
+			os.Remove(filename)
+			os.Remove(filename + ".part")
+			dl, err = nil, fmt.Errorf("%s: decoder panic: %v", url, p)
+		}
+	}()
+
+	// the body goes to part until complete, which resume keeps when the download is interrupted
+	part := filename + ".part"
+	var offset int64
+	if r.cfg.Resume && !r.cfg.DryRun {
+		info, err := os.Stat(part)
+		if err == nil {
+			offset = info.Size()
+		}
+	}
+	cached := r.cache.get(url)
+
+	// the response headers get the fixed timeout, the body a deadline derived from its size
+	getCtx, cancelGet := context.WithCancel(ctx)
+	defer cancelGet()
+	stopDeadline := afterTimeout(r.cfg.Timeout, cancelGet)
+	start := r.Clock.Now()
+	req, err := http.NewRequestWithContext(getCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// so CDNs negotiating the format serve one that can be decoded
+	req.Header.Set("Accept", acceptHeader(r.cfg.EnabledCodecs))
+	switch {
+	case r.cfg.DryRun:
+		// the head is enough to classify the image
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", maxHeaderBytes-1))
+	case offset > 0 && cached.validator() != "":
+		// the rest of the part when the image did not change since, all of it otherwise
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", cached.validator())
+	case cached.Complete:
+		cached.conditional(req)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	stopDeadline()
+	stopDeadline = afterTimeout(downloadTimeout(resp.ContentLength, r.cfg.BytesPerSecondFloor, r.cfg.Timeout), cancelGet)
+	defer stopDeadline()
+
+	if resp.StatusCode == http.StatusNotModified {
+		r.Logger.Info("skipping image", "url", url, "reason", "not modified since the last download")
+		return nil, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			os.Remove(part)
+		}
+		return nil, &statusError{url: url, code: resp.StatusCode}
+	}
+	resumed := offset > 0 && resp.StatusCode == http.StatusPartialContent
+	if resumed && !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+		os.Remove(part)
+		return nil, fmt.Errorf("%s: resumed at %q instead of byte %d", url, resp.Header.Get("Content-Range"), offset)
+	}
+	if !resumed {
+		offset = 0
+		if !r.cfg.DryRun {
+			r.cache.put(url, validators(resp))
+		}
+	}
+	contentType := resp.Header.Get("content-type")
+
+	// hosts often label images application/octet-stream, so the content decides and the
+	// header is only the fallback
+	body := bufio.NewReaderSize(resp.Body, maxHeaderBytes)
+	var head []byte
+	if resumed {
+		head, err = readHead(part)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		head, _ = body.Peek(512)
+	}
+	sniffed := http.DetectContentType(head)
+	codec := codecForContentType(sniffed)
+	if codec == "" && !isImageType(sniffed) {
+		codec = codecForContentType(contentType)
+	}
+	if codec != "" && !codecEnabled(r.cfg.EnabledCodecs, codec) {
+		os.Remove(part)
+		r.Logger.Info("skipping image", "url", url, "reason", "codec not enabled", "codec", codec)
+		return nil, nil
+	}
+	unsupported := codec == "" && (isImageType(sniffed) || isImageType(contentType))
+	if unsupported && r.cfg.UnsupportedDir == "" {
+		os.Remove(part)
+		r.Logger.Info("skipping image", "url", url, "reason", "cannot be decoded", "type", contentType)
+		return nil, nil
+	}
+
+	// the dimensions come from the buffered head of the stream, so the body is read once
+	// and decompression bombs are rejected before they hit the disk
+	var width, height int
+	var peekErr error
+	if resumed {
+		width, height, peekErr = getImageDimensions(part, codec)
+	} else {
+		width, height, peekErr = peekDimensions(body, codec)
+	}
+	if peekErr == nil {
+		err = checkPixels(width, height, r.cfg.MaxPixels)
+		if err != nil {
+			os.Remove(part)
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		if r.tooSmall(width, height) {
+			os.Remove(part)
+			return nil, r.reject(post, "below the minimum size", "width", width, "height", height)
+		}
+	}
+
+	if r.cfg.DryRun {
+		return nil, r.dryRun(url, filename, codec, width, height, unsupported, peekErr)
+	}
+
+	hash := sha256.New()
+	file, err := openPart(part, resumed, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if resumed {
+		r.Logger.Info("resuming download", "url", url, "offset", offset)
+	}
+
+	size, err := io.Copy(io.MultiWriter(file, hash), body)
+	downloadedBytes.Add(float64(size))
+	size += offset
+	if err != nil {
+		// the downloads the run no longer needs are not resumed either
+		if r.cfg.Resume && size > 0 && !cancelledByRun(ctx) {
+			r.Logger.Info("keeping partial download", "url", url, "path", part, "bytes", size)
+		} else {
+			os.Remove(part)
+		}
+		return nil, fmt.Errorf("%s: could not download: %w", url, err)
+	}
+	if size == 0 {
+		os.Remove(part)
+		return nil, fmt.Errorf("%s: empty response body", url)
+	}
+	downloadDuration.Observe(r.Clock.Now().Sub(start).Seconds())
+	file.Close()
+	err = os.Rename(part, filename)
+	if err != nil {
+		os.Remove(part)
+		return nil, err
+	}
+	// a later request for the same image can be conditional, unless this one fails
+	defer func() {
+		if err == nil {
+			r.cache.complete(url)
+		}
+	}()
+
+	// undecodable images are kept aside as they are, without being classified
+	if unsupported {
+		name := filename
+		if r.cfg.HashNames {
+			name = hex.EncodeToString(hash.Sum(nil)) + filepath.Ext(filename)
+		}
+		path := r.outputPath(r.cfg.UnsupportedDir, name)
+		err = r.move(filename, path)
+		if err == errSameContent {
+			os.Remove(filename)
+			return nil, r.reject(post, err.Error())
+		}
+		if err != nil {
+			os.Remove(filename)
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		r.Logger.Info("kept unsupported image", "url", url, "type", contentType, "path", path)
+		if r.seen != nil {
+			r.seen.add(url, filepath.Base(path))
+		}
+		return nil, nil
+	}
+
+	// headers larger than the buffer need the whole file
+	if peekErr != nil {
+		width, height, err = getImageDimensions(filename, codec)
+		if err != nil {
+			os.Remove(filename)
+			return nil, fmt.Errorf("%s: could not read the image dimensions: %v", url, err)
+		}
+		err = checkPixels(width, height, r.cfg.MaxPixels)
+		if err != nil {
+			os.Remove(filename)
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		if r.tooSmall(width, height) {
+			os.Remove(filename)
+			return nil, r.reject(post, "below the minimum size", "width", width, "height", height)
+		}
+	}
+
+	if !withinAspect(width, height, r.cfg.SanityAspectMin, r.cfg.SanityAspectMax) {
+		os.Remove(filename)
+		return nil, r.reject(post, "not a wallpaper", "width", width, "height", height)
+	}
+	if len(r.cfg.ExactResolutions) > 0 && !matchesResolution(r.cfg.ExactResolutions, width, height) {
+		os.Remove(filename)
+		return nil, r.reject(post, "not a wanted resolution", "width", width, "height", height)
+	}
+
+	var phash string
+	if r.cfg.Dedupe || r.cfg.Churn {
+		hash, err := perceptualHash(filename)
+		if err != nil {
+			os.Remove(filename)
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		idx.seeHash(hash)
+		if r.cfg.Dedupe {
+			dup, fresh := idx.claimHash(hash, url, r.cfg.DedupeThreshold)
+			if !fresh {
+				dup.Kept = r.cfg.DedupeKeep
+				idx.addDuplicate(dup)
+			}
+			if !fresh && !r.cfg.DedupeKeep {
+				os.Remove(filename)
+				return nil, r.reject(post, "duplicate", "similarTo", dup.SimilarTo)
+			}
+			if !fresh {
+				r.Logger.Info("keeping duplicate image", "url", url, "similarTo", dup.SimilarTo)
+			}
+			// the verify command, the pipeline or the quota may still remove the image
+			if fresh {
+				defer func() {
+					if dl == nil {
+						idx.releaseHash(hash, url)
+					}
+				}()
+			}
+		}
+		phash = strconv.FormatUint(hash, 16)
+	}
+
+	if len(r.cfg.VerifyCommand) > 0 {
+		err = verifyFile(r.cfg.VerifyCommand, r.cfg.VerifyTimeout, filename)
+		if errors.Is(err, errVerifyRejected) {
+			os.Remove(filename)
+			return nil, r.reject(post, "rejected by the verify command", "err", err)
+		}
+		if err != nil {
+			os.Remove(filename)
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+	}
+
+	// the images are processed before being classified, by their new dimensions
+	if r.pipeline != nil && r.pipeline.Rewrites() && (codec == JPEG || codec == PNG) {
+		width, height, err = r.pipeline.ProcessFile(filename)
+		if err != nil {
+			os.Remove(filename)
+			return nil, fmt.Errorf("%s: could not process: %v", url, err)
+		}
+	}
+
+	name := filename
+	if r.cfg.HashNames {
+		name = hex.EncodeToString(hash.Sum(nil)) + filepath.Ext(filename)
+	}
+	placed, err := r.place(filename, name, codec, width, height)
+	if err == errQuotaFull || err == errSameContent {
+		os.Remove(filename)
+		return nil, r.reject(post, err.Error())
+	}
+	if err != nil {
+		os.Remove(filename)
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	newPath, orientation := placed.path, placed.orientation
+	fields := []interface{}{"url", url, "path", newPath, "bytes", size, "type", contentType, "aspectRatio", placed.aspectRatio}
+	if placed.tier != "" {
+		fields = append(fields, "tier", placed.tier)
+	}
+	r.Logger.Info("saved image", fields...)
+	if r.pipeline != nil && (codec == JPEG || codec == PNG) {
+		_, err = r.pipeline.WriteVariants(newPath, filepath.Join(r.outputPath(variantsDir), filepath.Base(newPath)))
+		if err != nil {
+			r.Logger.Warn("could not write the variants", "path", newPath, "err", err)
+		}
+	}
+
+	entry := indexEntry{
+		ID:          post.ID,
+		Gallery:     post.gallery,
+		URL:         url,
+		Path:        newPath,
+		Width:       width,
+		Height:      height,
+		PHash:       phash,
+		Subreddit:   post.Subreddit,
+		Title:       post.Title,
+		Author:      post.Author,
+		Permalink:   post.FullPermalink(),
+		Score:       post.Score,
+		Orientation: orientation,
+	}
+	err = idx.add(entry)
+	if err != nil {
+		return nil, err
+	}
+	if r.cfg.Sidecars {
+		err = r.writeSidecar(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.seen != nil {
+		r.seen.add(url, filepath.Base(newPath))
+	}
+	if r.records != nil {
+		err = r.records.Put(store.Record{
+			ID:       post.key(),
+			URL:      url,
+			Path:     newPath,
+			Checksum: hex.EncodeToString(hash.Sum(nil)),
+			PHash:    phash,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &download{
+		submission:  post,
+		path:        newPath,
+		width:       width,
+		height:      height,
+		orientation: orientation,
+		size:        size,
+	}, nil
+}
+
+// reject logs why the image of post was skipped after its download and records the submission
+// as handled, in the seen manifest and the store, so later runs do not download it again
+func (r *Reddit) reject(post *submission, reason string, keyvals ...interface{}) error {
+	r.Logger.Info("skipping image", append([]interface{}{"url", post.URL, "reason", reason}, keyvals...)...)
+	if r.seen != nil {
+		r.seen.addLink(post.URL)
+	}
+	if r.records == nil || post.ID == "" {
+		return nil
+	}
+	return r.records.Put(store.Record{ID: post.key(), URL: post.URL, Skipped: reason})
+}
+
+// dryRun logs where the image of url would be saved, classified from the head of its download
+func (r *Reddit) dryRun(url, filename string, codec imageCodec, width, height int, unsupported bool, peekErr error) error {
+	if unsupported {
+		r.Logger.Info("would keep unsupported image", "url", url, "path", r.outputPath(r.cfg.UnsupportedDir, filename))
+		return nil
+	}
+	// headers larger than the head are only read once downloaded
+	if peekErr != nil {
+		r.Logger.Info("would download", "url", url, "reason", "dimensions unknown from the head")
+		return nil
+	}
+	if !withinAspect(width, height, r.cfg.SanityAspectMin, r.cfg.SanityAspectMax) {
+		r.Logger.Info("skipping image", "url", url, "reason", "not a wallpaper", "width", width, "height", height)
+		return nil
+	}
+	if len(r.cfg.ExactResolutions) > 0 && !matchesResolution(r.cfg.ExactResolutions, width, height) {
+		r.Logger.Info("skipping image", "url", url, "reason", "not a wanted resolution", "width", width, "height", height)
+		return nil
+	}
+
+	placed, err := r.classify("", filename, codec, width, height)
+	if err == errQuotaFull || err == errSameContent {
+		r.Logger.Info("skipping image", "url", url, "reason", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", url, err)
+	}
+	r.Logger.Info("would save image", "url", url, "path", placed.path, "width", width, "height", height)
+	return nil
+}
+
+// openPart opens the partial download at path, truncated unless resumed. A resumed part is
+// written to hash first, so the hash covers the whole image.
+func openPart(path string, resumed bool, hash io.Writer) (*os.File, error) {
+	if !resumed {
+		return os.Create(path)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// the copy leaves the file at its end, where the rest is appended
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// tooSmall reports whether an image is narrower or shorter than the configured minimum
+func (r *Reddit) tooSmall(width, height int) bool {
+	return width < r.cfg.MinWidth || height < r.cfg.MinHeight
+}
+
+// afterTimeout calls cancel once timeout elapsed unless stopped first, 0 means never
+func afterTimeout(timeout time.Duration, cancel context.CancelFunc) (stop func() bool) {
+	if timeout <= 0 {
+		return func() bool { return false }
+	}
+	return time.AfterFunc(timeout, cancel).Stop
+}
+
+// requestContext bounds a request made on behalf of ctx by timeout, 0 means no bound
+func requestContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// downloadSlack is added to size derived deadlines to account for connection setup
+const downloadSlack = 5 * time.Second
+
+// downloadTimeout gives larger images proportionally more time when a throughput floor is set,
+// it falls back to the fixed timeout when the size is unknown
+func downloadTimeout(size, bytesPerSecondFloor int64, fallback time.Duration) time.Duration {
+	if bytesPerSecondFloor <= 0 || size <= 0 {
+		return fallback
+	}
+	return time.Duration(size)*time.Second/time.Duration(bytesPerSecondFloor) + downloadSlack
+}
+
+// runContextKey holds, in the context of the downloads, the context of the run they belong to
+type runContextKey struct{}
+
+// cancelledByRun reports whether the download of ctx was cancelled because its run stopped
+// early, after a failure or once the quota was met, rather than because the run was interrupted
+func cancelledByRun(ctx context.Context) bool {
+	run, ok := ctx.Value(runContextKey{}).(context.Context)
+	return ok && ctx.Err() != nil && run.Err() == nil
+}
@@ -0,0 +1,575 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	imageproc "github.com/lucbarr/earthpornbot/image"
+	"github.com/lucbarr/earthpornbot/store"
+)
+
+func TestRejectedImagesAreNotFetchedAgain(t *testing.T) {
+	defer inTempDir(t)()
+	small := testPNG(t, 30, 20, 1)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(small)
+	}))
+	defer srv.Close()
+
+	for run := 0; run < 2; run++ {
+		r := newTestReddit(&Config{MinWidth: 100, Store: "store.jsonl", SeenManifest: "seen.json"}, srv,
+			post("small", srv.URL+"/small.png"))
+		err := r.FetchSubmissions()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("the rejected image was requested %d times, want once", n)
+	}
+
+	s, err := store.OpenFile("store.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rec, err := s.Get("small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Skipped != "below the minimum size" {
+		t.Errorf("recorded the skip reason %q", rec.Skipped)
+	}
+}
+
+func TestDiscardedImagesReleaseTheirHash(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	// the same image twice, the verify command rejects the first copy
+	r := newTestReddit(&Config{
+		Dedupe:        true,
+		VerifyCommand: []string{"sh", "-c", `case "$0" in *first*) exit 1;; esac`},
+	}, srv, post("first", srv.URL+"/first.png"), post("second", srv.URL+"/second.png"))
+	r.Concurrency = 1
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 {
+		t.Fatalf("saved %d images, want the second copy", len(saved))
+	}
+}
+
+func TestProcessingWritesVariants(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 60, 40, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{Process: imageproc.Config{Targets: []string{"30x10", "10x30"}}}, srv,
+		post("a", srv.URL+"/a.png"))
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].Width != 60 || saved[0].Height != 40 {
+		t.Fatalf("saved %+v, want the original image", saved)
+	}
+	for _, name := range []string{"30x10.png", "10x30.png"} {
+		if _, err := os.Stat(filepath.Join("variants", "a.png", name)); err != nil {
+			t.Errorf("variant %s: %v", name, err)
+		}
+	}
+}
+
+// served is a request answered by rangeServer
+type served struct {
+	header http.Header
+	status int
+}
+
+// statusWriter records the status written through it
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// rangeServer serves img with etag, honoring Range, If-Range and If-None-Match, and records
+// the requests it answered
+func rangeServer(img []byte, etag string, requests *[]served) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", etag)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		http.ServeContent(sw, req, "img.png", time.Time{}, bytes.NewReader(img))
+		mu.Lock()
+		*requests = append(*requests, served{req.Header.Clone(), sw.status})
+		mu.Unlock()
+	}))
+}
+
+func TestResumeAndConditionalRequests(t *testing.T) {
+	img := testPNG(t, 60, 40, 1)
+	half := len(img) / 2
+	tests := []struct {
+		name   string
+		etag   string
+		cached cacheEntry
+		part   bool
+		// rangeHeader is the Range of the request, none when empty
+		rangeHeader string
+		// status is the answer of the server
+		status int
+		saved  bool
+	}{
+		{name: "resumes the part", etag: `"v1"`, cached: cacheEntry{ETag: `"v1"`}, part: true,
+			rangeHeader: fmt.Sprintf("bytes=%d-", half), status: http.StatusPartialContent, saved: true},
+		{name: "restarts when the image changed", etag: `"v2"`, cached: cacheEntry{ETag: `"v1"`}, part: true,
+			rangeHeader: fmt.Sprintf("bytes=%d-", half), status: http.StatusOK, saved: true},
+		{name: "restarts without a validator", etag: `"v1"`, part: true, status: http.StatusOK, saved: true},
+		{name: "skips the unmodified image", etag: `"v1"`, cached: cacheEntry{ETag: `"v1"`, Complete: true},
+			status: http.StatusNotModified},
+		{name: "downloads the modified image", etag: `"v2"`, cached: cacheEntry{ETag: `"v1"`, Complete: true},
+			status: http.StatusOK, saved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			var requests []served
+			srv := rangeServer(img, tt.etag, &requests)
+			defer srv.Close()
+			link := srv.URL + "/a.png"
+
+			data, err := json.Marshal(map[string]cacheEntry{link: tt.cached})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile("cache.json", data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.part {
+				err = ioutil.WriteFile("a.png.part", img[:half], 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := newTestReddit(&Config{Resume: true, HTTPCache: "cache.json"}, srv, post("a", link))
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(requests) != 1 {
+				t.Fatalf("%d requests, want 1", len(requests))
+			}
+			if got := requests[0].header.Get("Range"); got != tt.rangeHeader {
+				t.Errorf("Range %q, want %q", got, tt.rangeHeader)
+			}
+			if requests[0].status != tt.status {
+				t.Errorf("answered %d, want %d", requests[0].status, tt.status)
+			}
+			if !tt.saved {
+				if len(saved) != 0 {
+					t.Errorf("saved %+v, want nothing", saved)
+				}
+				return
+			}
+			if len(saved) != 1 {
+				t.Fatalf("saved %+v, want the image", saved)
+			}
+			got, err := ioutil.ReadFile(saved[0].Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, img) {
+				t.Errorf("saved %d bytes differing from the %d of the image", len(got), len(img))
+			}
+			if _, err := os.Stat("a.png.part"); !os.IsNotExist(err) {
+				t.Errorf("the part is left: %v", err)
+			}
+
+			cache, err := loadHTTPCache("cache.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e := cache.get(link); e.ETag != tt.etag || !e.Complete {
+				t.Errorf("cached %+v, want %s complete", e, tt.etag)
+			}
+		})
+	}
+}
+
+func TestInterruptedDownloadsAreKept(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 60, 40, 1)
+	half := len(img) / 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", fmt.Sprint(len(img)))
+		w.Write(img[:half])
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{Resume: true, HTTPCache: "cache.json"}, srv, post("a", srv.URL+"/a.png"))
+	saved, _ := r.FetchSubmissionsResults()
+	if len(saved) != 0 {
+		t.Fatalf("saved %+v, want nothing", saved)
+	}
+	part, err := ioutil.ReadFile("a.png.part")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, img[:half]) {
+		t.Errorf("kept %d bytes, want the %d received", len(part), half)
+	}
+	cache, err := loadHTTPCache("cache.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := cache.get(srv.URL + "/a.png"); e.ETag != `"v1"` || e.Complete {
+		t.Errorf("cached %+v, want the incomplete validator", e)
+	}
+}
+
+func TestDryRunReadsTheHeadOnly(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 60, 40, 1)
+	var requests []served
+	srv := rangeServer(img, `"v1"`, &requests)
+	defer srv.Close()
+
+	r := newTestReddit(&Config{DryRun: true, Resume: true, HTTPCache: "cache.json"}, srv, post("a", srv.URL+"/a.png"))
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 0 {
+		t.Errorf("saved %+v, want nothing", saved)
+	}
+	if len(requests) != 1 || requests[0].header.Get("Range") != fmt.Sprintf("bytes=0-%d", maxHeaderBytes-1) {
+		t.Errorf("requests %v, want one for the head", requests)
+	}
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Errorf("the dry run wrote %s", f.Name())
+	}
+}
+
+func TestCancellingTheContextStopsTheRun(t *testing.T) {
+	defer inTempDir(t)()
+	var started int32
+	running := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&started, 1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		w.(http.Flusher).Flush()
+		running <- struct{}{}
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for i := 0; i < 10; i++ {
+		posts = append(posts, post(fmt.Sprint(i), fmt.Sprintf("%s/%d.png", srv.URL, i)))
+	}
+	r := newTestReddit(&Config{}, srv, posts...)
+	r.Concurrency = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.FetchSubmissionsResultsContext(ctx)
+		done <- err
+	}()
+	<-running
+	<-running
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run went on after its context was cancelled")
+	}
+	if n := atomic.LoadInt32(&started); n != 2 {
+		t.Errorf("started %d downloads, want the 2 in flight only", n)
+	}
+	parts, err := filepath.Glob("*.part")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) > 0 {
+		t.Errorf("left %v behind", parts)
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int64
+		floor int64
+		want  time.Duration
+	}{
+		{"unknown size", -1, 1000, 30 * time.Second},
+		{"no floor", 1 << 20, 0, 30 * time.Second},
+		{"small image", 1000, 1000, time.Second + downloadSlack},
+		{"large image", 100000, 1000, 100*time.Second + downloadSlack},
+	}
+	for _, tt := range tests {
+		if got := downloadTimeout(tt.size, tt.floor, 30*time.Second); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSlowBodiesGetTheirSizedDeadline(t *testing.T) {
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(img)))
+		half := len(img) / 2
+		w.Write(img[:half])
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write(img[half:])
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		floor int64
+		saved bool
+	}{
+		{"fixed timeout", 0, false},
+		{"sized deadline", 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			r := newTestReddit(&Config{Timeout: 100 * time.Millisecond, BytesPerSecondFloor: tt.floor}, srv,
+				post("a", srv.URL+"/a.png"))
+			saved, err := r.FetchSubmissionsResults()
+			if tt.saved && (err != nil || len(saved) != 1) {
+				t.Errorf("saved %+v, %v, want the image", saved, err)
+			}
+			if !tt.saved && (err == nil || len(saved) != 0) {
+				t.Errorf("saved %+v, %v, want the download cut at the timeout", saved, err)
+			}
+		})
+	}
+}
+
+func TestContentTypeOfTheGetIsUsed(t *testing.T) {
+	img := testPNG(t, 30, 20, 1)
+	tests := []struct {
+		name string
+		head string
+		get  string
+	}{
+		{"generic head", "application/octet-stream", "image/png"},
+		{"generic get", "image/png", "application/octet-stream"},
+		{"mislabelled", "image/jpeg", "image/jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Method == http.MethodHead {
+					w.Header().Set("Content-Type", tt.head)
+					return
+				}
+				w.Header().Set("Content-Type", tt.get)
+				w.Write(img)
+			}))
+			defer srv.Close()
+
+			r := newTestReddit(&Config{}, srv, post("a", srv.URL+"/a.png"))
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 1 || saved[0].Path != filepath.Join("hori", "a.png") || saved[0].Width != 30 {
+				t.Errorf("saved %+v, want the png in hori", saved)
+			}
+		})
+	}
+}
+
+func TestMislabelledJPEGsAreClassified(t *testing.T) {
+	var photo bytes.Buffer
+	err := jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 40, 60)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, contentType := range []string{"application/octet-stream", "binary/octet-stream", "image/png", ""} {
+		t.Run(contentType, func(t *testing.T) {
+			defer inTempDir(t)()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Method != http.MethodGet {
+					t.Errorf("sent a %s, the GET is enough", req.Method)
+				}
+				w.Header()["Content-Type"] = []string{contentType}
+				w.Write(photo.Bytes())
+			}))
+			defer srv.Close()
+
+			r := newTestReddit(&Config{AllowedExtensions: []string{"jpg"}}, srv, post("a", srv.URL+"/a.jpg"))
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 1 || saved[0].Path != filepath.Join("vert", "a.jpg") || saved[0].Width != 40 || saved[0].Height != 60 {
+				t.Errorf("saved %+v, want the jpeg in vert", saved)
+			}
+		})
+	}
+}
+
+func TestImagesAreReadInASinglePass(t *testing.T) {
+	defer inTempDir(t)()
+	// larger than the buffered head, so the body is streamed past it
+	img := noisyPNG(t, 700, 500)
+	var requests, written int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		n, _ := w.Write(img)
+		atomic.AddInt64(&written, int64(n))
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{}, srv, post("a", srv.URL+"/a.png"))
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].Width != 700 || saved[0].Height != 500 {
+		t.Fatalf("saved %+v, want the 700x500 image", saved)
+	}
+	if requests != 1 || written != int64(len(img)) {
+		t.Errorf("%d requests for %d bytes, want one of %d", requests, written, len(img))
+	}
+	data, err := ioutil.ReadFile(saved[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, img) {
+		t.Errorf("saved %d bytes differing from the %d sent", len(data), len(img))
+	}
+}
+
+func TestEmptyBodiesFail(t *testing.T) {
+	defer inTempDir(t)()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{}, srv, post("a", srv.URL+"/a.png"))
+	saved, err := r.FetchSubmissionsResults()
+	if err == nil || !strings.Contains(err.Error(), srv.URL+"/a.png: empty response body") {
+		t.Fatalf("got %v, want the empty body reported", err)
+	}
+	if len(saved) != 0 {
+		t.Errorf("saved %+v", saved)
+	}
+	files, err := filepath.Glob(filepath.Join("*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("left %v behind", files)
+	}
+}
+
+func TestSmallImagesAreRejected(t *testing.T) {
+	images := map[string][]byte{
+		"tiny.png":  testPNG(t, 100, 100, 1),
+		"wide.png":  testPNG(t, 400, 100, 2),
+		"large.png": testPNG(t, 400, 300, 3),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		minWidth  int
+		minHeight int
+		want      []string
+	}{
+		{"no minimum", 0, 0, []string{"large.png", "tiny.png", "wide.png"}},
+		{"min width", 200, 0, []string{"large.png", "wide.png"}},
+		{"min width and height", 200, 200, []string{"large.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			var posts []*submission
+			for name := range images {
+				posts = append(posts, post(name, srv.URL+"/"+name))
+			}
+			r := newTestReddit(&Config{MinWidth: tt.minWidth, MinHeight: tt.minHeight}, srv, posts...)
+			_, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// nothing else is left on disk, kept or partial
+			files, err := filepath.Glob(filepath.Join("*", "*.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			root, err := filepath.Glob("*.png*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, f := range append(files, root...) {
+				names = append(names, filepath.Base(f))
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("kept %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package api
+
+import "strings"
+
+// multiError holds every failure of a best-effort run
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, 0, len(m))
+	for _, err := range m {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ExternalDownloader hands the downloads to another program, such as aria2c
+type ExternalDownloader struct {
+	// Command is run once per run, "{list}" and "{dir}" in its arguments are replaced
+	// by List and Dir
+	Command []string
+	// List is where the links to download are written, one per line
+	List string
+	// Dir is where the command downloads to, its files are classified afterwards
+	Dir string
+}
+
+// fetchExternally lists the submissions, lets the external downloader fetch them all
+// and classifies the result
+func (r *Reddit) fetchExternally(ctx context.Context, idx *index) error {
+	ext := r.cfg.ExternalDownloader
+
+	posts := make(chan *submission)
+	stop := make(chan struct{})
+	defer close(stop)
+	progress := newListingProgress(r.listingStateFile())
+	if r.cfg.DryRun {
+		progress = newListingProgress("")
+	}
+	listed := make(chan error, 1)
+	go func() {
+		_, err := r.listSubmissions(ctx, posts, stop, progress)
+		close(posts)
+		listed <- err
+	}()
+
+	var handled []*submission
+	var links []string
+	for post := range posts {
+		handled = append(handled, post)
+		links = append(links, post.URL)
+	}
+	err := <-listed
+	if err != nil {
+		return err
+	}
+	if r.cfg.DryRun {
+		for _, link := range links {
+			r.Logger.Info("would download", "url", link, "downloader", ext.Command[0])
+		}
+		return nil
+	}
+
+	err = ioutil.WriteFile(ext.List, []byte(strings.Join(links, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(ext.Dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	args := make([]string, len(ext.Command))
+	for i, arg := range ext.Command {
+		args[i] = strings.NewReplacer("{list}", ext.List, "{dir}", ext.Dir).Replace(arg)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("external downloader failed: %v", err)
+	}
+
+	// the cursor moves once the command downloaded the links
+	for _, post := range handled {
+		post.page.done()
+	}
+	err = progress.saveErr()
+	if err != nil {
+		return err
+	}
+	return r.reclassifyDir(ext.Dir, idx)
+}
+
+// ReclassifyDir moves every image found in dir into its orientation folder, files that
+// aren't supported images or fail the checks of the downloads are left in place
+func (r *Reddit) ReclassifyDir(dir string) error {
+	idx, err := loadIndex(r.cfg.Index)
+	if err != nil {
+		return err
+	}
+	return r.reclassifyDir(dir, idx)
+}
+
+// reclassifyDir is ReclassifyDir, the duplicates are looked up in idx
+func (r *Reddit) reclassifyDir(dir string, idx *index) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var errs multiError
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		src := filepath.Join(dir, f.Name())
+
+		codec, err := sniffCodec(src)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if codec == "" {
+			r.Logger.Info("skipping file", "path", src, "reason", "not a supported image")
+			continue
+		}
+		if !codecEnabled(r.cfg.EnabledCodecs, codec) {
+			r.Logger.Info("skipping file", "path", src, "reason", "codec not enabled", "codec", codec)
+			continue
+		}
+
+		width, height, err := getImageDimensions(src, codec)
+		if err == nil {
+			err = checkPixels(width, height, r.cfg.MaxPixels)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", src, err))
+			continue
+		}
+		if r.tooSmall(width, height) {
+			r.Logger.Info("skipping file", "path", src, "reason", "below the minimum size", "width", width, "height", height)
+			continue
+		}
+		if !withinAspect(width, height, r.cfg.SanityAspectMin, r.cfg.SanityAspectMax) {
+			r.Logger.Info("skipping file", "path", src, "reason", "not a wallpaper", "width", width, "height", height)
+			continue
+		}
+		if len(r.cfg.ExactResolutions) > 0 && !matchesResolution(r.cfg.ExactResolutions, width, height) {
+			r.Logger.Info("skipping file", "path", src, "reason", "not a wanted resolution", "width", width, "height", height)
+			continue
+		}
+
+		var hash uint64
+		var phash string
+		claimed := false
+		if r.cfg.Dedupe {
+			hash, err = perceptualHash(src)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", src, err))
+				continue
+			}
+			idx.seeHash(hash)
+			dup, fresh := idx.claimHash(hash, src, r.cfg.DedupeThreshold)
+			if !fresh {
+				dup.Kept = r.cfg.DedupeKeep
+				idx.addDuplicate(dup)
+			}
+			if !fresh && !r.cfg.DedupeKeep {
+				r.Logger.Info("skipping file", "path", src, "reason", "duplicate", "similarTo", dup.SimilarTo)
+				continue
+			}
+			claimed = fresh
+			phash = strconv.FormatUint(hash, 16)
+		}
+
+		placed, err := r.place(src, f.Name(), codec, width, height)
+		if claimed && err != nil {
+			idx.releaseHash(hash, src)
+		}
+		if err == errQuotaFull || err == errSameContent {
+			r.Logger.Info("skipping file", "path", src, "reason", err)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", src, err))
+			continue
+		}
+		r.Logger.Info("classified file", "path", src, "into", placed.path, "aspectRatio", placed.aspectRatio)
+
+		err = idx.add(indexEntry{
+			Path:        placed.path,
+			Width:       width,
+			Height:      height,
+			PHash:       phash,
+			Subreddit:   r.current.Name,
+			Orientation: placed.orientation,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReclassifyDirChecksTheImages(t *testing.T) {
+	defer inTempDir(t)()
+	err := os.Mkdir("in", os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"small.png":   testPNG(t, 15, 10, 1),
+		"banner.png":  testPNG(t, 90, 10, 2),
+		"kept.png":    testPNG(t, 30, 20, 3),
+		"similar.png": testPNG(t, 30, 20, 3),
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join("in", name), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewRedditFromConfig(&Config{MinWidth: 20, SanityAspectMax: 3, Dedupe: true, AllowedExtensions: []string{"png"}})
+	r.Logger = nil
+	r.current = r.subreddits()[0]
+	err = r.ReclassifyDir("in")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	left, err := ioutil.ReadDir("in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 3 {
+		t.Errorf("left %d files in place, want the small, the banner and the duplicate", len(left))
+	}
+	for _, name := range []string{"small.png", "banner.png"} {
+		if _, err := os.Stat(filepath.Join("in", name)); err != nil {
+			t.Errorf("%s was classified: %v", name, err)
+		}
+	}
+	classified, _ := filepath.Glob("hori/*.png")
+	if len(classified) != 1 {
+		t.Errorf("classified %v, want one of the identical images", classified)
+	}
+}
+
+func TestReclassifyDirSkipsTheSameContent(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	for _, dir := range []string{"in", "hori"} {
+		err := os.Mkdir(dir, os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, "0123.png"), img, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewRedditFromConfig(&Config{HashNames: true, AllowedExtensions: []string{"png"}})
+	r.Logger = nil
+	r.current = r.subreddits()[0]
+	err := r.ReclassifyDir("in")
+	if err != nil {
+		t.Fatalf("the same content failed the classification: %v", err)
+	}
+}
+
+func TestExternalDownloaderGetsTheList(t *testing.T) {
+	defer inTempDir(t)()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir("fixtures", os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"a.png": testPNG(t, 30, 20, 1), "b.png": testPNG(t, 20, 30, 2)} {
+		err = ioutil.WriteFile(filepath.Join("fixtures", name), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the stub records its arguments and "downloads" the fixtures named like the links
+	script := `#!/bin/sh
+echo "$@" > ` + filepath.Join(wd, "invoked.txt") + `
+while read -r link; do cp "` + filepath.Join(wd, "fixtures") + `/$(basename "$link")" "$2/"; done < "$1"
+`
+	err = ioutil.WriteFile("downloader.sh", []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReddit(&Config{ExternalDownloader: ExternalDownloader{
+		Command: []string{filepath.Join(wd, "downloader.sh"), "{list}", "{dir}"},
+		List:    "links.txt",
+		Dir:     "downloads",
+	}}, nil,
+		post("a", "https://i.redd.it/a.png"),
+		post("b", "https://i.redd.it/b.png"),
+	)
+	err = r.FetchSubmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ioutil.ReadFile("links.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(list) != "https://i.redd.it/a.png\nhttps://i.redd.it/b.png\n" {
+		t.Errorf("listed %q", list)
+	}
+	invoked, err := ioutil.ReadFile("invoked.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(invoked) != "links.txt downloads\n" {
+		t.Errorf("invoked with %q, want the list and the directory", invoked)
+	}
+	for _, path := range []string{filepath.Join("hori", "a.png"), filepath.Join("vert", "b.png")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("the download was not classified: %v", err)
+		}
+	}
+}
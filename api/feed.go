@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// feedItem is an entry of the JSON feed written after each run
+type feedItem struct {
+	Filename    string `json:"filename"`
+	Path        string `json:"path"`
+	Orientation string `json:"orientation"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Permalink   string `json:"permalink"`
+	Title       string `json:"title"`
+}
+
+// writeFeed replaces the feed at path with the images of the current run
+func writeFeed(path string, downloads []download) error {
+	items := make([]feedItem, 0, len(downloads))
+	for _, d := range downloads {
+		items = append(items, feedItem{
+			Filename:    filepath.Base(d.path),
+			Path:        d.path,
+			Orientation: d.orientation,
+			Width:       d.width,
+			Height:      d.height,
+			Permalink:   d.submission.FullPermalink(),
+			Title:       d.submission.Title,
+		})
+	}
+
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write aside and rename so readers never see a partial feed
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
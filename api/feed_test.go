@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFeedListsTheImagesOfTheRun(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	runs := [][]string{{"a", "b"}, {"c"}}
+	for _, ids := range runs {
+		var posts []*submission
+		for _, id := range ids {
+			p := post(id, srv.URL+"/"+id+".png")
+			p.Permalink = "/r/earthporn/comments/" + id
+			posts = append(posts, p)
+		}
+		r := newTestReddit(&Config{FeedJSON: "feed.json", SeenManifest: "seen.json"}, srv, posts...)
+		err := r.FetchSubmissions()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile("feed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []feedItem
+	err = json.Unmarshal(data, &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := feedItem{
+		Filename:    "c.png",
+		Path:        filepath.Join("hori", "c.png"),
+		Orientation: "hori",
+		Width:       30,
+		Height:      20,
+		Permalink:   "https://reddit.com/r/earthporn/comments/c",
+		Title:       "c",
+	}
+	if len(items) != 1 || items[0] != want {
+		t.Errorf("feed %+v, want only %+v", items, want)
+	}
+}
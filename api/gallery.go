@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+)
+
+// galleryData is the order of the images of a gallery submission
+type galleryData struct {
+	Items []struct {
+		MediaID string `json:"media_id"`
+	} `json:"items"`
+}
+
+// galleryMedia describes an image of a gallery submission
+type galleryMedia struct {
+	Status string `json:"status"`
+	// Mime is the type of the source image, e.g. image/jpg
+	Mime string `json:"m"`
+}
+
+// galleryItems returns a copy of p per image of its gallery, in the gallery order, or p alone
+// when it is not a gallery. The images are linked on i.redd.it, in their full size, and keep
+// their position in the gallery.
+func galleryItems(p *submission) []*submission {
+	if !p.IsGallery || p.GalleryData == nil {
+		return []*submission{p}
+	}
+
+	var items []*submission
+	for i, item := range p.GalleryData.Items {
+		media, ok := p.MediaMetadata[item.MediaID]
+		if !ok || media.Status != "valid" || !strings.HasPrefix(media.Mime, "image/") {
+			continue
+		}
+		ext := strings.TrimPrefix(media.Mime, "image/")
+		if ext == "jpeg" {
+			ext = "jpg"
+		}
+
+		image := *p
+		image.URL = "https://i.redd.it/" + url.PathEscape(item.MediaID) + "." + ext
+		image.gallery = i + 1
+		// the previews are those of the first image only
+		image.Preview = nil
+		items = append(items, &image)
+	}
+	return items
+}
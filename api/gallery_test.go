@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lucbarr/earthpornbot/store"
+)
+
+// gallery is a gallery submission from its listing json
+func gallery(t *testing.T, id, data string) *submission {
+	t.Helper()
+	p := post(id, "https://www.reddit.com/gallery/"+id)
+	err := json.Unmarshal([]byte(data), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGalleryItems(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+		// keys are those of the images in the store, by their position in the gallery
+		keys []string
+	}{
+		{"not a gallery", `{"url": "https://i.redd.it/a.jpg"}`, []string{"https://i.redd.it/a.jpg"}, []string{"g"}},
+		{"in the gallery order", `{
+			"is_gallery": true,
+			"gallery_data": {"items": [{"media_id": "b"}, {"media_id": "a"}]},
+			"media_metadata": {"a": {"status": "valid", "m": "image/png"}, "b": {"status": "valid", "m": "image/jpeg"}}
+		}`, []string{"https://i.redd.it/b.jpg", "https://i.redd.it/a.png"}, []string{"g_1", "g_2"}},
+		{"invalid and missing media", `{
+			"is_gallery": true,
+			"gallery_data": {"items": [{"media_id": "a"}, {"media_id": "b"}, {"media_id": "c"}, {"media_id": "d"}]},
+			"media_metadata": {"a": {"status": "failed", "m": "image/png"}, "b": {"status": "valid", "m": "video/mp4"}, "c": {"status": "valid", "m": "image/webp"}}
+		}`, []string{"https://i.redd.it/c.webp"}, []string{"g_3"}},
+		{"deleted gallery", `{"is_gallery": true}`, []string{"https://www.reddit.com/gallery/g"}, []string{"g"}},
+	}
+	for _, tt := range tests {
+		var links []string
+		var keys []string
+		for _, item := range galleryItems(gallery(t, "g", tt.data)) {
+			if item.ID != "g" {
+				t.Errorf("%s: an image of the gallery is %q, want the id of the gallery", tt.name, item.ID)
+			}
+			links = append(links, item.URL)
+			keys = append(keys, item.key())
+		}
+		if !reflect.DeepEqual(links, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, links, tt.want)
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%s: keyed %v, want %v", tt.name, keys, tt.keys)
+		}
+	}
+}
+
+func TestEveryImageOfAGalleryIsDownloaded(t *testing.T) {
+	for _, resolve := range []bool{true, false} {
+		t.Run(fmt.Sprint(resolve), func(t *testing.T) {
+			defer inTempDir(t)()
+			img := testPNG(t, 30, 20, 1)
+			var fetched []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				fetched = append(fetched, req.URL.Path)
+				w.Write(img)
+			}))
+			target, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := newTestReddit(&Config{ResolveGalleries: resolve}, nil, gallery(t, "g", `{
+			"is_gallery": true,
+			"gallery_data": {"items": [{"media_id": "one"}, {"media_id": "two"}]},
+			"media_metadata": {"one": {"status": "valid", "m": "image/png"}, "two": {"status": "valid", "m": "image/png"}}
+		}`))
+			r.Concurrency = 1
+			r.client = &http.Client{Transport: redirectTransport{target}}
+			saved, err := r.FetchSubmissionsResults()
+			srv.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want, wantPaths []string
+			if resolve {
+				// each image is named after its media
+				want = []string{"/one.png", "/two.png"}
+				wantPaths = []string{"hori/one.png", "hori/two.png"}
+			}
+			sort.Strings(fetched)
+			if !reflect.DeepEqual(fetched, want) {
+				t.Errorf("resolving galleries %v fetched %v, want %v", resolve, fetched, want)
+			}
+			var paths []string
+			for _, s := range saved {
+				paths = append(paths, filepath.ToSlash(s.Path))
+			}
+			sort.Strings(paths)
+			if !reflect.DeepEqual(paths, wantPaths) {
+				t.Errorf("resolving galleries %v saved %v, want %v", resolve, paths, wantPaths)
+			}
+		})
+	}
+}
+
+func TestEachImageOfAGalleryIsPublished(t *testing.T) {
+	defer inTempDir(t)()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(testPNG(t, 30, 20, req.URL.Path[1]))
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := gallery(t, "g", `{
+		"is_gallery": true,
+		"gallery_data": {"items": [{"media_id": "one"}, {"media_id": "two"}]},
+		"media_metadata": {"one": {"status": "valid", "m": "image/png"}, "two": {"status": "valid", "m": "image/png"}}
+	}`)
+
+	r := newTestReddit(&Config{ResolveGalleries: true, Index: "index.jsonl", Store: "store.jsonl"}, nil, listed)
+	r.client = &http.Client{Transport: redirectTransport{target}}
+	var posted []string
+	r.Publishers = []Publisher{recordingPublisher{"discord", &posted}}
+	err = r.FetchSubmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = r.Publish(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(posted)
+	if want := []string{"hori/one.png", "hori/two.png"}; !reflect.DeepEqual(posted, want) {
+		t.Errorf("published %v, want each image of the gallery once", posted)
+	}
+
+	manifest, err := r.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, rec := range manifest {
+		keys = append(keys, imageKey(rec.ID, rec.Gallery))
+	}
+	sort.Strings(keys)
+	if want := []string{"g_1", "g_2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("the manifest keys the images %v, want %v", keys, want)
+	}
+	s, err := store.OpenFile("store.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, key := range []string{"g_1", "g_2"} {
+		rec, err := s.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rec.Posted {
+			t.Errorf("%s recorded %+v, want it posted", key, rec)
+		}
+	}
+}
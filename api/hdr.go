@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// hdrDir holds the images whose metadata declares an HDR transfer function
+const hdrDir = "hdr"
+
+var errBadPNG = errors.New("malformed png")
+
+// transfer characteristics of ITU-T H.273 used by HDR images
+const (
+	transferPQ  = 16
+	transferHLG = 18
+)
+
+// isHDR reports whether the metadata of an image declares HDR. Only PNGs carrying a cICP chunk
+// with the PQ or HLG transfer function are detected, every other image is reported as SDR
+func isHDR(filename string, codec imageCodec) (bool, error) {
+	if codec != PNG {
+		return false, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+
+	var sig [8]byte
+	_, err = io.ReadFull(br, sig[:])
+	if err != nil {
+		return false, err
+	}
+	if string(sig[:]) != "\x89PNG\r\n\x1a\n" {
+		return false, errBadPNG
+	}
+
+	// cICP must come before the image data, so the walk stops at the first IDAT
+	for {
+		var head [8]byte
+		_, err = io.ReadFull(br, head[:])
+		if err != nil {
+			return false, err
+		}
+		length := binary.BigEndian.Uint32(head[:4])
+		switch string(head[4:]) {
+		case "cICP":
+			var cicp [4]byte
+			if length != 4 {
+				return false, errBadPNG
+			}
+			_, err = io.ReadFull(br, cicp[:])
+			if err != nil {
+				return false, err
+			}
+			return cicp[1] == transferPQ || cicp[1] == transferHLG, nil
+		case "IDAT", "IEND":
+			return false, nil
+		}
+		// chunk data and crc
+		_, err = br.Discard(int(length) + 4)
+		if err != nil {
+			return false, err
+		}
+	}
+}
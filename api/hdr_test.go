@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// withCICP returns png with a cICP chunk declaring the BT.2020 primaries and transfer, inserted
+// after its IHDR chunk
+func withCICP(png []byte, transfer byte) []byte {
+	chunk := make([]byte, 4+4+4+4)
+	binary.BigEndian.PutUint32(chunk, 4)
+	copy(chunk[4:], "cICP")
+	copy(chunk[8:], []byte{9, transfer, 0, 1})
+	binary.BigEndian.PutUint32(chunk[12:], crc32.ChecksumIEEE(chunk[4:12]))
+	// the signature then the IHDR chunk: length, type, 13 bytes of data and crc
+	end := 8 + 4 + 4 + 13 + 4
+	tagged := append([]byte(nil), png[:end]...)
+	tagged = append(tagged, chunk...)
+	return append(tagged, png[end:]...)
+}
+
+func TestHDRImagesAreRouted(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"pq.png":   withCICP(testPNG(t, 60, 40, 1), transferPQ),
+		"hlg.png":  withCICP(testPNG(t, 40, 60, 2), transferHLG),
+		"srgb.png": withCICP(testPNG(t, 60, 40, 3), 13),
+		"none.png": testPNG(t, 60, 40, 4),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for name := range images {
+		posts = append(posts, post(name, srv.URL+"/"+name))
+	}
+	r := newTestReddit(&Config{DetectHDR: true}, srv, posts...)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"pq.png":   filepath.Join("hdr", "hori", "pq.png"),
+		"hlg.png":  filepath.Join("hdr", "vert", "hlg.png"),
+		"srgb.png": filepath.Join("hori", "srgb.png"),
+		"none.png": filepath.Join("hori", "none.png"),
+	}
+	if len(saved) != len(want) {
+		t.Fatalf("saved %+v, want every image", saved)
+	}
+	for _, d := range saved {
+		if name := filepath.Base(d.Path); d.Path != want[name] {
+			t.Errorf("%s saved to %s, want %s", name, d.Path, want[name])
+		}
+	}
+}
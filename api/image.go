@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/image/webp"
+)
+
+type imageCodec string
+
+const (
+	JPEG imageCodec = "jpeg"
+	PNG  imageCodec = "png"
+	GIF  imageCodec = "gif"
+	WEBP imageCodec = "webp"
+)
+
+// codecs lists every supported codec
+var codecs = []imageCodec{JPEG, PNG, GIF, WEBP}
+
+// parseCodec validates a codec name such as "png"
+func parseCodec(name string) (imageCodec, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "jpg" {
+		name = string(JPEG)
+	}
+	for _, c := range codecs {
+		if string(c) == name {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown codec %q", name)
+}
+
+// codecEnabled reports whether codec is one of enabled, all codecs are enabled when none is listed
+func codecEnabled(enabled []imageCodec, codec imageCodec) bool {
+	if len(enabled) == 0 {
+		return true
+	}
+	for _, c := range enabled {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// mimeType is the content-type of the codec images
+func (c imageCodec) mimeType() string {
+	return "image/" + string(c)
+}
+
+// acceptHeader lists the content-types of the enabled codecs, every codec when none is listed
+func acceptHeader(enabled []imageCodec) string {
+	if len(enabled) == 0 {
+		enabled = codecs
+	}
+	types := make([]string, 0, len(enabled))
+	for _, c := range enabled {
+		types = append(types, c.mimeType())
+	}
+	return strings.Join(types, ", ")
+}
+
+// codecForContentType maps a content-type header to its codec, empty when unsupported
+func codecForContentType(contentType string) imageCodec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case "image/jpeg":
+		return JPEG
+	case "image/png":
+		return PNG
+	case "image/gif":
+		return GIF
+	case "image/webp":
+		return WEBP
+	default:
+		return ""
+	}
+}
+
+// isImageType reports whether contentType is an image one, supported or not
+func isImageType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.HasPrefix(mediaType, "image/")
+}
+
+// sniffCodec detects the codec of an image file from its first bytes
+func sniffCodec(filename string) (imageCodec, error) {
+	head, err := readHead(filename)
+	if err != nil {
+		return "", err
+	}
+	return codecForContentType(http.DetectContentType(head)), nil
+}
+
+// readHead returns the first bytes of a file, as many as content sniffing considers
+func readHead(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// getImageDimensions reads only the image header, so it is cheap even for huge files
+func getImageDimensions(filename string, codec imageCodec) (int, int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	imageCfg, err := decodeConfig(file, codec)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return imageCfg.Width, imageCfg.Height, nil
+}
+
+// maxHeaderBytes bounds how much of a stream is buffered to read image dimensions
+const maxHeaderBytes = 1 << 20
+
+// peekDimensions reads the dimensions from the buffered head of an image stream
+// without consuming it, so the whole image never needs to sit in memory.
+// br must have been created with a size of maxHeaderBytes.
+func peekDimensions(br *bufio.Reader, codec imageCodec) (int, int, error) {
+	head, err := br.Peek(maxHeaderBytes)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+
+	imageCfg, err := decodeConfig(bytes.NewReader(head), codec)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return imageCfg.Width, imageCfg.Height, nil
+}
+
+// configDecoders read the header of the images of each codec
+var configDecoders = map[imageCodec]func(io.Reader) (image.Config, error){
+	JPEG: jpeg.DecodeConfig,
+	PNG:  png.DecodeConfig,
+	// the logical screen, which every frame fits in
+	GIF:  gif.DecodeConfig,
+	WEBP: webp.DecodeConfig,
+}
+
+func decodeConfig(r io.Reader, codec imageCodec) (image.Config, error) {
+	decode, ok := configDecoders[codec]
+	if !ok {
+		return image.Config{}, errors.New("unsupported file type")
+	}
+	return decode(r)
+}
+
+// checkPixels guards against decompression bombs, it must pass before any full decode
+func checkPixels(width, height int, maxPixels int64) error {
+	if maxPixels > 0 && int64(width)*int64(height) > maxPixels {
+		return fmt.Errorf("image is %dx%d, over the %d pixels limit", width, height, maxPixels)
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lucbarr/earthpornbot/logging"
+)
+
+// withDimensions returns png with its header claiming width x height, the pixels are left as
+// they are
+func withDimensions(png []byte, width, height uint32) []byte {
+	bomb := append([]byte(nil), png...)
+	// the IHDR chunk follows the 8 bytes signature: length, type, then width and height
+	ihdr := bomb[8+4 : 8+4+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	binary.BigEndian.PutUint32(bomb[8+4+4+13:], crc32.ChecksumIEEE(ihdr))
+	return bomb
+}
+
+func TestImagesOverThePixelCapAreRejected(t *testing.T) {
+	defer inTempDir(t)()
+	bomb := withDimensions(testPNG(t, 30, 20, 1), 100000, 100000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(bomb)
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{MaxPixels: 50000000}, srv, post("bomb", srv.URL+"/bomb.png"))
+	saved, err := r.FetchSubmissionsResults()
+	if err == nil || !strings.Contains(err.Error(), "over the 50000000 pixels limit") {
+		t.Errorf("got %v, want the pixel limit error", err)
+	}
+	if len(saved) != 0 {
+		t.Errorf("saved %+v", saved)
+	}
+	for _, path := range []string{"bomb.png", "bomb.png.part", "hori/bomb.png"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is left: %v", path, err)
+		}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// noisyPNG encodes random pixels, which do not compress, so the image is about 4 bytes a pixel
+func noisyPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPeekDimensionsReadsTheHeadOnly(t *testing.T) {
+	img := noisyPNG(t, 1200, 900)
+	if len(img) <= 2*maxHeaderBytes {
+		t.Fatalf("the image is only %d bytes", len(img))
+	}
+	src := &countingReader{r: bytes.NewReader(img)}
+	br := bufio.NewReaderSize(src, maxHeaderBytes)
+
+	width, height, err := peekDimensions(br, PNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 1200 || height != 900 {
+		t.Errorf("got %dx%d, want 1200x900", width, height)
+	}
+	if src.n > maxHeaderBytes {
+		t.Errorf("read %d bytes of the body, want at most %d", src.n, maxHeaderBytes)
+	}
+
+	// the head is still there for the copy
+	rest, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, img) {
+		t.Error("peeking consumed the stream")
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    imageCodec
+		wantErr bool
+	}{
+		{"png", PNG, false},
+		{" JPEG ", JPEG, false},
+		{"jpg", JPEG, false},
+		{"webp", WEBP, false},
+		{"avif", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseCodec(tt.name)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseCodec(%q) = %q, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestDisabledCodecsAreSkipped(t *testing.T) {
+	defer inTempDir(t)()
+	var photo bytes.Buffer
+	err := jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 30, 20)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[string][]byte{"a.png": testPNG(t, 30, 20, 1), "b.jpg": photo.Bytes()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{EnabledCodecs: []imageCodec{PNG}, AllowedExtensions: []string{"png", "jpg"}}, srv,
+		post("a", srv.URL+"/a.png"), post("b", srv.URL+"/b.jpg"))
+	var out bytes.Buffer
+	r.Logger, err = logging.New(&out, logging.Info, logging.Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].Path != filepath.Join("hori", "a.png") {
+		t.Errorf("saved %+v, want the png only", saved)
+	}
+	_, err = os.Stat(filepath.Join("hori", "b.jpg"))
+	if !os.IsNotExist(err) {
+		t.Errorf("the jpeg was kept: %v", err)
+	}
+	if !strings.Contains(out.String(), `reason="codec not enabled" codec=jpeg`) {
+		t.Errorf("logged %q, want the jpeg skipped as not enabled", out.String())
+	}
+}
+
+func TestAcceptHeaderListsTheEnabledCodecs(t *testing.T) {
+	img := testPNG(t, 30, 20, 1)
+	accepted := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		accepted <- req.Header.Get("Accept")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		enabled []imageCodec
+		want    string
+	}{
+		{"every codec by default", nil, "image/jpeg, image/png, image/gif, image/webp"},
+		{"png only", []imageCodec{PNG}, "image/png"},
+		{"png and webp", []imageCodec{PNG, WEBP}, "image/png, image/webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			r := newTestReddit(&Config{EnabledCodecs: tt.enabled}, srv, post("a", srv.URL+"/a.png"))
+			err := r.FetchSubmissions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := <-accepted; got != tt.want {
+				t.Errorf("Accept: %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecoderPanicsFailTheirImageOnly(t *testing.T) {
+	defer inTempDir(t)()
+	decode := configDecoders[PNG]
+	defer func() { configDecoders[PNG] = decode }()
+	configDecoders[PNG] = func(r io.Reader) (image.Config, error) {
+		cfg, err := decode(r)
+		if err != nil {
+			panic(err)
+		}
+		return cfg, nil
+	}
+
+	images := map[string][]byte{
+		"good.png": testPNG(t, 30, 20, 1),
+		"bad.png":  []byte("\x89PNG\r\n\x1a\nnot really a png"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{}, srv, post("bad", srv.URL+"/bad.png"), post("good", srv.URL+"/good.png"))
+	var out bytes.Buffer
+	var err error
+	r.Logger, err = logging.New(&out, logging.Info, logging.Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := r.FetchSubmissionsResults()
+	if err == nil || !strings.Contains(err.Error(), srv.URL+"/bad.png: decoder panic") {
+		t.Errorf("got %v, want the panic of bad.png reported", err)
+	}
+	if len(saved) != 1 || saved[0].Path != filepath.Join("hori", "good.png") {
+		t.Errorf("saved %+v, want good.png", saved)
+	}
+	for _, pattern := range []string{"bad.png*", filepath.Join("*", "bad.png*")} {
+		leftovers, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(leftovers) > 0 {
+			t.Errorf("kept %v", leftovers)
+		}
+	}
+	if !strings.Contains(out.String(), `msg="recovered decoding" url=`+srv.URL+"/bad.png") {
+		t.Errorf("logged %q, want the panic", out.String())
+	}
+}
+
+// webpHeader is the start of a lossless WebP of width x height, enough for its config
+func webpHeader(width, height int) []byte {
+	bits := uint32(width-1) | uint32(height-1)<<14
+	vp8l := []byte{0x2f, byte(bits), byte(bits >> 8), byte(bits >> 16), byte(bits >> 24), 0, 0, 0}
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+8+len(vp8l)))
+	buf.WriteString("WEBPVP8L")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(vp8l)))
+	buf.Write(vp8l)
+	return buf.Bytes()
+}
+
+func TestWebPAndGIFAreClassified(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{
+		"wide.webp": webpHeader(60, 40),
+		"tall.webp": webpHeader(40, 60),
+		"wide.gif":  testGIF(t, 60, 40, 1),
+		// animated GIFs are classified by their logical screen
+		"tall.gif": testGIF(t, 40, 60, 3),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for name := range images {
+		posts = append(posts, post(name, srv.URL+"/"+name))
+	}
+	r := newTestReddit(&Config{AllowedExtensions: []string{"webp", "gif"}}, srv, posts...)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != len(images) {
+		t.Fatalf("saved %+v, want every image", saved)
+	}
+	for _, d := range saved {
+		name := filepath.Base(d.Path)
+		want := filepath.Join("hori", name)
+		if strings.HasPrefix(name, "tall") {
+			want = filepath.Join("vert", name)
+		}
+		if d.Path != want {
+			t.Errorf("%s saved to %s, want %s", name, d.Path, want)
+		}
+	}
+}
+
+func TestCodecForContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		codec       imageCodec
+	}{
+		{"image/jpeg", JPEG},
+		{"image/png", PNG},
+		{"image/gif", GIF},
+		{"image/webp", WEBP},
+		{"Image/PNG; charset=binary", PNG},
+		{"image/bmp", ""},
+		{"application/octet-stream", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if codec := codecForContentType(test.contentType); codec != test.codec {
+			t.Errorf("codecForContentType(%q) = %q, want %q", test.contentType, codec, test.codec)
+		}
+	}
+}
+
+func TestTheContentDecidesTheType(t *testing.T) {
+	png := testPNG(t, 60, 40, 1)
+	// the header of a 2x1 bitmap, which no decoder handles
+	bmp := append([]byte("BM"), make([]byte, 60)...)
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		unsupported string
+		want        string
+	}{
+		{"mislabeled", png, "image/jpeg", "", filepath.Join("hori", "a.png")},
+		{"unlabeled", png, "application/octet-stream", "", filepath.Join("hori", "a.png")},
+		{"mislabeled unsupported", bmp, "image/png", "other", filepath.Join("other", "a.png")},
+		{"unsupported kept aside", bmp, "image/bmp", "other", filepath.Join("other", "a.png")},
+		{"unsupported skipped", bmp, "image/bmp", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+
+			r := newTestReddit(&Config{UnsupportedDir: tt.unsupported}, srv, post("a", srv.URL+"/a.png"))
+			_, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, dir := range []string{"hori", "vert", "other"} {
+				path := filepath.Join(dir, "a.png")
+				if _, err := os.Stat(path); (err == nil) != (path == tt.want) {
+					t.Errorf("%s exists %v, want the image in %q", path, err == nil, tt.want)
+				}
+			}
+		})
+	}
+}
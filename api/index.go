@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// indexEntry is a line of the append-only index of downloaded images
+type indexEntry struct {
+	// ID is the reddit id of the submission, Gallery the position of the image in its gallery
+	// from 1, 0 outside galleries
+	ID      string `json:"id,omitempty"`
+	Gallery int    `json:"gallery,omitempty"`
+	URL     string `json:"url"`
+	Path    string `json:"path"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	// PHash is the hex perceptual hash, only set when dedupe is enabled
+	PHash string `json:"phash,omitempty"`
+	// Subreddit is where the image was found
+	Subreddit string `json:"subreddit,omitempty"`
+	// Title, Author and Permalink describe the submission, for posting the image elsewhere
+	Title     string `json:"title,omitempty"`
+	Author    string `json:"author,omitempty"`
+	Permalink string `json:"permalink,omitempty"`
+	Score     int    `json:"score,omitempty"`
+	// Orientation is hori or vert, as the image was classified
+	Orientation string `json:"orientation,omitempty"`
+}
+
+// key identifies the image of e in the store
+func (e indexEntry) key() string {
+	return imageKey(e.ID, e.Gallery)
+}
+
+// index keeps track of the images downloaded across runs, an empty path keeps it in memory only
+type index struct {
+	path string
+
+	mu     sync.Mutex
+	hashes []indexedHash
+	// seen is every hash met during the current run, even the skipped ones
+	seen []uint64
+	// duplicates are the images of the current run similar to an earlier one
+	duplicates []duplicate
+}
+
+// indexedHash is the perceptual hash of an image, by the path or link it is known by
+type indexedHash struct {
+	hash   uint64
+	source string
+}
+
+// loadIndex reads the index at path, a missing file is an empty index
+func loadIndex(path string) (*index, error) {
+	idx := &index{path: path}
+	entries, err := readIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		err = idx.addHash(entry.PHash, entry.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// addHash records the hex perceptual hash of the image at source, an empty hash is ignored
+func (i *index) addHash(hexHash, source string) error {
+	if hexHash == "" {
+		return nil
+	}
+	hash, err := strconv.ParseUint(hexHash, 16, 64)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.hashes = append(i.hashes, indexedHash{hash, source})
+	return nil
+}
+
+// readIndex returns the entries of the index at path, oldest first
+func readIndex(path string) ([]indexEntry, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []indexEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry indexEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// claimHash records the hash of the image at source unless an indexed image is within
+// threshold bits of it, which is then returned as a duplicate
+func (i *index) claimHash(hash uint64, source string, threshold int) (duplicate, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, h := range i.hashes {
+		if d := hammingDistance(h.hash, hash); d <= threshold {
+			return duplicate{URL: source, SimilarTo: h.source, Distance: d}, false
+		}
+	}
+	i.hashes = append(i.hashes, indexedHash{hash, source})
+	return duplicate{}, true
+}
+
+// releaseHash forgets the hash claimed for source, whose image was not kept after all
+func (i *index) releaseHash(hash uint64, source string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for n, h := range i.hashes {
+		if h.hash == hash && h.source == source {
+			i.hashes = append(i.hashes[:n], i.hashes[n+1:]...)
+			return
+		}
+	}
+}
+
+// addDuplicate records d in the report of the current run
+func (i *index) addDuplicate(d duplicate) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.duplicates = append(i.duplicates, d)
+}
+
+// runDuplicates returns the duplicates met during the current run
+func (i *index) runDuplicates() []duplicate {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return append([]duplicate(nil), i.duplicates...)
+}
+
+// seeHash records hash as met during the current run
+func (i *index) seeHash(hash uint64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.seen = append(i.seen, hash)
+}
+
+// runHashes returns the hashes met during the current run
+func (i *index) runHashes() []uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return append([]uint64(nil), i.seen...)
+}
+
+// add appends entry to the index file
+func (i *index) add(entry indexEntry) error {
+	if i.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	file, err := os.OpenFile(i.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// remove drops the images at paths from the index file and from the hashes it holds
+func (i *index) remove(paths map[string]bool) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	hashes := i.hashes[:0]
+	for _, h := range i.hashes {
+		if !paths[filepath.ToSlash(h.source)] {
+			hashes = append(hashes, h)
+		}
+	}
+	i.hashes = hashes
+	return removeIndexed(i.path, paths)
+}
+
+// removeIndexed rewrites the index at path without the entries of the images at paths
+func removeIndexed(path string, paths map[string]bool) error {
+	if path == "" || len(paths) == 0 {
+		return nil
+	}
+	entries, err := readIndex(path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	removed := false
+	for _, entry := range entries {
+		if paths[filepath.ToSlash(entry.Path)] {
+			removed = true
+			continue
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if !removed {
+		return nil
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+// hostLimiter caps the simultaneous downloads from a single host
+type hostLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// newHostLimiter creates a limiter allowing limit downloads per host, 0 means unlimited
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit: limit,
+		hosts: map[string]chan struct{}{},
+	}
+}
+
+// acquire blocks until the host of rawURL has a free slot, the returned func releases it.
+// It gives up with ctx, returning its error.
+func (l *hostLimiter) acquire(ctx context.Context, rawURL string) (func(), error) {
+	if l.limit <= 0 {
+		return func() {}, nil
+	}
+
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Hostname()
+	}
+
+	l.mu.Lock()
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, l.limit)
+		l.hosts[host] = sem
+	}
+	l.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// workLimiter caps the operations of a run working at once, across the listing, the downloads,
+// the link resolution, the previews, the variants and the publishing. A nil limiter is unbounded.
+type workLimiter chan struct{}
+
+// newWorkLimiter creates a limiter allowing limit operations at once, 0 means unlimited
+func newWorkLimiter(limit int) workLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return make(workLimiter, limit)
+}
+
+// acquire blocks until a slot is free, the returned func releases it. It gives up with ctx,
+// returning its error.
+func (l workLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l <- struct{}{}:
+		return func() { <-l }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPerHostConcurrencyIsCapped(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	var mu sync.Mutex
+	running, most := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for i := 0; i < 12; i++ {
+		posts = append(posts, post(fmt.Sprint(i), fmt.Sprintf("%s/%d.png", srv.URL, i)))
+	}
+	r := newTestReddit(&Config{Concurrency: 8, PerHostConcurrency: 2}, srv, posts...)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != len(posts) {
+		t.Errorf("saved %d images, want %d", len(saved), len(posts))
+	}
+	if most > 2 {
+		t.Errorf("%d simultaneous downloads from the host, want at most 2", most)
+	}
+}
+
+func TestWaitingForAHostGivesUpWithTheContext(t *testing.T) {
+	l := newHostLimiter(1)
+	release, err := l.acquire(context.Background(), "https://i.redd.it/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, "https://i.redd.it/b.png")
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v waiting for the busy host, want %v", err, context.DeadlineExceeded)
+	}
+
+	// another host has its own slots
+	other, err := l.acquire(context.Background(), "https://i.imgur.com/c.png")
+	if err != nil {
+		t.Errorf("got %v for another host", err)
+	} else {
+		other()
+	}
+}
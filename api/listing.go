@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// PopularitySort is the order of a subreddit listing
+type PopularitySort string
+
+const (
+	HotSubmissions    PopularitySort = "hot"
+	NewSubmissions    PopularitySort = "new"
+	TopSubmissions    PopularitySort = "top"
+	RisingSubmissions PopularitySort = "rising"
+	// ControversialSubmissions lists by the ratio of up and down votes
+	ControversialSubmissions PopularitySort = "controversial"
+)
+
+// submission is a listed post
+type submission struct {
+	ID string `json:"id"`
+	// FullID is the id prefixed by the kind, which the listings paginate by
+	FullID        string  `json:"name"`
+	Author        string  `json:"author"`
+	Title         string  `json:"title"`
+	URL           string  `json:"url"`
+	Subreddit     string  `json:"subreddit"`
+	Permalink     string  `json:"permalink"`
+	ThumbnailURL  string  `json:"thumbnail"`
+	DateCreated   float64 `json:"created_utc"`
+	Score         int     `json:"score"`
+	IsNSFW        bool    `json:"over_18"`
+	LinkFlairText string  `json:"link_flair_text"`
+
+	Media       *submissionMedia `json:"media"`
+	SecureMedia *submissionMedia `json:"secure_media"`
+	Preview     *struct {
+		Images []struct {
+			Resolutions []previewImage `json:"resolutions"`
+		} `json:"images"`
+	} `json:"preview"`
+
+	IsGallery     bool                    `json:"is_gallery"`
+	GalleryData   *galleryData            `json:"gallery_data"`
+	MediaMetadata map[string]galleryMedia `json:"media_metadata"`
+
+	// page is the listing page the submission was sent from
+	page *listingPage
+	// gallery is the position of the image in its gallery from 1, 0 outside galleries
+	gallery int
+}
+
+// key identifies the image of the submission in the store
+func (s *submission) key() string {
+	return imageKey(s.ID, s.gallery)
+}
+
+// imageKey is the id of a submission, followed by the position of the image for the images of
+// galleries, so each image of a gallery is recorded on its own
+func imageKey(id string, gallery int) string {
+	if gallery == 0 || id == "" {
+		return id
+	}
+	return id + "_" + strconv.Itoa(gallery)
+}
+
+// FullPermalink is the link to the comments of the submission
+func (s *submission) FullPermalink() string {
+	return "https://reddit.com" + s.Permalink
+}
+
+// previewImage is a resized copy of the submission image generated by Reddit
+type previewImage struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// previewResolutions returns the resized copies of the submission image, smallest first
+func (s *submission) previewResolutions() []previewImage {
+	if s.Preview == nil || len(s.Preview.Images) == 0 {
+		return nil
+	}
+	return s.Preview.Images[0].Resolutions
+}
+
+// submissionMedia is the embedded media of video and embed posts
+type submissionMedia struct {
+	RedditVideo *struct {
+		FallbackURL string `json:"fallback_url"`
+	} `json:"reddit_video"`
+	OEmbed *struct {
+		ThumbnailURL string `json:"thumbnail_url"`
+	} `json:"oembed"`
+}
+
+// mediaURLs returns the links found in the embedded media, secure ones first
+func (s *submission) mediaURLs() []string {
+	var urls []string
+	for _, m := range []*submissionMedia{s.SecureMedia, s.Media} {
+		if m == nil {
+			continue
+		}
+		if m.RedditVideo != nil && m.RedditVideo.FallbackURL != "" {
+			urls = append(urls, m.RedditVideo.FallbackURL)
+		}
+		if m.OEmbed != nil && m.OEmbed.ThumbnailURL != "" {
+			urls = append(urls, m.OEmbed.ThumbnailURL)
+		}
+	}
+	return urls
+}
+
+// listingURL is where the subreddit listings are read from
+const listingURL = "https://oauth.reddit.com/r/%s/%s.json?%s"
+
+// listingOptions select the page of a listing
+type listingOptions struct {
+	Limit int
+	// After is the FullID of the last submission of the previous page
+	After string
+	// Time is the window of a top listing
+	Time string
+}
+
+// listPage reads a page of the subreddit listing
+func (r *Reddit) listPage(ctx context.Context, subreddit string, sort PopularitySort, opts listingOptions) ([]*submission, error) {
+	if r.API == nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+
+	params := url.Values{}
+	// without raw_json reddit escapes the & of the links
+	params.Set("raw_json", "1")
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.After != "" {
+		params.Set("after", opts.After)
+	}
+	if opts.Time != "" {
+		params.Set("t", opts.Time)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(listingURL, subreddit, sort, params.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.API.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing r/%s: unexpected status %d", subreddit, resp.StatusCode)
+	}
+
+	var listing struct {
+		Data struct {
+			Children []struct {
+				Data *submission
+			}
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&listing)
+	if err != nil {
+		return nil, err
+	}
+
+	page := make([]*submission, 0, len(listing.Data.Children))
+	for _, child := range listing.Data.Children {
+		if child.Data != nil {
+			page = append(page, child.Data)
+		}
+	}
+	return page, nil
+}
+
+// timeRanges are the windows a top or controversial listing can cover
+var timeRanges = []string{"hour", "day", "week", "month", "year", "all"}
+
+// checkListing validates the sort and time range of the listing
+func checkListing(sort PopularitySort, timeRange string) error {
+	switch sort {
+	case HotSubmissions, NewSubmissions, TopSubmissions, RisingSubmissions, ControversialSubmissions:
+	default:
+		return fmt.Errorf("subreddit.submissions.sort must be hot, new, top, rising or controversial, got %q", sort)
+	}
+	if timeRange == "" {
+		return nil
+	}
+	for _, t := range timeRanges {
+		if t == timeRange {
+			return nil
+		}
+	}
+	return fmt.Errorf("subreddit.submissions.timeRange must be one of %v, got %q", timeRanges, timeRange)
+}
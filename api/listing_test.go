@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// listed decodes a submission as the listings send it
+func listed(t *testing.T, raw string) *submission {
+	t.Helper()
+	var s submission
+	err := json.Unmarshal([]byte(raw), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+func TestMediaURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{"none", `{"url": "https://v.redd.it/abc"}`, nil},
+		{"video", `{"media": {"reddit_video": {"fallback_url": "https://v.redd.it/abc/DASH_720.mp4"}}}`,
+			[]string{"https://v.redd.it/abc/DASH_720.mp4"}},
+		{"secure first", `{"media": {"oembed": {"thumbnail_url": "http://i.ytimg.com/a.jpg"}},
+			"secure_media": {"oembed": {"thumbnail_url": "https://i.ytimg.com/a.jpg"}}}`,
+			[]string{"https://i.ytimg.com/a.jpg", "http://i.ytimg.com/a.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listed(t, tt.raw).mediaURLs()
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaURLIsUsedWithoutAnImageLink(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	embed := listed(t, fmt.Sprintf(`{"id": "embed", "name": "t3_embed", "url": "https://youtu.be/abc",
+		"secure_media": {"oembed": {"thumbnail_url": "%s/thumb.png"}}}`, srv.URL))
+	r := newTestReddit(&Config{}, srv, embed)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].URL != srv.URL+"/thumb.png" || saved[0].Path != filepath.Join("hori", "thumb.png") {
+		t.Errorf("saved %+v, want the media thumbnail", saved)
+	}
+}
+
+func TestCheckListing(t *testing.T) {
+	tests := []struct {
+		sort      PopularitySort
+		timeRange string
+		ok        bool
+	}{
+		{HotSubmissions, "", true},
+		{NewSubmissions, "", true},
+		{RisingSubmissions, "", true},
+		{TopSubmissions, "week", true},
+		{TopSubmissions, "all", true},
+		{TopSubmissions, "hour", true},
+		{TopSubmissions, "day", true},
+		{TopSubmissions, "month", true},
+		{ControversialSubmissions, "year", true},
+		{"best", "", false},
+		{"", "", false},
+		{TopSubmissions, "fortnight", false},
+	}
+	for _, tt := range tests {
+		err := checkListing(tt.sort, tt.timeRange)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkListing(%q, %q) = %v", tt.sort, tt.timeRange, err)
+		}
+	}
+}
+
+func TestListingsAreSortedAsConfigured(t *testing.T) {
+	tests := []struct {
+		sort      PopularitySort
+		timeRange string
+		path      string
+		window    string
+	}{
+		{HotSubmissions, "", "/r/earthporn/hot.json", ""},
+		{NewSubmissions, "", "/r/earthporn/new.json", ""},
+		{RisingSubmissions, "", "/r/earthporn/rising.json", ""},
+		{TopSubmissions, "week", "/r/earthporn/top.json", "week"},
+		{TopSubmissions, "all", "/r/earthporn/top.json", "all"},
+		{ControversialSubmissions, "day", "/r/earthporn/controversial.json", "day"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.sort)+tt.timeRange, func(t *testing.T) {
+			defer inTempDir(t)()
+			r := newTestReddit(&Config{Sort: tt.sort, TimeRange: tt.timeRange}, nil)
+			api := &recordingAPI{}
+			r.API = api
+			err := r.FetchSubmissions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(api.paths) != 1 || api.paths[0] != tt.path || api.queries[0].Get("t") != tt.window {
+				t.Errorf("listed %v %v, want %s with t=%s", api.paths, api.queries, tt.path, tt.window)
+			}
+		})
+	}
+
+	r := newTestReddit(&Config{Sort: "best"}, nil)
+	r.API = &recordingAPI{}
+	err := r.FetchSubmissions()
+	if err == nil || !strings.Contains(err.Error(), `got "best"`) {
+		t.Errorf("got %v, want the invalid sort reported", err)
+	}
+
+	api := &recordingAPI{}
+	r = newTestReddit(&Config{Sort: TopSubmissions, TimeRange: "fortnight"}, nil)
+	r.API = api
+	err = r.FetchSubmissions()
+	if err == nil || !strings.Contains(err.Error(), `got "fortnight"`) || len(api.paths) != 0 {
+		t.Errorf("got %v after listing %v, want the invalid time range reported first", err, api.paths)
+	}
+}
+
+func TestListingConfig(t *testing.T) {
+	defer viper.Reset()
+	tests := []struct {
+		name      string
+		config    string
+		sort      PopularitySort
+		timeRange string
+	}{
+		{"defaults", "", HotSubmissions, ""},
+		{"top of the week", "sort: top\n    timeRange: week", TopSubmissions, "week"},
+		{"time", "sort: top\n    time: month", TopSubmissions, "month"},
+		{"timeRange over time", "sort: top\n    time: month\n    timeRange: day", TopSubmissions, "day"},
+		{"invalid sort kept", "sort: best", "best", ""},
+	}
+	for _, tt := range tests {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(strings.NewReader("subreddit:\n  submissions:\n    " + tt.config + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := LoadConfig()
+		if cfg.Sort != tt.sort || cfg.TimeRange != tt.timeRange {
+			t.Errorf("%s: got %q %q, want %q %q", tt.name, cfg.Sort, cfg.TimeRange, tt.sort, tt.timeRange)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/lucbarr/earthpornbot/storage"
+)
+
+// ManifestRecord describes a downloaded image and the submission it comes from,
+// enough to credit it when posting it elsewhere. Gallery is the position of the image in its
+// gallery from 1, 0 outside galleries.
+type ManifestRecord struct {
+	ID          string  `json:"id,omitempty"`
+	Gallery     int     `json:"gallery,omitempty"`
+	URL         string  `json:"url"`
+	Path        string  `json:"path"`
+	Subreddit   string  `json:"subreddit,omitempty"`
+	Title       string  `json:"title,omitempty"`
+	Author      string  `json:"author,omitempty"`
+	Permalink   string  `json:"permalink,omitempty"`
+	Score       int     `json:"score"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	AspectRatio float64 `json:"aspectRatio"`
+	// Orientation is hori or vert
+	Orientation string `json:"orientation"`
+}
+
+// manifestRecord is the record describing an index entry
+func manifestRecord(e indexEntry) ManifestRecord {
+	rec := ManifestRecord{
+		ID:        e.ID,
+		Gallery:   e.Gallery,
+		URL:       e.URL,
+		Path:      e.Path,
+		Subreddit: e.Subreddit,
+		Title:     e.Title,
+		Author:    e.Author,
+		Permalink: e.Permalink,
+		Score:     e.Score,
+		Width:     e.Width,
+		Height:    e.Height,
+	}
+	if e.Height > 0 {
+		rec.AspectRatio = float64(e.Width) / float64(e.Height)
+	}
+	// the orientation of images indexed without one follows from their size
+	switch {
+	case e.Orientation != "":
+		rec.Orientation = e.Orientation
+	case rec.AspectRatio > 1:
+		rec.Orientation = "hori"
+	default:
+		rec.Orientation = "vert"
+	}
+	return rec
+}
+
+// Manifest returns the records of every image in the index, oldest first. Images deleted
+// since they were downloaded are still listed.
+func (r *Reddit) Manifest() ([]ManifestRecord, error) {
+	if r.cfg.Index == "" {
+		return nil, errors.New("the manifest is read from subreddit.output.index, which is not set")
+	}
+	entries, err := readIndex(r.cfg.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]ManifestRecord, 0, len(entries))
+	for _, e := range entries {
+		records = append(records, manifestRecord(e))
+	}
+	return records, nil
+}
+
+// sidecarPath is where the sidecar of the image at path is written
+func sidecarPath(path string) string {
+	return path + ".json"
+}
+
+// writeSidecar writes the record of e next to its image, and copies it wherever the image went
+func (r *Reddit) writeSidecar(e indexEntry) error {
+	data, err := json.MarshalIndent(manifestRecord(e), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := sidecarPath(e.Path)
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range []storage.Backend{r.output, r.mirrors} {
+		if s == nil {
+			continue
+		}
+		err = mirror(s, path)
+		if err != nil {
+			os.Remove(path)
+			return err
+		}
+	}
+	r.dropLocalCopy(path)
+	return nil
+}
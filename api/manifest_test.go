@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifestRecord(t *testing.T) {
+	tests := []struct {
+		name        string
+		entry       indexEntry
+		aspect      float64
+		orientation string
+	}{
+		{"classified", indexEntry{Width: 40, Height: 60, Orientation: "hori"}, 40.0 / 60, "hori"},
+		{"landscape", indexEntry{Width: 60, Height: 40}, 1.5, "hori"},
+		{"portrait", indexEntry{Width: 40, Height: 60}, 40.0 / 60, "vert"},
+		{"square", indexEntry{Width: 40, Height: 40}, 1, "vert"},
+		{"no size", indexEntry{}, 0, "vert"},
+	}
+	for _, test := range tests {
+		rec := manifestRecord(test.entry)
+		if rec.AspectRatio != test.aspect || rec.Orientation != test.orientation {
+			t.Errorf("%s: got %v %s, want %v %s", test.name, rec.AspectRatio, rec.Orientation, test.aspect, test.orientation)
+		}
+	}
+}
+
+func TestSidecarsAndManifestDescribeTheSubmissions(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 60, 40, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	p := post("lake", srv.URL+"/lake.png")
+	p.Title, p.Author, p.Permalink, p.Score = "Lake Bled [OC]", "photographer", "/r/EarthPorn/comments/lake/", 1234
+	r := newTestReddit(&Config{Index: "index.jsonl", Sidecars: true, Mirrors: []Mirror{{Dir: "mirror"}}}, srv, p)
+	_, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ManifestRecord{
+		ID:          "lake",
+		URL:         srv.URL + "/lake.png",
+		Path:        filepath.Join("hori", "lake.png"),
+		Subreddit:   "earthporn",
+		Title:       "Lake Bled [OC]",
+		Author:      "photographer",
+		Permalink:   p.FullPermalink(),
+		Score:       1234,
+		Width:       60,
+		Height:      40,
+		AspectRatio: 1.5,
+		Orientation: "hori",
+	}
+	for _, path := range []string{sidecarPath(want.Path), filepath.Join("mirror", "hori", "lake.png.json")} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sidecar ManifestRecord
+		err = json.Unmarshal(data, &sidecar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sidecar != want {
+			t.Errorf("%s is %+v, want %+v", path, sidecar, want)
+		}
+	}
+
+	records, err := r.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, []ManifestRecord{want}) {
+		t.Errorf("the manifest is %+v, want %+v", records, want)
+	}
+}
+
+func TestManifestNeedsTheIndex(t *testing.T) {
+	defer inTempDir(t)()
+	_, err := newTestReddit(&Config{}, nil).Manifest()
+	if err == nil {
+		t.Error("read a manifest without an index")
+	}
+	// an index not written yet is empty
+	records, err := newTestReddit(&Config{Index: "index.jsonl"}, nil).Manifest()
+	if err != nil || len(records) != 0 {
+		t.Errorf("got %+v, %v, want an empty manifest", records, err)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/lucbarr/earthpornbot/metrics"
+)
+
+var (
+	submissionsListed = metrics.NewCounter("earthpornbot_submissions_listed_total",
+		"Submissions listed and handed to the downloads.", "subreddit")
+	imagesDownloaded = metrics.NewCounter("earthpornbot_images_downloaded_total",
+		"Images downloaded and classified.", "orientation")
+	downloadedBytes = metrics.NewCounter("earthpornbot_downloaded_bytes_total",
+		"Bytes of the image downloads, skipped images included.")
+	downloadDuration = metrics.NewHistogram("earthpornbot_download_duration_seconds",
+		"Time taken by the image downloads, from the request to the last byte.", metrics.DefaultBuckets)
+	downloadErrors = metrics.NewCounter("earthpornbot_download_errors_total",
+		"Failed image downloads, by type of error.", "type")
+	postsPublished = metrics.NewCounter("earthpornbot_posts_published_total",
+		"Images posted, by publisher.", "publisher")
+)
+
+// errorType labels a failed download in the metrics: status, timeout, network, canceled or other
+func errorType(err error) string {
+	var status *statusError
+	if errors.As(err, &status) {
+		return "status"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return "network"
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "canceled"
+	}
+	return "other"
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedditClient sends requests to the Reddit API on behalf of the bot account
+type RedditClient interface {
+	// Login gets an access token for the account
+	Login(ctx context.Context) error
+	// Do sends req with the access token
+	Do(req *http.Request) (*http.Response, error)
+}
+
+const (
+	tokenURL = "https://www.reddit.com/api/v1/access_token"
+	// userAgent identifies the bot, Reddit throttles generic ones
+	userAgent = "bot for r/earthporn by u/earthpornsuperbot"
+	// tokenMargin renews the tokens this long before they expire, so none expires in flight
+	tokenMargin = time.Minute
+)
+
+// Auth modes, selected by credentials.auth-mode
+const (
+	// AuthScript logs in as the account of a script app, with its user name and password
+	AuthScript = "script"
+	// AuthAppOnly gets a token for the app alone, without any account, which is enough to list
+	// public subreddits
+	AuthAppOnly = "app-only"
+	// AuthRefreshToken renews the token of an installed or web app from a refresh token
+	AuthRefreshToken = "refresh-token"
+)
+
+// installedClientGrant is the app-only grant of installed apps, which have no secret
+const installedClientGrant = "https://oauth.reddit.com/grants/installed_client"
+
+// tokenForm returns the token request of the auth mode of cfg, the script one by default
+func tokenForm(cfg *Config) url.Values {
+	form := url.Values{}
+	switch cfg.AuthMode {
+	case AuthAppOnly:
+		if cfg.ClientSecret == "" {
+			form.Set("grant_type", installedClientGrant)
+			form.Set("device_id", "DO_NOT_TRACK_THIS_DEVICE")
+		} else {
+			form.Set("grant_type", "client_credentials")
+		}
+	case AuthRefreshToken:
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", cfg.RefreshToken)
+	default:
+		form.Set("grant_type", "password")
+		form.Set("username", cfg.User)
+		form.Set("password", cfg.Password)
+	}
+	return form
+}
+
+// oauthClient is the RedditClient getting its tokens with the OAuth2 grant of the auth mode.
+// It gets a new token once the token expired, and waits for the rate limit to reset when Reddit
+// says no request is left.
+type oauthClient struct {
+	client       *http.Client
+	clock        Clock
+	clientID     string
+	clientSecret string
+	// form is the token request
+	form url.Values
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	// remaining is the requests left until reset, as of the last answer
+	remaining float64
+	reset     time.Time
+	limited   bool
+}
+
+func newOAuthClient(client *http.Client, clock Clock, cfg *Config) *oauthClient {
+	return &oauthClient{
+		client:       client,
+		clock:        clock,
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		form:         tokenForm(cfg),
+	}
+}
+
+func (c *oauthClient) Login(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(c.form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.clientID, c.clientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request: unexpected status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		// Error is set with a 200 status when the credentials are wrong
+		Error string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return err
+	}
+	if token.Error != "" {
+		return fmt.Errorf("token request: %s", token.Error)
+	}
+	if token.AccessToken == "" {
+		return errors.New("token request: no access token in the answer")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token.AccessToken
+	c.expires = c.clock.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenMargin)
+	return nil
+}
+
+// Do sends req with the access token, logging in first when it is missing or expired.
+// A request without body is sent again once, with a new token, when the token is refused.
+func (c *oauthClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	resp, err := c.do(ctx, req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return resp, err
+	}
+
+	resp.Body.Close()
+	c.mu.Lock()
+	c.token = ""
+	c.mu.Unlock()
+	return c.do(ctx, req)
+}
+
+func (c *oauthClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	token, err := c.validToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not log in: %v", err)
+	}
+	err = c.waitRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(ctx)
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	c.updateRateLimit(resp.Header)
+	return resp, nil
+}
+
+// validToken returns the access token, logging in again when there is none or it expired
+func (c *oauthClient) validToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	token, expires := c.token, c.expires
+	c.mu.Unlock()
+	if token != "" && c.clock.Now().Before(expires) {
+		return token, nil
+	}
+
+	err := c.Login(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token, nil
+}
+
+// waitRateLimit blocks until the rate limit resets when the last answer said none is left
+func (c *oauthClient) waitRateLimit(ctx context.Context) error {
+	c.mu.Lock()
+	wait := time.Duration(0)
+	if c.limited && c.remaining < 1 {
+		wait = c.reset.Sub(c.clock.Now())
+	}
+	c.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.clock.After(wait):
+		return nil
+	}
+}
+
+// updateRateLimit records the X-Ratelimit headers of an answer, answers without them are ignored
+func (c *oauthClient) updateRateLimit(h http.Header) {
+	remaining, err := strconv.ParseFloat(h.Get("X-Ratelimit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseFloat(h.Get("X-Ratelimit-Reset"), 64)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limited = true
+	c.remaining = remaining
+	c.reset = c.clock.Now().Add(time.Duration(reset * float64(time.Second)))
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer answers the token requests with tokens numbered from 1, valid for an hour, and
+// the other requests with 200 when they bear the last token, 401 otherwise
+type tokenServer struct {
+	*httptest.Server
+	logins int32
+	// forms are the token requests received, with their basic auth
+	forms []url.Values
+	users []string
+}
+
+func newTokenServer() *tokenServer {
+	s := &tokenServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/access_token" {
+			want := fmt.Sprintf("bearer token%d", atomic.LoadInt32(&s.logins))
+			if req.Header.Get("Authorization") != want {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			return
+		}
+		err := req.ParseForm()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		user, secret, _ := req.BasicAuth()
+		s.forms = append(s.forms, req.PostForm)
+		s.users = append(s.users, user+":"+secret)
+		n := atomic.AddInt32(&s.logins, 1)
+		fmt.Fprintf(w, `{"access_token":"token%d","expires_in":3600}`, n)
+	}))
+	return s
+}
+
+// client sends every request to the server, the token one included
+func (s *tokenServer) client() *http.Client {
+	target, _ := url.Parse(s.URL)
+	return &http.Client{Transport: redirectTransport{target}}
+}
+
+func TestTokenRequestByAuthMode(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		form url.Values
+		user string
+	}{
+		{
+			name: "script",
+			cfg:  Config{AuthMode: AuthScript, ClientID: "id", ClientSecret: "secret", User: "bot", Password: "hunter2"},
+			form: url.Values{"grant_type": {"password"}, "username": {"bot"}, "password": {"hunter2"}},
+			user: "id:secret",
+		},
+		{
+			name: "app-only",
+			cfg:  Config{AuthMode: AuthAppOnly, ClientID: "id", ClientSecret: "secret"},
+			form: url.Values{"grant_type": {"client_credentials"}},
+			user: "id:secret",
+		},
+		{
+			name: "app-only installed app",
+			cfg:  Config{AuthMode: AuthAppOnly, ClientID: "id"},
+			form: url.Values{"grant_type": {installedClientGrant}, "device_id": {"DO_NOT_TRACK_THIS_DEVICE"}},
+			user: "id:",
+		},
+		{
+			name: "refresh-token",
+			cfg:  Config{AuthMode: AuthRefreshToken, ClientID: "id", RefreshToken: "refresh"},
+			form: url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"refresh"}},
+			user: "id:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTokenServer()
+			defer srv.Close()
+			c := newOAuthClient(srv.client(), RealClock{}, &tt.cfg)
+
+			req, err := http.NewRequest(http.MethodGet, "https://oauth.reddit.com/r/earthporn/hot", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("listing answered %d, want 200 with the token", resp.StatusCode)
+			}
+			if len(srv.forms) != 1 {
+				t.Fatalf("%d token requests, want 1", len(srv.forms))
+			}
+			if got := srv.forms[0].Encode(); got != tt.form.Encode() {
+				t.Errorf("token request %s, want %s", got, tt.form.Encode())
+			}
+			if srv.users[0] != tt.user {
+				t.Errorf("basic auth %q, want %q", srv.users[0], tt.user)
+			}
+		})
+	}
+}
+
+func TestTokensAreRenewed(t *testing.T) {
+	srv := newTokenServer()
+	defer srv.Close()
+	clock := fixedClock(time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+	c := newOAuthClient(srv.client(), &clock, &Config{AuthMode: AuthAppOnly, ClientID: "id"})
+
+	get := func() {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, "https://oauth.reddit.com/r/earthporn/hot", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("answered %d, want 200", resp.StatusCode)
+		}
+	}
+
+	get()
+	get()
+	if n := atomic.LoadInt32(&srv.logins); n != 1 {
+		t.Errorf("%d logins while the token is valid, want 1", n)
+	}
+
+	// renewed ahead of its expiry
+	clock = fixedClock(time.Time(clock).Add(time.Hour - tokenMargin))
+	get()
+	if n := atomic.LoadInt32(&srv.logins); n != 2 {
+		t.Errorf("%d logins once the token expired, want 2", n)
+	}
+
+	// a refused token is renewed and the request sent again
+	atomic.AddInt32(&srv.logins, 1)
+	get()
+	if n := atomic.LoadInt32(&srv.logins); n != 4 {
+		t.Errorf("%d logins once the token was refused, want 4", n)
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		err    string
+	}{
+		{name: "status", status: http.StatusUnauthorized, err: "unexpected status 401"},
+		{name: "error in the answer", status: http.StatusOK, body: `{"error":"invalid_grant"}`, err: "invalid_grant"},
+		{name: "no token", status: http.StatusOK, body: `{}`, err: "no access token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+			target, _ := url.Parse(srv.URL)
+			c := newOAuthClient(&http.Client{Transport: redirectTransport{target}}, RealClock{},
+				&Config{AuthMode: AuthRefreshToken, ClientID: "id", RefreshToken: "refresh"})
+
+			err := c.Login(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want an error with %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateTheCredentialsOfTheAuthMode(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		// missing are the errors expected, none when empty
+		missing []string
+	}{
+		{name: "script", cfg: Config{AuthMode: AuthScript, ClientID: "id", ClientSecret: "secret", User: "bot", Password: "pw"}},
+		{name: "script without password", cfg: Config{AuthMode: AuthScript, ClientID: "id"},
+			missing: []string{"credentials.user", "credentials.password", "credentials.app.client-secret"}},
+		{name: "app-only", cfg: Config{AuthMode: AuthAppOnly, ClientID: "id"}},
+		{name: "app-only without client id", cfg: Config{AuthMode: AuthAppOnly}, missing: []string{"credentials.app.client-id"}},
+		{name: "refresh-token", cfg: Config{AuthMode: AuthRefreshToken, ClientID: "id", RefreshToken: "refresh"}},
+		{name: "refresh-token without token", cfg: Config{AuthMode: AuthRefreshToken, ClientID: "id"},
+			missing: []string{"credentials.refresh-token"}},
+		{name: "unknown", cfg: Config{AuthMode: "oauth", ClientID: "id"}, missing: []string{"credentials.auth-mode"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Sort = HotSubmissions
+			tt.cfg.Limit = 10
+			tt.cfg.AllowedExtensions = []string{"png"}
+			err := tt.cfg.Validate()
+			if len(tt.missing) == 0 {
+				if err != nil {
+					t.Errorf("got %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %v", tt.missing)
+			}
+			for _, key := range tt.missing {
+				if !strings.Contains(err.Error(), key) {
+					t.Errorf("got %v, want an error about %s", err, key)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitIsHonored(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining string
+		reset     string
+		wait      time.Duration
+	}{
+		{"requests left", "5", "0.3", 0},
+		{"none left", "0", "0.3", 300 * time.Millisecond},
+		{"no headers", "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == "/api/v1/access_token" {
+					fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+					return
+				}
+				if tt.remaining != "" {
+					w.Header().Set("X-Ratelimit-Remaining", tt.remaining)
+					w.Header().Set("X-Ratelimit-Reset", tt.reset)
+				}
+			}))
+			defer srv.Close()
+			target, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := newOAuthClient(&http.Client{Transport: redirectTransport{target}}, RealClock{},
+				&Config{AuthMode: AuthAppOnly, ClientID: "id"})
+
+			get := func(ctx context.Context) error {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://oauth.reddit.com/r/earthporn/hot", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := c.Do(req)
+				if err == nil {
+					resp.Body.Close()
+				}
+				return err
+			}
+			err = get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			err = get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			if elapsed < tt.wait || (tt.wait == 0 && elapsed > 100*time.Millisecond) {
+				t.Errorf("the next request waited %v, want %v", elapsed, tt.wait)
+			}
+
+			if tt.wait > 0 {
+				// the wait gives up with the context of the request
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				err = get(ctx)
+				if err != context.DeadlineExceeded {
+					t.Errorf("got %v while waiting, want %v", err, context.DeadlineExceeded)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitWaitsOnTheClock(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/v1/access_token" {
+			fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+			return
+		}
+		w.Header().Set("X-Ratelimit-Remaining", "0")
+		w.Header().Set("X-Ratelimit-Reset", "0.001")
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the reset is a millisecond away on the wall clock, and never comes on the fixed one
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := newOAuthClient(&http.Client{Transport: redirectTransport{target}}, fixedClock(now),
+		&Config{AuthMode: AuthAppOnly, ClientID: "id"})
+
+	get := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://oauth.reddit.com/r/earthporn/hot", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	err = get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = get(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want the request waiting on the clock until %v", err, context.DeadlineExceeded)
+	}
+}
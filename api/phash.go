@@ -0,0 +1,73 @@
+package api
+
+import (
+	"image"
+	"math/bits"
+	"os"
+)
+
+// perceptualHash computes the 64 bits difference hash (dHash) of an image file:
+// the image is shrunk to 9x8 gray cells and each bit tells whether a cell is
+// brighter than its right neighbour, so re-encodes and resizes hash alike.
+func perceptualHash(filename string) (uint64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+
+	const cols, rows = 9, 8
+	var cells [rows][cols]float64
+	b := img.Bounds()
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			cells[y][x] = averageLuma(img, image.Rect(
+				b.Min.X+x*b.Dx()/cols,
+				b.Min.Y+y*b.Dy()/rows,
+				b.Min.X+(x+1)*b.Dx()/cols,
+				b.Min.Y+(y+1)*b.Dy()/rows,
+			))
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols-1; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// averageLuma samples at most 16x16 pixels of rect, which is plenty for a hash cell
+func averageLuma(img image.Image, rect image.Rectangle) float64 {
+	stepX := rect.Dx()/16 + 1
+	stepY := rect.Dy()/16 + 1
+
+	var sum float64
+	var n int
+	for y := rect.Min.Y; y < rect.Max.Y; y += stepY {
+		for x := rect.Min.X; x < rect.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// hammingDistance counts the bits that differ between two hashes
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
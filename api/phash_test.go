@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"testing"
+)
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0xff, 0xff, 0},
+		{0, 1, 1},
+		{0xf0, 0x0f, 8},
+		{0, ^uint64(0), 64},
+	}
+	for _, test := range tests {
+		if got := hammingDistance(test.a, test.b); got != test.want {
+			t.Errorf("hammingDistance(%x, %x) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestPerceptualHash(t *testing.T) {
+	defer inTempDir(t)()
+	// the same pattern as a JPEG
+	decoded, err := png.Decode(bytes.NewReader(patternPNG(t, 90, 60, false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reencoded bytes.Buffer
+	err = jpeg.Encode(&reencoded, decoded, &jpeg.Options{Quality: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"original.png": patternPNG(t, 90, 60, false),
+		"resized.png":  patternPNG(t, 450, 300, false),
+		"jpeg.jpg":     reencoded.Bytes(),
+		"mirrored.png": patternPNG(t, 90, 60, true),
+	}
+	hashes := map[string]uint64{}
+	for name, data := range files {
+		err := ioutil.WriteFile(name, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[name], err = perceptualHash(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		similar bool
+	}{
+		{"resized.png", true},
+		{"jpeg.jpg", true},
+		{"mirrored.png", false},
+	}
+	for _, test := range tests {
+		d := hammingDistance(hashes["original.png"], hashes[test.name])
+		if similar := d <= 4; similar != test.similar {
+			t.Errorf("%s is %d bits from the original, similar %v, want %v", test.name, d, similar, test.similar)
+		}
+	}
+
+	_, err = perceptualHash("missing.png")
+	if err == nil {
+		t.Error("hashed a missing file")
+	}
+	err = ioutil.WriteFile("text.png", []byte("not an image"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = perceptualHash("text.png")
+	if err == nil {
+		t.Error("hashed a text file")
+	}
+}
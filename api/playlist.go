@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// playlist formats
+const (
+	// playlistList is a plain list of paths, one per line, as feh and most rotation scripts read
+	playlistList = "list"
+	// playlistGNOME is a GNOME background slideshow
+	playlistGNOME = "gnome"
+)
+
+// slideshowDuration is how long, in seconds, the GNOME slideshow shows each image
+const slideshowDuration = 1795.0
+
+// slideshowTransition is how long, in seconds, the GNOME slideshow fades between two images
+const slideshowTransition = 5.0
+
+type gnomeBackground struct {
+	XMLName xml.Name `xml:"background"`
+	Items   []interface{}
+}
+
+type gnomeStatic struct {
+	XMLName  xml.Name `xml:"static"`
+	Duration float64  `xml:"duration"`
+	File     string   `xml:"file"`
+}
+
+type gnomeTransition struct {
+	XMLName  xml.Name `xml:"transition"`
+	Duration float64  `xml:"duration"`
+	From     string   `xml:"from"`
+	To       string   `xml:"to"`
+}
+
+// writePlaylist replaces the playlist at path with the absolute paths of the images of the run
+func writePlaylist(path, format string, downloads []download) error {
+	paths := make([]string, 0, len(downloads))
+	for _, d := range downloads {
+		abs, err := filepath.Abs(d.path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, abs)
+	}
+
+	var data []byte
+	switch format {
+	case playlistList, "":
+		for _, p := range paths {
+			data = append(data, p+"\n"...)
+		}
+	case playlistGNOME:
+		var bg gnomeBackground
+		for i, p := range paths {
+			bg.Items = append(bg.Items, gnomeStatic{Duration: slideshowDuration, File: p})
+			bg.Items = append(bg.Items, gnomeTransition{
+				Duration: slideshowTransition,
+				From:     p,
+				To:       paths[(i+1)%len(paths)],
+			})
+		}
+		out, err := xml.MarshalIndent(bg, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append([]byte(xml.Header), out...)
+	default:
+		return fmt.Errorf("unknown playlist format %q", format)
+	}
+
+	// write aside and rename so a running slideshow never reads a partial playlist
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// parseGNOME returns the files of a GNOME slideshow, failing when its transitions don't chain them
+func parseGNOME(t *testing.T, data []byte) []string {
+	t.Helper()
+	var bg struct {
+		Statics []struct {
+			File string `xml:"file"`
+		} `xml:"static"`
+		Transitions []struct {
+			From string `xml:"from"`
+			To   string `xml:"to"`
+		} `xml:"transition"`
+	}
+	err := xml.Unmarshal(data, &bg)
+	if err != nil {
+		t.Fatalf("invalid slideshow: %v", err)
+	}
+	var files []string
+	for i, s := range bg.Statics {
+		files = append(files, s.File)
+		next := bg.Statics[(i+1)%len(bg.Statics)].File
+		if i >= len(bg.Transitions) || bg.Transitions[i].From != s.File || bg.Transitions[i].To != next {
+			t.Errorf("no transition from %s to %s in %+v", s.File, next, bg.Transitions)
+		}
+	}
+	return files
+}
+
+func TestPlaylistListsTheImagesOfTheRun(t *testing.T) {
+	images := map[string][]byte{
+		"a.png": testPNG(t, 60, 40, 1),
+		"b.png": testPNG(t, 40, 60, 2),
+		"c.png": testPNG(t, 60, 40, 3),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		format string
+		files  func(*testing.T, []byte) []string
+	}{
+		{"list by default", "", func(t *testing.T, data []byte) []string { return strings.Fields(string(data)) }},
+		{"list", playlistList, func(t *testing.T, data []byte) []string { return strings.Fields(string(data)) }},
+		{"gnome", playlistGNOME, parseGNOME},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			// the playlist is replaced by each run
+			runs := [][]string{{"c"}, {"a", "b"}}
+			for _, ids := range runs {
+				var posts []*submission
+				for _, id := range ids {
+					posts = append(posts, post(id, srv.URL+"/"+id+".png"))
+				}
+				r := newTestReddit(&Config{Playlist: "playlist", PlaylistFormat: tt.format}, srv, posts...)
+				err := r.FetchSubmissions()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := ioutil.ReadFile("playlist")
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := tt.files(t, data)
+			sort.Strings(files)
+			var want []string
+			for _, p := range []string{filepath.Join("hori", "a.png"), filepath.Join("vert", "b.png")} {
+				abs, err := filepath.Abs(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, abs)
+			}
+			if !reflect.DeepEqual(files, want) {
+				t.Errorf("playlist lists %v, want %v", files, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// skippedPreviewsDir holds the thumbnails of the submissions dropped by the filters
+const skippedPreviewsDir = "skipped-previews"
+
+// savePreview downloads the small preview Reddit generates for a submission into dir,
+// posts without one (self posts, nsfw, ...) are ignored
+func savePreview(ctx context.Context, client *http.Client, timeout time.Duration, dir string, post *submission) error {
+	thumb, err := url.Parse(post.ThumbnailURL)
+	if err != nil || (thumb.Scheme != "http" && thumb.Scheme != "https") {
+		return nil
+	}
+	return saveImage(ctx, client, timeout, thumb, filepath.Join(dir, post.ID+imageExt(thumb)))
+}
+
+// variantsDir holds the preview resolutions of the downloaded images
+const variantsDir = "variants"
+
+// saveVariants downloads every preview resolution Reddit generated for a downloaded image
+// into <dir>/<filename>/<width>x<height>.<ext>
+func saveVariants(ctx context.Context, client *http.Client, timeout time.Duration, dir string, d download) error {
+	var errs multiError
+	dir = filepath.Join(dir, filepath.Base(d.path))
+	for _, res := range d.submission.previewResolutions() {
+		link, err := url.Parse(res.URL)
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+			continue
+		}
+		name := fmt.Sprintf("%dx%d%s", res.Width, res.Height, imageExt(link))
+		err = saveImage(ctx, client, timeout, link, filepath.Join(dir, name))
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// imageExt is the extension of an image link, .jpg when it has none
+func imageExt(link *url.URL) string {
+	ext := path.Ext(link.Path)
+	if ext == "" {
+		ext = ".jpg"
+	}
+	return ext
+}
+
+// saveImage downloads the image at link into dst, creating its directory
+func saveImage(ctx context.Context, client *http.Client, timeout time.Duration, link *url.URL, dst string) error {
+	ctx, cancel := requestContext(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return &statusError{url: link.String(), code: resp.StatusCode}
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return fmt.Errorf("%s is not an image", link)
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, resp.Body)
+	return err
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPreviewsOfSkippedSubmissions(t *testing.T) {
+	img := testPNG(t, 60, 40, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		preview  bool
+		previews []string
+	}{
+		{name: "saves the previews of the skipped", preview: true, previews: []string{"skipped.png"}},
+		{name: "saves nothing when disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			kept := post("kept", srv.URL+"/kept.png")
+			kept.ThumbnailURL = srv.URL + "/thumbs/kept.png"
+			skipped := post("skipped", srv.URL+"/page.html")
+			skipped.ThumbnailURL = srv.URL + "/thumbs/skipped.png"
+			self := post("self", srv.URL+"/self.html")
+			self.ThumbnailURL = "self"
+
+			r := newTestReddit(&Config{PreviewSkipped: tt.preview}, srv, kept, skipped, self)
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 1 {
+				t.Fatalf("saved %+v, want only the image", saved)
+			}
+
+			files, err := filepath.Glob(filepath.Join(skippedPreviewsDir, "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tt.previews) {
+				t.Fatalf("previews %v, want %v", files, tt.previews)
+			}
+			for _, name := range tt.previews {
+				if _, err := os.Stat(filepath.Join(skippedPreviewsDir, name)); err != nil {
+					t.Errorf("preview %s: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPreviewVariantsAreSaved(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 60, 40, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	p := listed(t, fmt.Sprintf(`{"id":"a","name":"t3_a","url":"%[1]s/a.png","preview":{"images":[{"resolutions":[
+		{"url":"%[1]s/a-108.png","width":108,"height":72},
+		{"url":"%[1]s/a-216.png","width":216,"height":144},
+		{"url":"%[1]s/a-640","width":640,"height":427}
+	]}]}}`, srv.URL))
+	r := newTestReddit(&Config{SavePreviewVariants: true}, srv, p)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 {
+		t.Fatalf("saved %+v, want the image", saved)
+	}
+
+	files, err := filepath.Glob(filepath.Join(variantsDir, "a.png", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(variantsDir, "a.png", "108x72.png"),
+		filepath.Join(variantsDir, "a.png", "216x144.png"),
+		// links without an extension are saved as jpg
+		filepath.Join(variantsDir, "a.png", "640x427.jpg"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("saved the variants %v, want %v", files, want)
+	}
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/lucbarr/earthpornbot/store"
+)
+
+// Publisher posts an image somewhere, crediting the submission it comes from
+type Publisher interface {
+	// Name identifies the publisher in the store, which records what each one posted
+	Name() string
+	// Publish posts the image of rec, errTooLarge when the destination refuses its size
+	Publish(ctx context.Context, rec ManifestRecord) error
+}
+
+// errTooLarge is returned by a Publisher for images over the size its destination accepts
+var errTooLarge = errors.New("image too large")
+
+// PublisherConfig is an entry of publishers
+type PublisherConfig struct {
+	// Type is telegram, discord or twitter, which posts with the notify.twitter keys
+	Type string
+	// Name tells the publishers apart in the store, the type by default
+	Name string
+	// Token and ChatID are the Telegram bot token and the chat posted to, e.g. @landscapes
+	Token  string
+	ChatID string `mapstructure:"chatID"`
+	// Webhook is the Discord webhook URL
+	Webhook string
+}
+
+// newPublishers creates the publishers of cfgs, skipping those that are misconfigured. The
+// twitter ones sign their requests at the time of clock.
+func newPublishers(client *http.Client, clock Clock, cfgs []PublisherConfig, twitter TwitterConfig) ([]Publisher, error) {
+	var errs multiError
+	publishers := make([]Publisher, 0, len(cfgs))
+	names := map[string]bool{}
+	for i, c := range cfgs {
+		if c.Name == "" {
+			c.Name = c.Type
+		}
+		if names[c.Name] {
+			errs = append(errs, fmt.Errorf("%d: another publisher is named %q", i, c.Name))
+			continue
+		}
+
+		var p Publisher
+		switch c.Type {
+		case "telegram":
+			if c.Token == "" || c.ChatID == "" {
+				errs = append(errs, fmt.Errorf("%s: telegram needs token and chatID", c.Name))
+				continue
+			}
+			p = telegramPublisher{c.Name, client, c.Token, c.ChatID}
+		case "discord":
+			if c.Webhook == "" {
+				errs = append(errs, fmt.Errorf("%s: discord needs webhook", c.Name))
+				continue
+			}
+			p = discordPublisher{c.Name, client, c.Webhook}
+		case "twitter":
+			if twitter.ConsumerKey == "" || twitter.AccessToken == "" {
+				errs = append(errs, fmt.Errorf("%s: notify.twitter is not configured", c.Name))
+				continue
+			}
+			p = twitterPublisher{c.Name, client, oauth1{twitter.ConsumerKey, twitter.ConsumerSecret, twitter.AccessToken, twitter.AccessSecret, clock}}
+		default:
+			errs = append(errs, fmt.Errorf("%d: unknown type %q, expected telegram, discord or twitter", i, c.Type))
+			continue
+		}
+		names[c.Name] = true
+		publishers = append(publishers, p)
+	}
+	if len(errs) > 0 {
+		return publishers, errs
+	}
+	return publishers, nil
+}
+
+// Publish posts to each of the Publishers the newest indexed image it has not posted yet. It
+// needs the index, where the images are picked from, and the store, where each publisher
+// records what it posted.
+func (r *Reddit) Publish(ctx context.Context) error {
+	if len(r.Publishers) == 0 {
+		return errors.New("publishers is not configured")
+	}
+	if r.cfg.Index == "" {
+		return errors.New("publishing picks the images from subreddit.output.index, which is not set")
+	}
+	closeStore, err := r.openStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	if r.records == nil {
+		return errors.New("publishing records the posted images in subreddit.output.store, which is not set")
+	}
+
+	entries, err := readIndex(r.cfg.Index)
+	if err != nil {
+		return err
+	}
+	var errs multiError
+	for _, p := range r.Publishers {
+		err := r.publishNext(ctx, p, entries)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", p.Name(), err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// publishNext posts with p the newest of entries it has not posted yet and whose file is still there
+func (r *Reddit) publishNext(ctx context.Context, p Publisher, entries []indexEntry) error {
+	err := r.importPosted(p, entries)
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		// entries without a submission id cannot be told apart in the store
+		if e.ID == "" {
+			continue
+		}
+		rec, err := r.records.Get(e.key())
+		if err == store.ErrNotFound {
+			rec = store.Record{ID: e.key(), URL: e.URL, Path: e.Path}
+		} else if err != nil {
+			return err
+		}
+		if postedTo(rec, p.Name()) {
+			continue
+		}
+		if r.cfg.DryRun {
+			_, err = r.imageSize(e.Path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			r.Logger.Info("would publish image", "path", e.Path, "publisher", p.Name())
+			return nil
+		}
+
+		release, err := r.work.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		err = r.publishImage(ctx, p, e)
+		release()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == errTooLarge {
+			r.Logger.Info("skipping image", "path", e.Path, "publisher", p.Name(), "reason", err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not publish %s: %v", e.Path, err)
+		}
+		r.Logger.Info("published image", "path", e.Path, "publisher", p.Name())
+		postsPublished.Inc(p.Name())
+
+		rec.Posted = true
+		rec.PostedTo = append(rec.PostedTo, p.Name())
+		return r.records.Put(rec)
+	}
+	r.Logger.Info("nothing new to publish", "publisher", p.Name())
+	return nil
+}
+
+// publishImage posts the image of e with p, read from the output storage when it is not on disk
+func (r *Reddit) publishImage(ctx context.Context, p Publisher, e indexEntry) error {
+	local, done, err := r.localCopy(e.Path)
+	if err != nil {
+		return err
+	}
+	defer done()
+	rec := manifestRecord(e)
+	rec.Path = local
+	return p.Publish(ctx, rec)
+}
+
+// postingNames returns the names the posts are recorded under in the store, with the store of
+// the run open: those of the publishers, or twitter for PostNext. None without a store, PostNext
+// then records its posts in notify.twitter.posted.
+func (r *Reddit) postingNames() []string {
+	if r.records == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.Publishers))
+	for _, p := range r.Publishers {
+		names = append(names, p.Name())
+	}
+	if len(names) == 0 && r.cfg.Twitter.ConsumerKey != "" && r.cfg.Twitter.AccessToken != "" {
+		names = append(names, r.twitterPublisher().Name())
+	}
+	return names
+}
+
+// unposted returns which of names have not posted the image of e, entries without a
+// submission id are never posted
+func (r *Reddit) unposted(e indexEntry, names []string) ([]string, error) {
+	if e.ID == "" {
+		return nil, nil
+	}
+	rec, err := r.records.Get(e.key())
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	var pending []string
+	for _, name := range names {
+		if !postedTo(rec, name) {
+			pending = append(pending, name)
+		}
+	}
+	return pending, nil
+}
+
+// importPosted records in the store as posted by p, a twitter publisher, the images PostNext
+// listed in notify.twitter.posted, so none is tweeted twice
+func (r *Reddit) importPosted(p Publisher, entries []indexEntry) error {
+	if _, ok := p.(twitterPublisher); !ok || r.cfg.Twitter.Posted == "" {
+		return nil
+	}
+	posted, err := readStringSet(r.cfg.Twitter.Posted)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.ID == "" || !posted[e.Path] {
+			continue
+		}
+		rec, err := r.records.Get(e.key())
+		if err == store.ErrNotFound {
+			rec = store.Record{ID: e.key(), URL: e.URL, Path: e.Path}
+		} else if err != nil {
+			return err
+		}
+		if postedTo(rec, p.Name()) {
+			continue
+		}
+		rec.Posted = true
+		rec.PostedTo = append(rec.PostedTo, p.Name())
+		err = r.records.Put(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postedTo reports whether the publisher name posted the image of rec
+func postedTo(rec store.Record, name string) bool {
+	for _, n := range rec.PostedTo {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSize returns errTooLarge when the file at path is over max bytes
+func checkSize(path string, max int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > max {
+		return errTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lucbarr/earthpornbot/store"
+	"github.com/spf13/viper"
+)
+
+// recordingPublisher posts nothing, it only records the images it was given
+type recordingPublisher struct {
+	name   string
+	posted *[]string
+}
+
+func (p recordingPublisher) Name() string {
+	return p.name
+}
+
+func (p recordingPublisher) Publish(ctx context.Context, rec ManifestRecord) error {
+	*p.posted = append(*p.posted, rec.Path)
+	return nil
+}
+
+// redirectTransport sends every request to the server at target, whatever its host
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestStatusCountsThePendingImagesByPublisher(t *testing.T) {
+	defer inTempDir(t)()
+	writeImages(t, "index.jsonl", nil,
+		indexEntry{ID: "a", Path: "hori/a.png"},
+		indexEntry{ID: "b", Path: "hori/b.png"},
+	)
+	s, err := store.OpenFile("store.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	err = s.Put(store.Record{ID: "a", Path: "hori/a.png", Posted: true, PostedTo: []string{"telegram"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRedditFromConfig(&Config{Index: "index.jsonl"})
+	r.Logger = nil
+	r.Store = s
+	var posted []string
+	r.Publishers = []Publisher{recordingPublisher{"telegram", &posted}, recordingPublisher{"discord", &posted}}
+	status, err := r.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Pending != 2 || status.PendingTo["telegram"] != 1 || status.PendingTo["discord"] != 2 {
+		t.Errorf("got %d pending, %v by publisher", status.Pending, status.PendingTo)
+	}
+}
+
+func TestTweetsAreRecordedOnce(t *testing.T) {
+	defer inTempDir(t)()
+	var tweets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/2/tweets" {
+			tweets++
+			return
+		}
+		w.Write([]byte(`{"media_id_string": "1"}`))
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	writeImages(t, "index.jsonl", nil,
+		indexEntry{ID: "old", Path: "hori/old.png"},
+		indexEntry{ID: "new", Path: "hori/new.png"},
+	)
+	// tweeted before the store kept the posts
+	legacy, _ := json.Marshal([]string{"hori/old.png"})
+	err := ioutil.WriteFile("posted.json", legacy, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRedditFromConfig(&Config{
+		Index:   "index.jsonl",
+		Store:   "store.jsonl",
+		Twitter: TwitterConfig{ConsumerKey: "key", AccessToken: "token", Posted: "posted.json"},
+	})
+	r.Logger = nil
+	r.client = &http.Client{Transport: redirectTransport{target}}
+	for i := 0; i < 2; i++ {
+		err = r.PostNext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tweets != 1 {
+		t.Fatalf("tweeted %d times, want the new image once", tweets)
+	}
+
+	// the publisher replacing PostNext finds both images tweeted
+	r.Publishers = []Publisher{r.twitterPublisher()}
+	err = r.Publish(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tweets != 1 {
+		t.Errorf("the twitter publisher tweeted %d more times", tweets-1)
+	}
+}
+
+func TestNewPublishers(t *testing.T) {
+	twitter := TwitterConfig{ConsumerKey: "key", AccessToken: "token"}
+	tests := []struct {
+		name  string
+		cfgs  []PublisherConfig
+		names []string
+		ok    bool
+	}{
+		{"named by type", []PublisherConfig{
+			{Type: "telegram", Token: "token", ChatID: "@landscapes"},
+			{Type: "discord", Webhook: "https://discord.com/api/webhooks/1/a"},
+			{Type: "twitter"},
+		}, []string{"telegram", "discord", "twitter"}, true},
+		{"two of a type", []PublisherConfig{
+			{Type: "discord", Name: "photos", Webhook: "https://discord.com/api/webhooks/1/a"},
+			{Type: "discord", Name: "wallpapers", Webhook: "https://discord.com/api/webhooks/2/b"},
+		}, []string{"photos", "wallpapers"}, true},
+		{"same name", []PublisherConfig{
+			{Type: "discord", Webhook: "https://discord.com/api/webhooks/1/a"},
+			{Type: "discord", Webhook: "https://discord.com/api/webhooks/2/b"},
+		}, []string{"discord"}, false},
+		{"telegram without chat", []PublisherConfig{{Type: "telegram", Token: "token"}}, nil, false},
+		{"discord without webhook", []PublisherConfig{{Type: "discord"}}, nil, false},
+		{"unknown type", []PublisherConfig{{Type: "mastodon"}, {Type: "twitter"}}, []string{"twitter"}, false},
+	}
+	for _, tt := range tests {
+		publishers, err := newPublishers(http.DefaultClient, RealClock{}, tt.cfgs, twitter)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+		var names []string
+		for _, p := range publishers {
+			names = append(names, p.Name())
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: created %v, want %v", tt.name, names, tt.names)
+		}
+	}
+
+	_, err := newPublishers(http.DefaultClient, RealClock{}, []PublisherConfig{{Type: "twitter"}}, TwitterConfig{})
+	if err == nil {
+		t.Error("created a twitter publisher without notify.twitter")
+	}
+}
+
+func TestEachPublisherPostsTheImagesOnce(t *testing.T) {
+	defer inTempDir(t)()
+	defer viper.Reset()
+	var mu sync.Mutex
+	posted := map[string][]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.URL.Path {
+		case "/bottoken/sendPhoto":
+			_, header, err := req.FormFile("photo")
+			if err != nil || !strings.Contains(req.FormValue("caption"), "https://reddit.com/r/EarthPorn/comments/") {
+				fmt.Fprint(w, `{"ok":false,"description":"no photo or no link"}`)
+				return
+			}
+			posted["telegram"] = append(posted["telegram"], header.Filename)
+			fmt.Fprint(w, `{"ok":true}`)
+		case "/webhook":
+			_, header, err := req.FormFile("files[0]")
+			if err != nil || !strings.Contains(req.FormValue("payload_json"), "https://reddit.com/r/EarthPorn/comments/") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			posted["discord"] = append(posted["discord"], header.Filename)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeImages(t, "index.jsonl", nil,
+		indexEntry{ID: "old", Path: "hori/old.png", Title: "Old", Permalink: "https://reddit.com/r/EarthPorn/comments/old/"},
+		indexEntry{ID: "new", Path: "hori/new.png", Title: "New", Permalink: "https://reddit.com/r/EarthPorn/comments/new/"},
+	)
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	err = viper.ReadConfig(strings.NewReader(`
+subreddit:
+  output:
+    index: index.jsonl
+    store: store.jsonl
+publishers:
+  - type: telegram
+    token: token
+    chatID: "@landscapes"
+  - type: discord
+    webhook: ` + srv.URL + `/webhook
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := LoadConfig()
+	r := NewRedditFromConfig(cfg)
+	r.Logger = nil
+	r.Publishers, err = newPublishers(&http.Client{Transport: redirectTransport{target}}, RealClock{}, cfg.Publishers, cfg.Twitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = r.Publish(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the newest first, then the older one, then there is nothing left
+	want := map[string][]string{"telegram": {"new.png", "old.png"}, "discord": {"new.png", "old.png"}}
+	if !reflect.DeepEqual(posted, want) {
+		t.Errorf("posted %v, want %v", posted, want)
+	}
+	s, err := store.OpenFile("store.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, id := range []string{"old", "new"} {
+		rec, err := s.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rec.Posted || !reflect.DeepEqual(rec.PostedTo, []string{"telegram", "discord"}) {
+			t.Errorf("%s recorded %+v, want posted to both", id, rec)
+		}
+	}
+}
+
+func TestAFailingPublisherDoesNotStopTheOthers(t *testing.T) {
+	defer inTempDir(t)()
+	writeImages(t, "index.jsonl", nil, indexEntry{ID: "a", Path: "hori/a.png"})
+
+	r := NewRedditFromConfig(&Config{Index: "index.jsonl", Store: "store.jsonl"})
+	r.Logger = nil
+	var posted []string
+	r.Publishers = []Publisher{failingPublisher{}, recordingPublisher{"discord", &posted}}
+	err := r.Publish(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("got %v, want the failure of the broken publisher", err)
+	}
+	if !reflect.DeepEqual(posted, []string{"hori/a.png"}) {
+		t.Errorf("the other publisher posted %v", posted)
+	}
+
+	// the image is still pending for the failed publisher
+	status, err := r.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PendingTo["broken"] != 1 || status.PendingTo["discord"] != 0 {
+		t.Errorf("pending %v, want the image pending for the broken publisher only", status.PendingTo)
+	}
+}
+
+// failingPublisher fails every post
+type failingPublisher struct{}
+
+func (failingPublisher) Name() string {
+	return "broken"
+}
+
+func (failingPublisher) Publish(ctx context.Context, rec ManifestRecord) error {
+	return errors.New("unavailable")
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"sync"
+)
+
+// errQuotaFull is returned by place when the orientation of an image already has enough images
+var errQuotaFull = errors.New("enough images of this orientation")
+
+// orientationQuota caps the images saved per orientation in a run, 0 means unlimited
+type orientationQuota struct {
+	mu     sync.Mutex
+	limits map[string]int
+	saved  map[string]int
+}
+
+// newOrientationQuota returns nil when neither orientation is capped
+func newOrientationQuota(horizontal, vertical int) *orientationQuota {
+	if horizontal <= 0 && vertical <= 0 {
+		return nil
+	}
+	return &orientationQuota{
+		limits: map[string]int{"hori": horizontal, "vert": vertical},
+		saved:  map[string]int{},
+	}
+}
+
+// take reserves a slot for an image of orientation, false when there is none left
+func (q *orientationQuota) take(orientation string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	limit := q.limits[orientation]
+	if limit > 0 && q.saved[orientation] >= limit {
+		return false
+	}
+	q.saved[orientation]++
+	return true
+}
+
+// full reports whether every orientation is capped and reached its cap
+func (q *orientationQuota) full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for orientation, limit := range q.limits {
+		if limit <= 0 || q.saved[orientation] < limit {
+			return false
+		}
+	}
+	return true
+}
+
+// release gives back a slot taken for an image of orientation that was not saved
+func (q *orientationQuota) release(orientation string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.saved[orientation] > 0 {
+		q.saved[orientation]--
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"os"
+
+	"github.com/lucbarr/earthpornbot/store"
+)
+
+// openStore sets the store of the run, the Store field or else the file at
+// subreddit.output.store, and returns what closes it
+func (r *Reddit) openStore() (func() error, error) {
+	noop := func() error { return nil }
+	if r.Store != nil {
+		r.records = r.Store
+		return noop, nil
+	}
+	if r.cfg.Store == "" {
+		r.records = nil
+		return noop, nil
+	}
+	// opening the store creates it, which a dry run does not
+	if r.cfg.DryRun {
+		_, err := os.Stat(r.cfg.Store)
+		if os.IsNotExist(err) {
+			r.records = nil
+			return noop, nil
+		}
+	}
+
+	s, err := store.OpenFile(r.cfg.Store)
+	if err != nil {
+		return nil, err
+	}
+	r.records = s
+	return s.Close, nil
+}
+
+// stored reports whether the submission id was handled by a previous run
+func (r *Reddit) stored(id string) bool {
+	if r.records == nil || id == "" {
+		return false
+	}
+	_, err := r.records.Get(id)
+	if err == store.ErrNotFound {
+		return false
+	}
+	if err != nil {
+		// the submission is tried again rather than lost
+		r.Logger.Warn("could not look up the store", "id", id, "err", err)
+		return false
+	}
+	return true
+}
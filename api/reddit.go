@@ -1,251 +1,1260 @@
 package api
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"image/jpeg"
-	"image/png"
-	"io"
-	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/jzelinskie/geddit"
+	"github.com/lucbarr/earthpornbot/filter"
+	imageproc "github.com/lucbarr/earthpornbot/image"
+	"github.com/lucbarr/earthpornbot/logging"
+	"github.com/lucbarr/earthpornbot/storage"
+	"github.com/lucbarr/earthpornbot/store"
 	"github.com/spf13/viper"
 )
 
 // Config is the configuration to access the reddit api
 type Config struct {
+	// AuthMode is how the bot gets its tokens, AuthScript, AuthAppOnly or AuthRefreshToken.
+	// User and Password are only needed by AuthScript, RefreshToken by AuthRefreshToken.
+	AuthMode     string
 	User         string
 	Password     string
 	ClientID     string
 	ClientSecret string
+	RefreshToken string
 
 	Limit int32
+	// Sort is the listing order, hot, new, top or rising
+	Sort PopularitySort
+	// TimeRange is the window of a top or controversial listing, hour, day, week, month, year or all
+	TimeRange string
+	// Subreddits, when set, are fetched one after the other instead of subreddit.name
+	Subreddits []SubredditConfig
+	// AllowedExtensions are the file extensions of the links downloaded
+	AllowedExtensions []string
+	// Filters drop submissions by their score, title, flair and nsfw mark before any download
+	Filters filter.Config
+	// Process writes the variants of the downloaded JPEGs and PNGs, and re-encodes them before they
+	// are classified
+	Process imageproc.Config
+	// MaxPerAuthor caps the submissions downloaded per author in a run, 0 means unlimited
+	MaxPerAuthor int
+	// MaxAge skips submissions older than this, 0 means no limit
+	MaxAge time.Duration
+	// FailFast stops the run on the first failed download instead of reporting all failures at the end
+	FailFast bool
+	// PerHostConcurrency caps the simultaneous downloads from a single host, 0 means unlimited
+	PerHostConcurrency int
+	// StateFile, when set, saves the pagination progress so an interrupted listing resumes
+	StateFile string
+	// ListingBuffer is how many listed submissions may wait for a download slot
+	ListingBuffer int
+	// ExternalDownloader, when its command is set, replaces the built-in downloads
+	ExternalDownloader ExternalDownloader
+	// MaxRequests stops the run once it issued this many HTTP requests, 0 means unlimited
+	MaxRequests int64
+	// ExpandShortLinks follows the redirects of t.co, bit.ly and other short links before filtering
+	ExpandShortLinks bool
+	// ResolveOpenGraph downloads the og:image of links to pages instead of dropping them
+	ResolveOpenGraph bool
+	// ResolveImgur downloads the image of links to imgur image pages instead of dropping them
+	ResolveImgur bool
+	// ResolveGalleries downloads every image of gallery submissions instead of dropping them
+	ResolveGalleries bool
+	// Concurrency bounds the operations of a run working at once, the listing, resolution,
+	// previews and publishing included, 0 means unbounded
+	Concurrency int
+	// AdaptiveConcurrency grows the simultaneous downloads while throughput improves
+	// and backs off when hosts throttle
+	AdaptiveConcurrency bool
+	// EnabledCodecs, when set, restricts the decoded image types, the others are skipped
+	EnabledCodecs []imageCodec
+	// MinWidth and MinHeight skip the images smaller than this, before they are downloaded
+	MinWidth  int
+	MinHeight int
+	// MaxPixels rejects images whose header declares more pixels than this, 0 means unlimited
+	MaxPixels int64
+	// Timeout bounds each request, 0 means no timeout
+	Timeout time.Duration
+	// BytesPerSecondFloor, when set, derives each download deadline from its Content-Length
+	BytesPerSecondFloor int64
+	// Retries is how many more times a download failing with a transient error is tried
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled before each of the next ones
+	RetryBackoff time.Duration
+	// Resume keeps interrupted downloads and fetches only the rest of them on the next run
+	Resume bool
+	// DryRun lists, filters and classifies the images without saving or publishing anything
+	DryRun bool
+
+	// SeenManifest, when set, records the links downloaded so they are not fetched again
+	// even once deleted
+	SeenManifest string
+	// Index, when set, is the append-only JSON lines index of every downloaded image
+	Index string
+	// Store, when set, is the file recording the submissions handled, which later runs skip
+	Store string
+	// HTTPCache, when set, keeps the ETag and Last-Modified of each image so later runs
+	// download it again only when it changed
+	HTTPCache string
+	// FeedJSON, when set, is overwritten after each run with a JSON feed of the run's images
+	FeedJSON string
+	// RSS, when set, is an RSS feed gaining an item per downloaded image on each run
+	RSS string
+	// RSSBaseURL, when set, is where the images are served, the enclosures point to local files otherwise
+	RSSBaseURL string
+	// RSSMaxEntries caps the items kept in the RSS feed
+	RSSMaxEntries int
+	// Playlist, when set, is overwritten after each run with the images of the run
+	// in PlaylistFormat, "list" or "gnome"
+	Playlist       string
+	PlaylistFormat string
+	// RetentionDays, when set, deletes the images older than this many days at the start of each run
+	RetentionDays int
+	// RetentionMaxSize, RetentionMaxFiles and RetentionMaxAge cap the images kept, those over
+	// are deleted after each run in RetentionOrder, oldest or lowestScore. 0 means unlimited
+	RetentionMaxSize  int64
+	RetentionMaxFiles int
+	RetentionMaxAge   time.Duration
+	RetentionOrder    string
+	// PreviewSkipped saves the Reddit thumbnail of submissions dropped by the filters
+	PreviewSkipped bool
+	// SavePreviewVariants saves the preview resolutions Reddit generated for each downloaded image
+	SavePreviewVariants bool
+	// HashNames names the images by the sha256 of their content, identical images are saved once
+	HashNames bool
+	// Sidecars writes <image>.json next to each image, describing it and its submission
+	Sidecars bool
+	// Mirrors receive a copy of each classified image
+	Mirrors []Mirror
+	// Storage is where the classified images are kept, the working directory by default
+	Storage StorageConfig
+	// VerifyCommand, when set, runs on each downloaded file, a non-zero exit discards the file
+	VerifyCommand []string
+	// VerifyTimeout bounds VerifyCommand
+	VerifyTimeout time.Duration
+
+	// Dedupe skips images perceptually similar to one downloaded before
+	Dedupe bool
+	// DedupeThreshold is the Hamming distance under which two perceptual hashes match
+	DedupeThreshold int
+	// DedupeKeep saves the duplicates anyway, they are only reported
+	DedupeKeep bool
+	// DedupeReport, when set, is overwritten after each run with the duplicates met
+	DedupeReport string
+	// Churn logs the share of images that changed since the previous run
+	Churn bool
+	// ChurnFile keeps the previous run's perceptual hashes
+	ChurnFile string
+
+	// SanityAspectMin and SanityAspectMax bound the aspect ratio of wallpapers, images
+	// outside them are always skipped. 0 means unbounded
+	SanityAspectMin float64
+	SanityAspectMax float64
+	// ExactResolutions, when set, keeps only images of exactly one of these sizes, in either orientation
+	ExactResolutions []Resolution
+	// ResolutionTiers, when set, routes images into tier folders by their longer side
+	ResolutionTiers []ResolutionTier
+	// UseExifCrop decides the orientation from the crop declared in the image metadata, if any
+	UseExifCrop bool
+	// ContentAware decides the orientation from where the detail is, ignoring uniform borders
+	ContentAware bool
+	// HorizontalLimit and VerticalLimit cap the images saved per orientation in a run,
+	// 0 means unlimited
+	HorizontalLimit int
+	VerticalLimit   int
+	// ColorTemperature routes images into warm, cool and neutral subfolders by their dominant color
+	ColorTemperature bool
+	// DetectHDR routes the images whose metadata declares HDR into hdr/
+	DetectHDR bool
+	// Animated routes animated images into the animated folder
+	Animated bool
+	// AnimatedByOrientation also sorts the animated images by orientation, e.g. animated/hori
+	AnimatedByOrientation bool
+	// UnsupportedDir, when set, receives the images of a type that cannot be decoded, which are
+	// skipped otherwise
+	UnsupportedDir string
+	// DisplayAspects, when set, replaces the hori/vert folders by the nearest display aspect folder
+	DisplayAspects []DisplayAspect
+	// DisplayAspectTolerance is how far an aspect ratio may be from a display aspect to match it
+	DisplayAspectTolerance float64
+
+	// DiscordWebhook, when set, receives the highest scored image of each run
+	DiscordWebhook string
+	// Twitter is the account PostNext tweets to
+	Twitter TwitterConfig
+	// Publishers are where Publish posts the images
+	Publishers []PublisherConfig
+
+	// problems are the invalid values ignored while loading
+	problems multiError
+}
+
+// LoadConfig reads the Config from viper, invalid values are logged, ignored and reported
+// by Validate
+func LoadConfig() *Config {
+	return defaultConfig()
 }
 
 func defaultConfig() *Config {
+	// invalid values fall back to their default so a typo doesn't stop the bot
+	var problems multiError
+	ignore := func(key string, err error) {
+		logging.Default().Warn("ignoring invalid config", "key", key, "err", err)
+		problems = append(problems, fmt.Errorf("%s: %v", key, err))
+	}
+
+	var tiers []ResolutionTier
+	err := viper.UnmarshalKey("subreddit.classify.resolutionTiers", &tiers)
+	if err != nil {
+		ignore("subreddit.classify.resolutionTiers", err)
+		tiers = nil
+	}
+	sortResolutionTiers(tiers)
+
+	var filters filter.Config
+	err = viper.UnmarshalKey("subreddit.filters", &filters)
+	if err != nil {
+		ignore("subreddit.filters", err)
+		filters = filter.Config{}
+	}
+
+	var process imageproc.Config
+	err = viper.UnmarshalKey("subreddit.process", &process)
+	if err != nil {
+		ignore("subreddit.process", err)
+		process = imageproc.Config{}
+	}
+
+	var outputStorage StorageConfig
+	err = viper.UnmarshalKey("subreddit.output.storage", &outputStorage)
+	if err != nil {
+		ignore("subreddit.output.storage", err)
+		outputStorage = StorageConfig{}
+	}
+
+	var publishers []PublisherConfig
+	err = viper.UnmarshalKey("publishers", &publishers)
+	if err != nil {
+		ignore("publishers", err)
+		publishers = nil
+	}
+
+	var mirrors []Mirror
+	err = viper.UnmarshalKey("subreddit.output.mirrors", &mirrors)
+	if err != nil {
+		ignore("subreddit.output.mirrors", err)
+		mirrors = nil
+	}
+
+	maxAge, err := ParseAge(viper.GetString("subreddit.submissions.maxAge"))
+	if err != nil {
+		ignore("subreddit.submissions.maxAge", err)
+	}
+	retentionMaxAge, err := ParseAge(viper.GetString("subreddit.output.retention.maxAge"))
+	if err != nil {
+		ignore("subreddit.output.retention.maxAge", err)
+	}
+	retentionMaxSize, err := parseSize(viper.GetString("subreddit.output.retention.maxSize"))
+	if err != nil {
+		ignore("subreddit.output.retention.maxSize", err)
+	}
+	retentionOrder := viper.GetString("subreddit.output.retention.order")
+	if retentionOrder == "" {
+		retentionOrder = retainOldest
+	}
+
+	// an invalid sort is kept so the run fails instead of listing something else
+	listingSort := PopularitySort(viper.GetString("subreddit.submissions.sort"))
+	if listingSort == "" {
+		listingSort = HotSubmissions
+	}
+	timeRange := viper.GetString("subreddit.submissions.timeRange")
+	if timeRange == "" {
+		timeRange = viper.GetString("subreddit.submissions.time")
+	}
+
+	var subreddits []SubredditConfig
+	err = viper.UnmarshalKey("subreddits", &subreddits)
+	if err == nil {
+		subreddits, err = subredditConfigs(subreddits, viper.GetInt32("subreddit.submissions.limit"),
+			listingSort, timeRange)
+	}
+	if err != nil {
+		ignore("subreddits", err)
+		subreddits = nil
+	}
+
+	retryBackoff := viper.GetDuration("subreddit.submissions.retryBackoff")
+	if retryBackoff <= 0 {
+		retryBackoff = defaultRetryBackoff
+	}
+
+	churnFile := viper.GetString("subreddit.analysis.churnFile")
+	if churnFile == "" {
+		churnFile = "churn.json"
+	}
+
+	external := ExternalDownloader{
+		Command: viper.GetStringSlice("subreddit.submissions.externalDownloader.command"),
+		List:    viper.GetString("subreddit.submissions.externalDownloader.list"),
+		Dir:     viper.GetString("subreddit.submissions.externalDownloader.dir"),
+	}
+	if external.List == "" {
+		external.List = "urls.txt"
+	}
+	if external.Dir == "" {
+		external.Dir = "incoming"
+	}
+
+	var resolutions []Resolution
+	for _, s := range viper.GetStringSlice("subreddit.classify.exactResolutions") {
+		res, err := imageproc.ParseResolution(s)
+		if err != nil {
+			ignore("subreddit.classify.exactResolutions", err)
+			continue
+		}
+		resolutions = append(resolutions, res)
+	}
+
+	twitter := TwitterConfig{
+		ConsumerKey:    viper.GetString("notify.twitter.consumer-key"),
+		ConsumerSecret: viper.GetString("notify.twitter.consumer-secret"),
+		AccessToken:    viper.GetString("notify.twitter.access-token"),
+		AccessSecret:   viper.GetString("notify.twitter.access-secret"),
+		Posted:         viper.GetString("notify.twitter.posted"),
+	}
+	if twitter.Posted == "" {
+		twitter.Posted = "posted.json"
+	}
+
+	var enabledCodecs []imageCodec
+	for _, name := range viper.GetStringSlice("subreddit.submissions.enabledCodecs") {
+		codec, err := parseCodec(name)
+		if err != nil {
+			ignore("subreddit.submissions.enabledCodecs", err)
+			continue
+		}
+		enabledCodecs = append(enabledCodecs, codec)
+	}
+
+	var aspects []DisplayAspect
+	for _, name := range viper.GetStringSlice("subreddit.classify.byDisplayAspect.aspects") {
+		aspect, err := parseDisplayAspect(name)
+		if err != nil {
+			ignore("subreddit.classify.byDisplayAspect", err)
+			continue
+		}
+		aspects = append(aspects, aspect)
+	}
+
+	authMode := viper.GetString("credentials.auth-mode")
+	if authMode == "" {
+		authMode = AuthScript
+	}
+
 	return &Config{
-		User:         viper.GetString("credentials.user"),
-		Password:     viper.GetString("credentials.password"),
-		ClientID:     viper.GetString("credentials.app.client-id"),
-		ClientSecret: viper.GetString("credentials.app.client-secret"),
-		Limit:        viper.GetInt32("subreddit.submissions.limit"),
+		AuthMode:               authMode,
+		User:                   viper.GetString("credentials.user"),
+		Password:               viper.GetString("credentials.password"),
+		ClientID:               viper.GetString("credentials.app.client-id"),
+		ClientSecret:           viper.GetString("credentials.app.client-secret"),
+		RefreshToken:           viper.GetString("credentials.refresh-token"),
+		Limit:                  viper.GetInt32("subreddit.submissions.limit"),
+		Sort:                   listingSort,
+		TimeRange:              timeRange,
+		Subreddits:             subreddits,
+		AllowedExtensions:      viper.GetStringSlice("subreddit.submissions.allowedExtensions"),
+		Filters:                filters,
+		Process:                process,
+		MaxAge:                 maxAge,
+		FailFast:               viper.GetBool("subreddit.submissions.failFast"),
+		PerHostConcurrency:     viper.GetInt("subreddit.submissions.perHostConcurrency"),
+		AdaptiveConcurrency:    viper.GetBool("subreddit.submissions.adaptiveConcurrency"),
+		ResolveOpenGraph:       viper.GetBool("subreddit.submissions.resolveOpenGraph"),
+		ResolveImgur:           viper.GetBool("subreddit.submissions.resolveImgur"),
+		ResolveGalleries:       viper.GetBool("subreddit.submissions.resolveGalleries"),
+		ExpandShortLinks:       viper.GetBool("subreddit.submissions.expandShortLinks"),
+		MaxRequests:            viper.GetInt64("subreddit.submissions.maxRequests"),
+		MaxPerAuthor:           viper.GetInt("subreddit.submissions.maxPerAuthor"),
+		Concurrency:            viper.GetInt("runtime.concurrency"),
+		ListingBuffer:          viper.GetInt("subreddit.submissions.listingBuffer"),
+		StateFile:              viper.GetString("subreddit.submissions.stateFile"),
+		ExternalDownloader:     external,
+		EnabledCodecs:          enabledCodecs,
+		MinWidth:               viper.GetInt("subreddit.submissions.minWidth"),
+		MinHeight:              viper.GetInt("subreddit.submissions.minHeight"),
+		MaxPixels:              viper.GetInt64("subreddit.submissions.maxPixels"),
+		Timeout:                viper.GetDuration("subreddit.submissions.timeout"),
+		BytesPerSecondFloor:    viper.GetInt64("subreddit.submissions.bytesPerSecondFloor"),
+		Retries:                viper.GetInt("subreddit.submissions.retries"),
+		RetryBackoff:           retryBackoff,
+		Resume:                 viper.GetBool("subreddit.submissions.resume"),
+		DryRun:                 viper.GetBool("runtime.dryRun"),
+		Index:                  viper.GetString("subreddit.output.index"),
+		Store:                  viper.GetString("subreddit.output.store"),
+		HTTPCache:              viper.GetString("subreddit.output.httpCache"),
+		SeenManifest:           viper.GetString("subreddit.output.seenManifest"),
+		FeedJSON:               viper.GetString("subreddit.output.feedJSON"),
+		RSS:                    viper.GetString("subreddit.output.rss.path"),
+		RSSBaseURL:             viper.GetString("subreddit.output.rss.baseURL"),
+		RSSMaxEntries:          viper.GetInt("subreddit.output.rss.maxEntries"),
+		Playlist:               viper.GetString("subreddit.output.playlist.path"),
+		PlaylistFormat:         viper.GetString("subreddit.output.playlist.format"),
+		RetentionDays:          viper.GetInt("subreddit.output.retentionDays"),
+		RetentionMaxSize:       retentionMaxSize,
+		RetentionMaxFiles:      viper.GetInt("subreddit.output.retention.maxFiles"),
+		RetentionMaxAge:        retentionMaxAge,
+		RetentionOrder:         retentionOrder,
+		PreviewSkipped:         viper.GetBool("subreddit.output.previewSkipped"),
+		SavePreviewVariants:    viper.GetBool("subreddit.output.savePreviewVariants"),
+		HashNames:              viper.GetBool("subreddit.output.hashNames"),
+		Sidecars:               viper.GetBool("subreddit.output.sidecars"),
+		Mirrors:                mirrors,
+		Storage:                outputStorage,
+		VerifyCommand:          viper.GetStringSlice("subreddit.output.verifyCommand"),
+		VerifyTimeout:          viper.GetDuration("subreddit.output.verifyTimeout"),
+		Dedupe:                 viper.GetBool("subreddit.dedupe.enabled"),
+		DedupeThreshold:        viper.GetInt("subreddit.dedupe.threshold"),
+		DedupeKeep:             viper.GetBool("subreddit.dedupe.keep"),
+		DedupeReport:           viper.GetString("subreddit.dedupe.report"),
+		Churn:                  viper.GetBool("subreddit.analysis.churn"),
+		ChurnFile:              churnFile,
+		SanityAspectMin:        viper.GetFloat64("subreddit.classify.sanityAspect.min"),
+		SanityAspectMax:        viper.GetFloat64("subreddit.classify.sanityAspect.max"),
+		ExactResolutions:       resolutions,
+		ResolutionTiers:        tiers,
+		UseExifCrop:            viper.GetBool("subreddit.classify.useExifCrop"),
+		ContentAware:           viper.GetBool("subreddit.classify.contentAware"),
+		ColorTemperature:       viper.GetBool("subreddit.classify.colorTemperature"),
+		HorizontalLimit:        viper.GetInt("subreddit.classify.limits.horizontal"),
+		VerticalLimit:          viper.GetInt("subreddit.classify.limits.vertical"),
+		DetectHDR:              viper.GetBool("subreddit.classify.detectHDR"),
+		Animated:               viper.GetBool("subreddit.classify.animated.enabled"),
+		AnimatedByOrientation:  viper.GetBool("subreddit.classify.animated.byOrientation"),
+		UnsupportedDir:         viper.GetString("subreddit.classify.unsupportedDir"),
+		DisplayAspects:         aspects,
+		DisplayAspectTolerance: viper.GetFloat64("subreddit.classify.byDisplayAspect.tolerance"),
+		problems:               problems,
+		DiscordWebhook:         viper.GetString("notify.discord.webhook"),
+		Twitter:                twitter,
+		Publishers:             publishers,
 	}
 }
 
+// defaultSubreddit is listed when subreddit.name is not set
+const defaultSubreddit = "earthporn"
+
+// defaultConcurrency is the simultaneous downloads when subreddit.submissions.concurrency is not set
+const defaultConcurrency = 8
+
 // Reddit is used to get the reddit images
 type Reddit struct {
+	// Concurrency caps the simultaneous downloads, 0 means unbounded
+	Concurrency int
+	// Logger receives the progress messages, logging.Default() by default, nil silences them
+	Logger *logging.Logger
+	// Store, when set, replaces the file store of subreddit.output.store
+	Store store.Store
+	// API lists the subreddits, logged in as the configured account by default
+	API RedditClient
+	// Publishers are where Publish posts the images, those of the publishers config by default
+	Publishers []Publisher
+	// Clock tells the time to the age limits, the retention, the logins and the signed
+	// requests, RealClock by default
+	Clock Clock
+
 	cfg       *Config
 	subreddit string
 	limit     int32
 
-	session           *geddit.OAuthSession
 	client            *http.Client
 	allowedExtMatches []*regexp.Regexp
+	filter            filter.Filter
+	// pipeline is nil unless the images are processed
+	pipeline *imageproc.Pipeline
+	hosts    *hostLimiter
+	// work is the global budget of runtime.concurrency, shared by every operation of the runs
+	work      workLimiter
+	resolvers []urlResolver
+	// mirrors is nil unless the images are copied elsewhere
+	mirrors storage.Backend
+	// output is nil unless the images are stored remotely
+	output storage.Backend
+	// keepLocal keeps the images on disk once in the output storage
+	keepLocal bool
+	budget    *requestBudget
+	// shortLinks is nil unless short links are expanded
+	shortLinks *shortLinkExpander
+	// quota is nil unless the images per orientation are capped, it is renewed every run
+	quota *orientationQuota
+	// seen is loaded at the start of every run
+	seen *seenSet
+	// cache is loaded at the start of every run, nil when there is none
+	cache *httpCache
+	// records is the store of the current run, nil when there is none
+	records store.Store
+	// current is the subreddit being fetched
+	current SubredditConfig
 }
 
-// NewReddit creates a structure to access Reddit API
+// NewReddit creates a structure to access Reddit API, configured from viper
 func NewReddit() *Reddit {
+	r := NewRedditFromConfig(defaultConfig())
+	if subreddit := viper.GetString("subreddit.name"); subreddit != "" {
+		r.subreddit = subreddit
+	}
+	if viper.IsSet("subreddit.submissions.concurrency") {
+		r.Concurrency = viper.GetInt("subreddit.submissions.concurrency")
+	}
+	return r
+}
+
+// NewRedditFromConfig creates a structure to access Reddit API configured by cfg, listing
+// the default subreddit
+func NewRedditFromConfig(cfg *Config) *Reddit {
 
-	allowedExt := viper.GetStringSlice("subreddit.submissions.allowedExtensions")
-	allowedExtMatches := make([]*regexp.Regexp, 0, len(allowedExt))
-	for _, ext := range allowedExt {
-		pattern := fmt.Sprintf("^.+\\.%s$", ext)
-		allowedExtMatches = append(allowedExtMatches, regexp.MustCompile(pattern))
+	allowedExtMatches, err := extensionPatterns(cfg.AllowedExtensions)
+	if err != nil {
+		logging.Default().Warn("ignoring invalid config", "key", "subreddit.submissions.allowedExtensions", "err", err)
+	}
+	keep, err := filter.New(cfg.Filters)
+	if err != nil {
+		logging.Default().Warn("ignoring invalid config", "key", "subreddit.filters", "err", err)
+		keep = filter.All()
+	}
+	pipeline, err := imageproc.New(cfg.Process)
+	if err != nil {
+		logging.Default().Warn("ignoring invalid config", "key", "subreddit.process", "err", err)
+	}
+
+	var resolvers []urlResolver
+	if cfg.ResolveImgur {
+		resolvers = append(resolvers, imgurResolver{})
+	}
+	if cfg.ResolveOpenGraph {
+		resolvers = append(resolvers, openGraphResolver{})
 	}
 
-	return &Reddit{
-		cfg:               defaultConfig(),
-		subreddit:         viper.GetString("subreddit.name"),
-		client:            &http.Client{},
+	budget := &requestBudget{max: cfg.MaxRequests}
+	var shortLinks *shortLinkExpander
+	if cfg.ExpandShortLinks {
+		shortLinks = newShortLinkExpander()
+	}
+	// the listings and the logins count against the request budget too
+	client := &http.Client{Transport: &budgetTransport{budget, http.DefaultTransport}}
+	output, err := newOutputStorage(client, cfg.Storage)
+	if err != nil {
+		// the images are kept in the working directory
+		logging.Default().Warn("ignoring invalid config", "key", "subreddit.output.storage", "err", err)
+	}
+	keepLocal := !cfg.storedOnly()
+	for _, err := range cfg.localCopiesNeeded() {
+		// the images stay on disk for the features reading them there
+		logging.Default().Warn("ignoring invalid config", "key", "subreddit.output.storage.keepLocal", "err", err)
+		keepLocal = true
+	}
+	r := &Reddit{
+		Concurrency:       defaultConcurrency,
+		Logger:            logging.Default(),
+		Clock:             RealClock{},
+		cfg:               cfg,
+		subreddit:         defaultSubreddit,
+		client:            client,
 		allowedExtMatches: allowedExtMatches,
+		filter:            keep,
+		pipeline:          pipeline,
+		hosts:             newHostLimiter(cfg.PerHostConcurrency),
+		work:              newWorkLimiter(cfg.Concurrency),
+		resolvers:         resolvers,
+		budget:            budget,
+		shortLinks:        shortLinks,
+		mirrors:           newMirrorStorage(cfg.Mirrors),
+		output:            output,
+		keepLocal:         keepLocal,
+	}
+	r.API = newOAuthClient(client, redditClock{r}, cfg)
+	r.Publishers, err = newPublishers(client, redditClock{r}, cfg.Publishers, cfg.Twitter)
+	if err != nil {
+		logging.Default().Warn("ignoring invalid config", "key", "publishers", "err", err)
 	}
+	return r
 }
 
-// Authenticate authenticates the api
-func (r *Reddit) Authenticate() error {
-	o, err := geddit.NewOAuthSession(
-		r.cfg.ClientID,
-		r.cfg.ClientSecret,
-		"bot for r/earthporn by u/earthpornsuperbot",
-		"",
-	)
-	if err != nil {
-		return err
+// normalizeExtensions cleans up the configured extensions so they are safe to put in a pattern:
+// lowercased, without surrounding spaces or dots, deduplicated and regex escaped
+func normalizeExtensions(exts []string) []string {
+	seen := map[string]bool{}
+	normalized := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.Trim(strings.TrimSpace(ext), "."))
+		if ext == "" || seen[ext] {
+			continue
+		}
+		seen[ext] = true
+		normalized = append(normalized, regexp.QuoteMeta(ext))
 	}
+	return normalized
+}
 
-	err = o.LoginAuth(r.cfg.User, r.cfg.Password)
-	if err != nil {
-		return err
+// extensionPatterns compiles the patterns matching links with one of exts, skipping the
+// extensions that don't compile
+func extensionPatterns(exts []string) ([]*regexp.Regexp, error) {
+	var errs multiError
+	patterns := make([]*regexp.Regexp, 0, len(exts))
+	for _, ext := range normalizeExtensions(exts) {
+		pattern, err := regexp.Compile(fmt.Sprintf("(?i)^.+\\.%s$", ext))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		patterns = append(patterns, pattern)
 	}
+	if len(errs) > 0 {
+		return patterns, errs
+	}
+	return patterns, nil
+}
 
-	r.session = o
-	return nil
+// Authenticate authenticates the api
+func (r *Reddit) Authenticate() error {
+	return r.AuthenticateContext(context.Background())
+}
+
+// AuthenticateContext is Authenticate, giving up once ctx is done
+func (r *Reddit) AuthenticateContext(ctx context.Context) error {
+	return r.API.Login(ctx)
 }
 
 // FetchSubmissions fetches submissions
 func (r *Reddit) FetchSubmissions() error {
-	validURLs := r.fetchSubmissions()
-	filenameRegex := regexp.MustCompile("[^/]*$")
+	return r.FetchSubmissionsContext(context.Background())
+}
+
+// FetchSubmissionsContext is FetchSubmissions, cancelling ctx aborts the downloads in flight
+// and stops new ones from starting
+func (r *Reddit) FetchSubmissionsContext(ctx context.Context) error {
+	_, err := r.FetchSubmissionsResultsContext(ctx)
+	return err
+}
+
+// Download is an image saved by a run
+type Download struct {
+	URL       string
+	Path      string
+	Width     int
+	Height    int
+	Subreddit string
+	// Orientation is hori or vert
+	Orientation string
+}
+
+// FetchSubmissionsResults is FetchSubmissions, returning the images saved even when it fails
+func (r *Reddit) FetchSubmissionsResults() ([]Download, error) {
+	return r.FetchSubmissionsResultsContext(context.Background())
+}
+
+// FetchSubmissionsResultsContext is FetchSubmissionsContext, returning the images saved even when
+// it fails
+func (r *Reddit) FetchSubmissionsResultsContext(ctx context.Context) ([]Download, error) {
+	for _, sub := range r.subreddits() {
+		err := checkListing(sub.Sort, sub.TimeRange)
+		if err != nil {
+			return nil, fmt.Errorf("r/%s: %v", sub.Name, err)
+		}
+	}
+
+	r.budget.reset()
+	r.quota = newOrientationQuota(r.cfg.HorizontalLimit, r.cfg.VerticalLimit)
+	if r.shortLinks != nil {
+		r.shortLinks.reset()
+	}
+	saved, err := r.fetchSubmissions(ctx)
+	downloads := make([]Download, 0, len(saved))
+	for _, d := range saved {
+		downloads = append(downloads, Download{
+			URL:         d.submission.URL,
+			Path:        d.path,
+			Width:       d.width,
+			Height:      d.height,
+			Subreddit:   d.submission.Subreddit,
+			Orientation: d.orientation,
+		})
+	}
+
+	if ctx.Err() != nil {
+		return downloads, ctx.Err()
+	}
+	if r.budget.exhausted() {
+		r.Logger.Warn("stopped at the request budget", "requests", r.cfg.MaxRequests)
+		return downloads, ErrRequestBudgetExhausted
+	}
+	return downloads, err
+}
+
+func (r *Reddit) fetchSubmissions(ctx context.Context) ([]download, error) {
+	idx, err := loadIndex(r.cfg.Index)
+	if err != nil {
+		return nil, err
+	}
+	closeStore, err := r.openStore()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := closeStore()
+		if err != nil {
+			r.Logger.Error("could not close the store", "err", err)
+		}
+	}()
+	if r.cfg.Dedupe {
+		err = r.loadStoredHashes(idx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.cache, err = loadHTTPCache(r.cfg.HTTPCache)
+	if err != nil {
+		return nil, err
+	}
 
-	os.Mkdir("hori", os.ModePerm)
-	os.Mkdir("vert", os.ModePerm)
+	var saved []download
+	var errs multiError
+	for _, sub := range r.subreddits() {
+		r.current = sub
+		subSaved, subErrs := r.fetchSubreddit(ctx, idx)
+		saved = append(saved, subSaved...)
+		errs = append(errs, subErrs...)
 
-	fetchImage := func(url string, abort chan error) {
-		matches := filenameRegex.FindAllString(url, 1)
-		if len(matches) == 0 {
-			abort <- fmt.Errorf("No match for regex")
-			return
+		if ctx.Err() != nil || r.budget.exhausted() || (r.cfg.FailFast && len(errs) > 0) {
+			break
 		}
+		if r.quota != nil && r.quota.full() {
+			break
+		}
+	}
+	// a dry run saved nothing to report on
+	if r.cfg.DryRun {
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		return nil, nil
+	}
+	err = r.cache.save()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// the external downloader saves the images itself
+	if len(r.cfg.ExternalDownloader.Command) > 0 {
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		return nil, nil
+	}
 
-		filename := matches[0]
-		file, err := os.Create(filename)
+	if duplicates := idx.runDuplicates(); len(duplicates) > 0 {
+		r.Logger.Info("met duplicates", "count", len(duplicates))
+	}
+	if r.cfg.DedupeReport != "" {
+		err := writeDuplicates(r.cfg.DedupeReport, idx.runDuplicates())
 		if err != nil {
-			abort <- fmt.Errorf("Could not create file %s", filename)
-			return
+			errs = append(errs, err)
 		}
-		defer file.Close()
+	}
 
-		err = os.Chmod(filename, os.ModePerm)
+	if r.cfg.Churn {
+		err := reportChurn(r.cfg.ChurnFile, idx.runHashes(), r.cfg.DedupeThreshold, r.Logger)
 		if err != nil {
-			abort <- err
-			return
+			r.Logger.Error("could not compute the churn", "err", err)
 		}
+	}
 
-		resp, err := r.client.Head(url)
+	if r.cfg.FeedJSON != "" {
+		err := writeFeed(r.cfg.FeedJSON, saved)
 		if err != nil {
-			abort <- fmt.Errorf("could not get HEAD")
-			return
+			errs = append(errs, err)
 		}
-		contentType := resp.Header.Get("content-type")
+	}
 
-		var sb strings.Builder
-		sb.WriteString(fmt.Sprintf("Getting image %s, length: %s, type: %s", url, resp.Header.Get("Content-Length"), contentType))
+	if r.cfg.RSS != "" {
+		err := writeRSS(r.cfg.RSS, r.subredditNames(), r.cfg.RSSBaseURL, r.cfg.RSSMaxEntries, saved, r.Clock.Now())
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-		var codec imageCodec
-		if contentType == "image/jpeg" {
-			codec = JPEG
-		} else if contentType == "image/png" {
-			codec = PNG
+	if r.cfg.Playlist != "" {
+		err := writePlaylist(r.cfg.Playlist, r.cfg.PlaylistFormat, saved)
+		if err != nil {
+			errs = append(errs, err)
 		}
+	}
 
-		resp, err = r.client.Get(url)
+	if r.cfg.DiscordWebhook != "" && len(saved) > 0 {
+		err := r.postBestToDiscord(ctx, saved)
 		if err != nil {
-			abort <- fmt.Errorf("Could not gete content length")
-			return
+			r.Logger.Error("could not post to discord", "err", err)
 		}
+	}
 
-		defer resp.Body.Close()
-		_, err = io.Copy(file, resp.Body)
+	if r.cfg.retentionEnabled() {
+		deleted, err := r.retain()
 		if err != nil {
-			abort <- fmt.Errorf("No match for regex")
-			return
+			errs = append(errs, err)
+		}
+		if deleted > 0 {
+			r.Logger.Info("applied the retention limits", "deleted", deleted)
 		}
+	}
 
-		aspectRatio, err := getImageAspectRatio(filename, codec)
+	if len(errs) == 0 {
+		return saved, nil
+	}
+	if r.cfg.FailFast {
+		return saved, errs[0]
+	}
+	return saved, errs
+}
+
+// fetchSubreddit downloads the images of the current subreddit into its output folder
+func (r *Reddit) fetchSubreddit(ctx context.Context, idx *index) ([]download, multiError) {
+	if r.cfg.RetentionDays > 0 && !r.cfg.DryRun {
+		purged, err := r.purge(r.Clock.Now().Add(-time.Duration(r.cfg.RetentionDays)*day), idx)
 		if err != nil {
-			abort <- fmt.Errorf("No match for regex")
-			return
+			return nil, multiError{err}
+		}
+		if purged > 0 {
+			r.Logger.Info("purged old files", "count", purged, "days", r.cfg.RetentionDays)
 		}
-		sb.WriteString(fmt.Sprintf(", aspect ratio: %f", aspectRatio))
+	}
 
-		var newPath string
-		if aspectRatio > 1.0 {
-			newPath = fmt.Sprintf("hori/%s", filename)
-		} else {
-			newPath = fmt.Sprintf("vert/%s", filename)
+	if len(r.cfg.ExternalDownloader.Command) > 0 {
+		err := r.fetchExternally(ctx, idx)
+		if err != nil {
+			return nil, multiError{err}
 		}
+		return nil, nil
+	}
 
-		err = os.Rename(filename, newPath)
+	var err error
+	r.seen, err = loadSeen(r.cfg.SeenManifest, r.classifiedDirs())
+	if err != nil {
+		return nil, multiError{err}
+	}
+	if r.output != nil {
+		err = r.storedNames()
 		if err != nil {
-			abort <- err
-			return
+			return nil, multiError{err}
 		}
-		fmt.Println(sb.String())
+	}
+
+	if !r.cfg.DryRun {
+		os.MkdirAll(r.outputPath("hori"), os.ModePerm)
+		os.MkdirAll(r.outputPath("vert"), os.ModePerm)
+	}
 
-		abort <- nil
+	// the listing pauses while the buffer is full, so slow downloads don't pile up posts in memory
+	buffer := r.cfg.ListingBuffer
+	if buffer < 0 {
+		buffer = 0
 	}
+	posts := make(chan *submission, buffer)
+	stop := make(chan struct{})
 
-	abort := make(chan error)
-	for _, url := range validURLs {
-		go fetchImage(url, abort)
+	// a dry run lists from the saved cursor without moving it
+	progress := newListingProgress(r.listingStateFile())
+	if r.cfg.DryRun {
+		progress = newListingProgress("")
 	}
+	var skipped []skippedPost
+	listed := make(chan error, 1)
+	go func() {
+		var err error
+		skipped, err = r.listSubmissions(ctx, posts, stop, progress)
+		close(posts)
+		listed <- err
+	}()
 
-	for i := 0; i < len(validURLs); i++ {
-		if (<-abort) != nil {
-			return <-abort
+	var tuner *concurrencyTuner
+	if r.cfg.AdaptiveConcurrency {
+		tuner = newConcurrencyTuner(maxAdaptiveWorkers, r.Clock)
+	}
+	workers := func() int {
+		limit := math.MaxInt32
+		if tuner != nil {
+			limit = tuner.limit()
 		}
+		if r.Concurrency > 0 && limit > r.Concurrency {
+			limit = r.Concurrency
+		}
+		// the downloads share the global budget with the listing, which holds them back
+		if r.cfg.Concurrency > 0 && limit > r.cfg.Concurrency {
+			limit = r.cfg.Concurrency
+		}
+		return limit
 	}
-	return nil
-}
 
-func (r *Reddit) fetchSubmissions() []string {
-	opts := geddit.ListingOptions{
-		Limit: int(r.cfg.Limit),
+	// the downloads get their own context, so stopping early cancels those still running
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	downloadCtx := context.WithValue(runCtx, runContextKey{}, ctx)
+
+	type result struct {
+		post     *submission
+		download *download
+		err      error
 	}
+	results := make(chan result)
+
+	var saved []download
+	var errs multiError
+	inFlight := 0
+	listing := true
+collect:
+	for listing || inFlight > 0 {
+		// a nil channel blocks, so no post is taken while the pool is full
+		var next <-chan *submission
+		if listing && inFlight < workers() {
+			next = posts
+		}
+
+		select {
+		case post, ok := <-next:
+			if !ok {
+				listing = false
+				continue
+			}
+			inFlight++
+			go func(post *submission) {
+				d, err := r.fetchImageRetrying(downloadCtx, post, idx)
+				results <- result{post, d, err}
+			}(post)
 
-	posts, err := r.session.SubredditSubmissions("earthporn", geddit.HotSubmissions, opts)
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			break collect
+
+		case res := <-results:
+			inFlight--
+			// the cursor moves past failed downloads too, but not past cancelled ones
+			if res.err == nil || runCtx.Err() == nil {
+				res.post.page.done()
+			}
+			if tuner != nil {
+				tuner.done(res.download, res.err)
+			}
+
+			if res.err != nil {
+				downloadErrors.Inc(errorType(res.err))
+				errs = append(errs, res.err)
+				if r.cfg.FailFast || r.budget.exhausted() {
+					break collect
+				}
+				continue
+			}
+			if res.download != nil {
+				imagesDownloaded.Inc(res.download.orientation)
+				saved = append(saved, *res.download)
+			}
+			// the downloads still running are surplus, they are cancelled below
+			if r.quota != nil && r.quota.full() {
+				break collect
+			}
+		}
+	}
+
+	// the downloads still running are cancelled and waited for, so none writes to the seen
+	// manifest, the index or the store once the run returned, and they remove their partial files
+	cancelRun()
+	for ; inFlight > 0; inFlight-- {
+		res := <-results
+		if res.err == nil {
+			res.post.page.done()
+		}
+		if res.err == nil && res.download != nil {
+			imagesDownloaded.Inc(res.download.orientation)
+			saved = append(saved, *res.download)
+		}
+	}
+
+	close(stop)
+	err = <-listed
+	if err != nil {
+		errs = append(errs, err)
+	}
+	err = progress.saveErr()
 	if err != nil {
-		log.Fatal(err)
+		errs = append(errs, err)
 	}
 
-	isImageURL := func(s string) bool {
-		ret := false
-		for _, regex := range r.allowedExtMatches {
-			ret = ret || regex.MatchString(s)
+	if r.cfg.DryRun {
+		return saved, errs
+	}
+	err = r.seen.save()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if r.cfg.PreviewSkipped {
+		for _, s := range skipped {
+			release, err := r.work.acquire(ctx)
+			if err != nil {
+				break
+			}
+			err = savePreview(ctx, r.client, r.cfg.Timeout, r.outputPath(skippedPreviewsDir), s.submission)
+			release()
+			if err != nil {
+				r.Logger.Warn("could not save the preview", "url", s.submission.URL, "err", err)
+			}
 		}
-		return ret
 	}
 
-	validURLs := []string{}
-	for _, p := range posts {
-		if isImageURL(p.URL) {
-			validURLs = append(validURLs, p.URL)
+	if r.cfg.SavePreviewVariants {
+		for _, d := range saved {
+			release, err := r.work.acquire(ctx)
+			if err != nil {
+				break
+			}
+			err = saveVariants(ctx, r.client, r.cfg.Timeout, r.outputPath(variantsDir), d)
+			release()
+			if err != nil {
+				r.Logger.Warn("could not save the variants", "path", d.path, "err", err)
+			}
 		}
 	}
-	return validURLs
+	return saved, errs
 }
 
-type imageCodec string
+// maxPageSize is the most submissions Reddit returns per listing page
+const maxPageSize = 100
 
-const (
-	JPEG imageCodec = "jpeg"
-	PNG  imageCodec = "png"
-)
+// listSubmissions pages through the listing until Limit submissions were listed, sending
+// the downloadable ones to out. Sending blocks while out is full, which pauses the pagination.
+// It returns early once stop is closed. The cursor is saved by progress as the pages sent are
+// handled, the receiver calls done on the page of each submission.
+func (r *Reddit) listSubmissions(ctx context.Context, out chan<- *submission, stop <-chan struct{}, progress *listingProgress) ([]skippedPost, error) {
+	var cutoff time.Time
+	if r.cfg.MaxAge > 0 {
+		cutoff = r.Clock.Now().Add(-r.cfg.MaxAge)
+	}
 
-func getImageAspectRatio(filename string, codec imageCodec) (float64, error) {
-	file, err := os.Open(filename)
+	state, err := loadListingState(r.listingStateFile())
 	if err != nil {
-		return 0.0, err
+		return nil, err
+	}
+	if state.After != "" {
+		r.Logger.Info("resuming the listing", "after", state.After, "listed", state.Listed)
 	}
 
-	switch codec {
-	case JPEG:
-		return getJPEGAspectRatio(file)
-	case PNG:
-		return getPNGAspectRatio(file)
-	default:
-		return 0.0, errors.New("unsupported file type")
+	var skipped []skippedPost
+	perAuthor := map[string]int{}
+	// a limit lowered since the state was saved may leave nothing to list
+	remaining := int(r.current.Limit) - state.Listed
+	if remaining < 0 {
+		remaining = 0
+	}
+	after := state.After
+	for {
+		select {
+		case <-stop:
+			return skipped, nil
+		default:
+		}
+		if remaining == 0 {
+			progress.page(listingState{}, true).listed()
+			return skipped, nil
+		}
+
+		opts := listingOptions{
+			Limit: remaining,
+			After: after,
+		}
+		if r.current.Sort == TopSubmissions || r.current.Sort == ControversialSubmissions {
+			opts.Time = r.current.TimeRange
+		}
+		if opts.Limit > maxPageSize {
+			opts.Limit = maxPageSize
+		}
+
+		release, err := r.work.acquire(ctx)
+		if err != nil {
+			return skipped, err
+		}
+		page, err := r.listPage(ctx, r.current.Name, r.current.Sort, opts)
+		release()
+		if err != nil {
+			return skipped, err
+		}
+		remaining -= len(page)
+		last := len(page) == 0 || remaining <= 0
+		if !last {
+			after = page[len(page)-1].FullID
+			state.After = after
+			state.Listed += len(page)
+		}
+		handled := progress.page(state, last)
+
+		for _, p := range page {
+			if r.stored(p.ID) {
+				r.Logger.Info("skipping submission", "id", p.ID, "reason", "already stored")
+				continue
+			}
+			items := []*submission{p}
+			if r.cfg.ResolveGalleries {
+				items = galleryItems(p)
+			}
+			var posts []*submission
+			reason := "empty gallery"
+			stored := 0
+			for _, item := range items {
+				// the images of a gallery are recorded on their own
+				if item.gallery != 0 && r.stored(item.key()) {
+					stored++
+					continue
+				}
+				post, why := r.filterSubmission(ctx, item, cutoff)
+				if post != nil {
+					posts = append(posts, post)
+				} else {
+					reason = why
+				}
+			}
+			if len(items) > 0 && stored == len(items) {
+				r.Logger.Info("skipping submission", "id", p.ID, "reason", "already stored")
+				continue
+			}
+			if len(posts) > 0 && r.cfg.MaxPerAuthor > 0 {
+				if perAuthor[p.Author] >= r.cfg.MaxPerAuthor {
+					posts, reason = nil, "too many from the same author"
+				} else {
+					perAuthor[p.Author]++
+				}
+			}
+			if len(posts) == 0 {
+				skipped = append(skipped, skippedPost{submission: p, reason: reason})
+				continue
+			}
+
+			for _, post := range posts {
+				post.page = handled
+				handled.add()
+				select {
+				case out <- post:
+					submissionsListed.Inc(r.current.Name)
+				case <-stop:
+					return skipped, nil
+				}
+			}
+		}
+		handled.listed()
+		if last {
+			return skipped, nil
+		}
 	}
 }
 
-func getJPEGAspectRatio(file *os.File) (float64, error) {
-	imageCfg, err := jpeg.DecodeConfig(file)
-	if err != nil {
-		return 0.0, err
+// filterSubmission returns the submission to download, possibly with a resolved link,
+// or nil and the reason it is skipped
+func (r *Reddit) filterSubmission(ctx context.Context, p *submission, cutoff time.Time) (*submission, string) {
+	if !cutoff.IsZero() && createdAt(p.DateCreated).Before(cutoff) {
+		return nil, "too old"
+	}
+	reason := r.filter(filter.Post{Title: p.Title, Score: p.Score, NSFW: p.IsNSFW, Flair: p.LinkFlairText})
+	if reason != "" {
+		return nil, reason
+	}
+	if r.shortLinks != nil {
+		release, err := r.work.acquire(ctx)
+		if err != nil {
+			return nil, "unresolved link"
+		}
+		link, err := r.shortLinks.expand(ctx, r.client, r.cfg.Timeout, p.URL)
+		release()
+		if err != nil {
+			r.Logger.Warn("could not expand link", "url", p.URL, "err", err)
+			return nil, "unresolved link"
+		}
+		if link != p.URL {
+			expanded := *p
+			expanded.URL = link
+			p = &expanded
+		}
+	}
+	if isRedditPage(p.URL) {
+		return nil, "reddit page"
+	}
+	if r.isImageURL(p.URL) {
+		return p, ""
+	}
+	// video and embed posts may carry the image in their media rather than their link
+	for _, link := range p.mediaURLs() {
+		if r.isImageURL(link) {
+			withMedia := *p
+			withMedia.URL = link
+			return &withMedia, ""
+		}
+	}
+	if len(r.resolvers) == 0 {
+		return nil, "not an image link"
 	}
 
-	return float64(imageCfg.Width) / float64(imageCfg.Height), nil
+	release, err := r.work.acquire(ctx)
+	if err != nil {
+		return nil, "unresolved link"
+	}
+	link, err := resolveLink(ctx, r.resolvers, r.client, r.cfg.Timeout, p.URL)
+	release()
+	if err != nil {
+		r.Logger.Warn("could not resolve link", "url", p.URL, "err", err)
+		return nil, "unresolved link"
+	}
+	if link == "" {
+		return nil, "not an image link"
+	}
+	resolved := *p
+	resolved.URL = link
+	return &resolved, ""
 }
 
-func getPNGAspectRatio(file *os.File) (float64, error) {
-	imageCfg, err := png.DecodeConfig(file)
+// isRedditPage reports whether link points to a reddit comment thread, subreddit or user
+// page, as self posts and crossposts do
+func isRedditPage(link string) bool {
+	u, err := url.Parse(link)
 	if err != nil {
-		return 0.0, err
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != "reddit.com" && !strings.HasSuffix(host, ".reddit.com") {
+		return false
 	}
+	if u.Path == "" || u.Path == "/" {
+		return true
+	}
+	for _, prefix := range []string{"/r/", "/u/", "/user/", "/comments/"} {
+		if strings.HasPrefix(u.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reddit) isImageURL(s string) bool {
+	ret := false
+	for _, regex := range r.allowedExtMatches {
+		ret = ret || regex.MatchString(s)
+	}
+	return ret
+}
 
-	return float64(imageCfg.Width) / float64(imageCfg.Height), nil
+// skippedPost is a submission dropped by the listing filters
+type skippedPost struct {
+	submission *submission
+	reason     string
 }
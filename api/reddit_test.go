@@ -0,0 +1,745 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// inTempDir runs the test from a new directory, the images are saved relative to it, and
+// returns what restores the working directory
+func inTempDir(t *testing.T) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "earthpornbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// fakeAPI lists posts as a single page, the next pages are empty
+type fakeAPI struct {
+	posts []*submission
+}
+
+func (fakeAPI) Login(ctx context.Context) error {
+	return nil
+}
+
+func (a fakeAPI) Do(req *http.Request) (*http.Response, error) {
+	type child struct {
+		Data *submission `json:"data"`
+	}
+	var listing struct {
+		Data struct {
+			Children []child `json:"children"`
+		} `json:"data"`
+	}
+	if req.URL.Query().Get("after") == "" {
+		for _, p := range a.posts {
+			listing.Data.Children = append(listing.Data.Children, child{p})
+		}
+	}
+	body, err := json.Marshal(listing)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}, nil
+}
+
+// testPNG encodes a width x height image, noisy so the perceptual hashes differ by seed
+func testPNG(t *testing.T, width, height int, seed byte) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)*seed + seed
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newTestReddit lists posts linking to the images of srv, if any, cfg gets the defaults a run
+// needs
+func newTestReddit(cfg *Config, srv *httptest.Server, posts ...*submission) *Reddit {
+	if cfg.Limit == 0 {
+		cfg.Limit = 100
+	}
+	if cfg.Sort == "" {
+		cfg.Sort = HotSubmissions
+	}
+	if len(cfg.AllowedExtensions) == 0 {
+		cfg.AllowedExtensions = []string{"png"}
+	}
+	r := NewRedditFromConfig(cfg)
+	r.Logger = nil
+	r.API = fakeAPI{posts}
+	if srv != nil {
+		r.client = srv.Client()
+	}
+	return r
+}
+
+// post is a submission linking to the image at url
+func post(id, url string) *submission {
+	return &submission{ID: id, FullID: "t3_" + id, URL: url, Author: id, Title: id, Subreddit: "earthporn"}
+}
+
+func TestFailFastReturnsPromptly(t *testing.T) {
+	defer inTempDir(t)()
+	var running int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/missing.png") {
+			http.NotFound(w, req)
+			return
+		}
+		// the other images never finish unless cancelled
+		atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-req.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	r := newTestReddit(&Config{FailFast: true}, srv,
+		post("a", srv.URL+"/slow-a.png"),
+		post("b", srv.URL+"/missing.png"),
+		post("c", srv.URL+"/slow-c.png"),
+	)
+
+	done := make(chan error, 1)
+	go func() { done <- r.FetchSubmissions() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("FetchSubmissions did not return after the failed download")
+	}
+
+	var status *statusError
+	if !errors.As(err, &status) || status.code != http.StatusNotFound {
+		t.Fatalf("got %v, want the 404 of missing.png", err)
+	}
+	// the server sees the cancelled requests shortly after the client gave up on them
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&running) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&running); n > 0 {
+		t.Errorf("%d downloads still running after FetchSubmissions returned", n)
+	}
+	if _, err := os.Stat("slow-a.png.part"); !os.IsNotExist(err) {
+		t.Errorf("the partial file of a cancelled download was left behind: %v", err)
+	}
+}
+
+func TestOneFailedDownloadDoesNotHangTheRun(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing.png" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	posts := []*submission{post("missing", srv.URL+"/missing.png")}
+	for i := 0; i < 20; i++ {
+		posts = append(posts, post(fmt.Sprint(i), fmt.Sprintf("%s/%d.png", srv.URL, i)))
+	}
+	r := newTestReddit(&Config{}, srv, posts...)
+	r.Concurrency = 0
+
+	type result struct {
+		saved []Download
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		saved, err := r.FetchSubmissionsResults()
+		done <- result{saved, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run hung after the failed download")
+	}
+
+	var errs multiError
+	var status *statusError
+	if !errors.As(res.err, &errs) || len(errs) != 1 || !errors.As(errs[0], &status) || status.code != http.StatusNotFound {
+		t.Fatalf("got %v, want the 404 of missing.png only", res.err)
+	}
+	if len(res.saved) != 20 {
+		t.Errorf("saved %d images, want the 20 others", len(res.saved))
+	}
+}
+
+func TestConcurrencyCapsTheDownloadsInFlight(t *testing.T) {
+	img := testPNG(t, 30, 20, 1)
+	var active, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if req.URL.Path == "/missing.png" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	for _, concurrency := range []int{1, 2, 5} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			defer inTempDir(t)()
+			atomic.StoreInt32(&peak, 0)
+			posts := []*submission{post("missing", srv.URL+"/missing.png")}
+			for i := 0; i < 20; i++ {
+				posts = append(posts, post(fmt.Sprint(i), fmt.Sprintf("%s/%d.png", srv.URL, i)))
+			}
+			r := newTestReddit(&Config{}, srv, posts...)
+			r.Concurrency = concurrency
+			err := r.FetchSubmissions()
+			// the pool still reports the failure
+			var errs multiError
+			var status *statusError
+			if !errors.As(err, &errs) || len(errs) != 1 || !errors.As(errs[0], &status) || status.code != http.StatusNotFound {
+				t.Errorf("got %v, want the 404 of missing.png", err)
+			}
+			if p := atomic.LoadInt32(&peak); p != int32(concurrency) {
+				t.Errorf("up to %d downloads at once, want %d", p, concurrency)
+			}
+		})
+	}
+}
+
+func TestContinueReportsEveryFailure(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/missing") {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{Concurrency: 1}, srv,
+		post("a", srv.URL+"/missing-a.png"),
+		post("b", srv.URL+"/b.png"),
+		post("c", srv.URL+"/missing-c.png"),
+		post("d", srv.URL+"/d.png"),
+	)
+	saved, err := r.FetchSubmissionsResults()
+	if len(saved) != 2 {
+		t.Errorf("saved %+v, want the two images found", saved)
+	}
+	var errs multiError
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("got %v, want both failures", err)
+	}
+	for _, err := range errs {
+		var status *statusError
+		if !errors.As(err, &status) || status.code != http.StatusNotFound {
+			t.Errorf("got %v, want a 404", err)
+		}
+	}
+}
+
+func TestQuotaCancelsSurplusDownloads(t *testing.T) {
+	defer inTempDir(t)()
+	hori, vert := testPNG(t, 30, 20, 1), testPNG(t, 20, 30, 2)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		switch req.URL.Path {
+		case "/hori.png":
+			w.Write(hori)
+		case "/vert.png":
+			w.Write(vert)
+		default:
+			// a surplus download, half sent
+			w.Header().Set("Content-Length", strconv.Itoa(2*len(hori)))
+			w.Write(hori)
+			w.(http.Flusher).Flush()
+			select {
+			case <-req.Context().Done():
+			case <-release:
+			}
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	r := newTestReddit(&Config{HorizontalLimit: 1, VerticalLimit: 1, Resume: true}, srv,
+		post("slow", srv.URL+"/slow.png"),
+		post("hori", srv.URL+"/hori.png"),
+		post("vert", srv.URL+"/vert.png"),
+	)
+	done := make(chan error, 1)
+	var saved []Download
+	go func() {
+		var err error
+		saved, err = r.FetchSubmissionsResults()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run did not stop once both orientations were full")
+	}
+
+	if len(saved) != 2 {
+		t.Errorf("saved %d images, want 2", len(saved))
+	}
+	if _, err := os.Stat("slow.png.part"); !os.IsNotExist(err) {
+		t.Errorf("the partial file of the surplus download was kept: %v", err)
+	}
+}
+
+func TestNormalizeExtensions(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"clean", []string{"jpg", "png"}, []string{"jpg", "png"}},
+		{"case", []string{"JPG", "Png"}, []string{"jpg", "png"}},
+		{"dots and spaces", []string{".jpg", " png ", "..gif."}, []string{"jpg", "png", "gif"}},
+		{"duplicates", []string{"jpg", ".JPG", "jpg"}, []string{"jpg"}},
+		{"regex characters", []string{"j.g", "png+"}, []string{`j\.g`, `png\+`}},
+		{"empty", []string{"", " . "}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeExtensions(tt.in)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtensionPatterns(t *testing.T) {
+	patterns, err := extensionPatterns([]string{".JPG", "png", "j.g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		link  string
+		match bool
+	}{
+		{"https://i.redd.it/a.jpg", true},
+		{"https://i.redd.it/a.JPG", true},
+		{"https://i.imgur.com/b.png", true},
+		{"https://i.imgur.com/b.j.g", true},
+		{"https://i.imgur.com/b.jxg", false},
+		{"https://i.imgur.com/b.gif", false},
+		{"https://i.imgur.com/png", false},
+	}
+	for _, tt := range tests {
+		matched := false
+		for _, pattern := range patterns {
+			matched = matched || pattern.MatchString(tt.link)
+		}
+		if matched != tt.match {
+			t.Errorf("%s matched %v, want %v", tt.link, matched, tt.match)
+		}
+	}
+}
+
+// pagedAPI lists posts by pages of size, counting the pages it served. When failAt is set, the
+// request for that page fails.
+type pagedAPI struct {
+	posts  []*submission
+	size   int
+	failAt int32
+	pages  int32
+	// afters are the cursors of the requests
+	afters []string
+}
+
+func (*pagedAPI) Login(ctx context.Context) error {
+	return nil
+}
+
+func (a *pagedAPI) Do(req *http.Request) (*http.Response, error) {
+	if n := atomic.AddInt32(&a.pages, 1); n == a.failAt {
+		return nil, errors.New("connection reset")
+	}
+	after := req.URL.Query().Get("after")
+	a.afters = append(a.afters, after)
+	start := 0
+	if after != "" {
+		for i, p := range a.posts {
+			if p.FullID == after {
+				start = i + 1
+			}
+		}
+	}
+	end := start + a.size
+	if end > len(a.posts) {
+		end = len(a.posts)
+	}
+	return fakeAPI{a.posts[start:end]}.Do(&http.Request{URL: &url.URL{}})
+}
+
+func TestListingPausesWhileTheDownloadsLag(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for i := 0; i < 40; i++ {
+		posts = append(posts, post(fmt.Sprint(i), fmt.Sprintf("%s/%d.png", srv.URL, i)))
+	}
+	api := &pagedAPI{posts: posts, size: 5}
+	r := newTestReddit(&Config{Concurrency: 1, ListingBuffer: 2}, srv)
+	r.API = api
+
+	done := make(chan []Download, 1)
+	go func() {
+		saved, _ := r.FetchSubmissionsResults()
+		done <- saved
+	}()
+
+	// the download in flight, the buffer and the post waiting to be sent are all from the first page
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&api.pages); n > 1 {
+		t.Errorf("listed %d pages while the first download is stuck, want 1", n)
+	}
+
+	close(release)
+	select {
+	case saved := <-done:
+		if len(saved) != len(posts) {
+			t.Errorf("saved %d images, want %d", len(saved), len(posts))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run did not finish once the downloads went on")
+	}
+}
+
+// countingAPI lists the posts of a pagedAPI, counting its requests in flight with those of the server
+type countingAPI struct {
+	*pagedAPI
+	track func() func()
+}
+
+func (a countingAPI) Do(req *http.Request) (*http.Response, error) {
+	defer a.track()()
+	time.Sleep(10 * time.Millisecond)
+	return a.pagedAPI.Do(req)
+}
+
+func TestRunsStayWithinTheGlobalConcurrency(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	var active, peak int32
+	// track counts a request in flight until the returned func is called
+	track := func() func() {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		return func() { atomic.AddInt32(&active, -1) }
+	}
+	var attempts sync.Map
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer track()()
+		time.Sleep(10 * time.Millisecond)
+		if strings.HasPrefix(req.URL.Path, "/page/") {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<meta property="og:image" content="%s/%s.png">`, srv.URL, path.Base(req.URL.Path))
+			return
+		}
+		// every first attempt of an image fails, so the retries run too
+		if _, retried := attempts.LoadOrStore(req.URL.Path, true); !retried && !strings.Contains(req.URL.Path, "-") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	// half the posts link to a page resolved to its image, each image has a preview variant
+	var posts []*submission
+	for i := 0; i < 24; i++ {
+		link := fmt.Sprintf("%s/%d.png", srv.URL, i)
+		if i%2 == 1 {
+			link = fmt.Sprintf("%s/page/%d", srv.URL, i)
+		}
+		posts = append(posts, listed(t, fmt.Sprintf(`{"id":"%[1]d","name":"t3_%[1]d","url":"%[2]s",
+			"preview":{"images":[{"resolutions":[{"url":"%[3]s/%[1]d-108.png","width":108,"height":72}]}]}}`,
+			i, link, srv.URL)))
+	}
+	const budget = 3
+	r := newTestReddit(&Config{
+		Concurrency:         budget,
+		Retries:             1,
+		RetryBackoff:        time.Millisecond,
+		ResolveOpenGraph:    true,
+		SavePreviewVariants: true,
+	}, srv)
+	r.API = countingAPI{&pagedAPI{posts: posts, size: 5}, track}
+	// the downloads are left unbounded, only the global budget holds them
+	r.Concurrency = 0
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != len(posts) {
+		t.Errorf("saved %d images, want %d", len(saved), len(posts))
+	}
+	variants, err := filepath.Glob(filepath.Join(variantsDir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != len(posts) {
+		t.Errorf("saved %d variants, want %d", len(variants), len(posts))
+	}
+	// the listing, the resolution, the downloads and the variants share the budget
+	if p := atomic.LoadInt32(&peak); p != budget {
+		t.Errorf("up to %d simultaneous requests, want %d", p, budget)
+	}
+}
+
+func TestRedditPagesAreSkipped(t *testing.T) {
+	tests := []struct {
+		url    string
+		reason string
+	}{
+		{"https://www.reddit.com/r/EarthPorn/comments/abc/valley/", "reddit page"},
+		{"https://old.reddit.com/r/EarthPorn/", "reddit page"},
+		{"https://reddit.com/user/someone", "reddit page"},
+		{"https://www.reddit.com/u/someone/", "reddit page"},
+		{"https://reddit.com/comments/abc", "reddit page"},
+		{"https://www.reddit.com", "reddit page"},
+		{"https://www.reddit.com/gallery/abc", "not an image link"},
+		{"https://notreddit.com/r/pics/", "not an image link"},
+		{"https://i.redd.it/abc.png", ""},
+	}
+	r := newTestReddit(&Config{}, nil)
+	for _, tt := range tests {
+		_, reason := r.filterSubmission(context.Background(), post("a", tt.url), time.Time{})
+		if reason != tt.reason {
+			t.Errorf("%s: skipped as %q, want %q", tt.url, reason, tt.reason)
+		}
+	}
+}
+
+func TestRedditPagesAreNotResolved(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReddit(&Config{ResolveOpenGraph: true}, nil)
+	r.client = &http.Client{Transport: redirectTransport{target}}
+	_, reason := r.filterSubmission(context.Background(), post("a", "https://www.reddit.com/r/pics/comments/abc/"), time.Time{})
+	if reason != "reddit page" {
+		t.Errorf("skipped as %q, want reddit page", reason)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("requested the page %d times", n)
+	}
+}
+
+func TestMaxPerAuthor(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	byAuthor := func(id, author, link string) *submission {
+		p := post(id, link)
+		p.Author = author
+		return p
+	}
+	r := newTestReddit(&Config{MaxPerAuthor: 2}, srv,
+		// the skipped submissions don't count against the cap
+		byAuthor("a", "prolific", srv.URL+"/a.html"),
+		byAuthor("b", "prolific", srv.URL+"/b.png"),
+		byAuthor("c", "other", srv.URL+"/c.png"),
+		byAuthor("d", "prolific", srv.URL+"/d.png"),
+		byAuthor("e", "prolific", srv.URL+"/e.png"),
+		byAuthor("f", "prolific", srv.URL+"/f.png"),
+		byAuthor("g", "another", srv.URL+"/g.png"),
+	)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, d := range saved {
+		names = append(names, filepath.Base(d.Path))
+	}
+	sort.Strings(names)
+	want := []string{"b.png", "c.png", "d.png", "g.png"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("saved %v, want %v", names, want)
+	}
+}
+
+func TestResultsDescribeTheDownloads(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{"a.png": testPNG(t, 60, 40, 1), "b.png": testPNG(t, 30, 50, 2)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	// no logger, the library stays quiet
+	r := newTestReddit(&Config{}, srv, post("a", srv.URL+"/a.png"), post("b", srv.URL+"/b.png"))
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].URL < saved[j].URL })
+	want := []Download{
+		{URL: srv.URL + "/a.png", Path: filepath.Join("hori", "a.png"), Width: 60, Height: 40, Subreddit: "earthporn", Orientation: "hori"},
+		{URL: srv.URL + "/b.png", Path: filepath.Join("vert", "b.png"), Width: 30, Height: 50, Subreddit: "earthporn", Orientation: "vert"},
+	}
+	if !reflect.DeepEqual(saved, want) {
+		t.Errorf("got %+v, want %+v", saved, want)
+	}
+}
+
+func TestFiltersDeclaredInTheConfigSkipDownloads(t *testing.T) {
+	defer inTempDir(t)()
+	defer viper.Reset()
+	img := testPNG(t, 60, 40, 1)
+	var mu sync.Mutex
+	var fetched []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, req.URL.Path)
+		mu.Unlock()
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+subreddit:
+  submissions:
+    limit: 10
+    allowedExtensions: [png]
+  filters:
+    minScore: 100
+    minTitleResolution: 3840x2160
+    excludeNSFW: true
+    titleInclude: ["(?i)lake"]
+    titleExclude: ["(?i)\\bai\\b"]
+    flairs: [OC]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := map[string]func(p *submission){
+		"kept":      func(p *submission) {},
+		"score":     func(p *submission) { p.Score = 99 },
+		"reso":      func(p *submission) { p.Title = "Lake [1920x1080]" },
+		"nsfw":      func(p *submission) { p.IsNSFW = true },
+		"include":   func(p *submission) { p.Title = "Desert [6000x4000]" },
+		"exclude":   func(p *submission) { p.Title = "AI lake [6000x4000]" },
+		"flair":     func(p *submission) { p.LinkFlairText = "Meta" },
+		"unflaired": func(p *submission) { p.LinkFlairText = "" },
+	}
+	var listed []*submission
+	for id, change := range posts {
+		p := post(id, srv.URL+"/"+id+".png")
+		p.Score, p.Title, p.LinkFlairText = 100, "Lake [6000x4000]", "oc"
+		change(p)
+		listed = append(listed, p)
+	}
+	r := NewRedditFromConfig(LoadConfig())
+	r.Logger = nil
+	r.client = srv.Client()
+	r.API = fakeAPI{listed}
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fetched, []string{"/kept.png"}) || len(saved) != 1 {
+		t.Errorf("fetched %v and saved %+v, want the kept submission only", fetched, saved)
+	}
+}
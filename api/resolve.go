@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// urlResolver turns a submission link that is not a direct image into an image link
+type urlResolver interface {
+	// accepts reports whether the resolver knows how to handle link
+	accepts(link *url.URL) bool
+	// resolve returns the image link, empty when the page has none
+	resolve(ctx context.Context, client *http.Client, timeout time.Duration, link *url.URL) (string, error)
+}
+
+// resolveLink runs the first resolver accepting link, resolvers are ordered from the
+// most specific to the most generic one
+func resolveLink(ctx context.Context, resolvers []urlResolver, client *http.Client, timeout time.Duration, rawLink string) (string, error) {
+	link, err := url.Parse(rawLink)
+	if err != nil {
+		return "", err
+	}
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return "", nil
+	}
+
+	for _, res := range resolvers {
+		if res.accepts(link) {
+			return res.resolve(ctx, client, timeout, link)
+		}
+	}
+	return "", nil
+}
+
+// imgurID matches the path of an imgur image page, /<id>, albums and galleries have a prefix
+var imgurID = regexp.MustCompile(`^/([A-Za-z0-9]{5,10})$`)
+
+// imgurResolver links imgur image pages to the image itself, without fetching the page.
+// i.imgur.com serves the image whatever the extension asked for.
+type imgurResolver struct{}
+
+func (imgurResolver) accepts(link *url.URL) bool {
+	host := strings.TrimPrefix(strings.ToLower(link.Hostname()), "www.")
+	return (host == "imgur.com" || host == "m.imgur.com") && imgurID.MatchString(link.Path)
+}
+
+func (imgurResolver) resolve(_ context.Context, _ *http.Client, _ time.Duration, link *url.URL) (string, error) {
+	id := imgurID.FindStringSubmatch(link.Path)[1]
+	return "https://i.imgur.com/" + id + ".jpg", nil
+}
+
+// maxPageBytes bounds how much of an HTML page is read looking for its image
+const maxPageBytes = 512 << 10
+
+var (
+	metaTag  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttr = regexp.MustCompile(`(?is)([a-z][a-z:-]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// openGraphImageProps are the meta properties declaring a page image, by preference
+var openGraphImageProps = []string{
+	"og:image:secure_url",
+	"og:image:url",
+	"og:image",
+	"twitter:image",
+	"twitter:image:src",
+}
+
+// openGraphResolver reads the og:image or twitter:image a page declares, it accepts every link
+// so it must come last
+type openGraphResolver struct{}
+
+func (openGraphResolver) accepts(*url.URL) bool {
+	return true
+}
+
+func (openGraphResolver) resolve(ctx context.Context, client *http.Client, timeout time.Duration, link *url.URL) (string, error) {
+	ctx, cancel := requestContext(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 || !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return "", nil
+	}
+
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return "", err
+	}
+
+	image := openGraphImage(page)
+	if image == "" {
+		return "", nil
+	}
+	ref, err := url.Parse(image)
+	if err != nil {
+		return "", nil
+	}
+	return link.ResolveReference(ref).String(), nil
+}
+
+// openGraphImage returns the preferred image declared by the meta tags of page
+func openGraphImage(page []byte) string {
+	found := map[string]string{}
+	for _, tag := range metaTag.FindAll(page, -1) {
+		attrs := map[string]string{}
+		for _, m := range metaAttr.FindAllSubmatch(tag, -1) {
+			value := m[2]
+			if len(value) == 0 {
+				value = m[3]
+			}
+			attrs[strings.ToLower(string(m[1]))] = html.UnescapeString(strings.TrimSpace(string(value)))
+		}
+
+		prop := attrs["property"]
+		if prop == "" {
+			prop = attrs["name"]
+		}
+		prop = strings.ToLower(prop)
+		if _, ok := found[prop]; !ok && attrs["content"] != "" {
+			found[prop] = attrs["content"]
+		}
+	}
+
+	for _, prop := range openGraphImageProps {
+		if image := found[prop]; image != "" {
+			return image
+		}
+	}
+	return ""
+}
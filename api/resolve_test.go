@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenGraphImage(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"og:image", `<head><meta property="og:image" content="https://i.example.com/a.jpg"></head>`, "https://i.example.com/a.jpg"},
+		{"content first", `<meta content='https://i.example.com/a.jpg' property='og:image' />`, "https://i.example.com/a.jpg"},
+		{"twitter", `<meta name="twitter:image" content="https://i.example.com/t.jpg">`, "https://i.example.com/t.jpg"},
+		{"secure url preferred", `<meta name="twitter:image" content="https://i.example.com/t.jpg">
+			<meta property="og:image" content="http://i.example.com/a.jpg">
+			<META PROPERTY="og:image:secure_url" CONTENT="https://i.example.com/s.jpg">`, "https://i.example.com/s.jpg"},
+		{"first of a property", `<meta property="og:image" content="/1.jpg"><meta property="og:image" content="/2.jpg">`, "/1.jpg"},
+		{"entities", `<meta property="og:image" content="/a.jpg?w=1&amp;h=2">`, "/a.jpg?w=1&h=2"},
+		{"empty content", `<meta property="og:image" content="">`, ""},
+		{"none", `<meta property="og:title" content="Lake"><img src="/a.jpg">`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := openGraphImage([]byte(tt.page)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPagesAreResolvedToTheirOpenGraphImage(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/photos/lake":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<html><head><meta property="og:image" content="/images/lake.png"></head></html>`)
+		case "/photos/empty":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><title>Nothing</title></head></html>`)
+		case "/images/lake.png":
+			w.Write(img)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{ResolveOpenGraph: true}, srv,
+		post("lake", srv.URL+"/photos/lake"),
+		post("empty", srv.URL+"/photos/empty"),
+	)
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].Path != filepath.Join("hori", "lake.png") || saved[0].URL != srv.URL+"/images/lake.png" {
+		t.Errorf("saved %+v, want the og:image of the lake page", saved)
+	}
+}
+
+func TestImgurResolver(t *testing.T) {
+	tests := []struct {
+		link    string
+		accepts bool
+		image   string
+	}{
+		{"https://imgur.com/AbC123x", true, "https://i.imgur.com/AbC123x.jpg"},
+		{"https://m.imgur.com/AbC123x", true, "https://i.imgur.com/AbC123x.jpg"},
+		{"https://imgur.com/a/AbC123x", false, ""},
+		{"https://imgur.com/gallery/AbC123x", false, ""},
+		{"https://example.com/AbC123x", false, ""},
+	}
+	for _, tt := range tests {
+		link, err := url.Parse(tt.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (imgurResolver{}).accepts(link); got != tt.accepts {
+			t.Errorf("%s accepted %v, want %v", tt.link, got, tt.accepts)
+			continue
+		}
+		if !tt.accepts {
+			continue
+		}
+		image, err := imgurResolver{}.resolve(context.Background(), nil, 0, link)
+		if err != nil || image != tt.image {
+			t.Errorf("%s resolved to %q, %v, want %q", tt.link, image, err, tt.image)
+		}
+	}
+}
+
+func TestImgurPagesAreDownloaded(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Host != "i.imgur.com" || req.URL.Path != "/AbC123x.jpg" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(img)
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReddit(&Config{ResolveImgur: true}, nil,
+		post("page", "https://imgur.com/AbC123x"),
+		post("album", "https://imgur.com/a/AbC123x"),
+	)
+	r.client = &http.Client{Transport: redirectTransport{target}}
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].Path != filepath.Join("hori", "AbC123x.jpg") || saved[0].URL != "https://i.imgur.com/AbC123x.jpg" {
+		t.Errorf("saved %+v, want the image of the imgur page", saved)
+	}
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outputDirs are the folders images of the current subreddit are written to
+func (r *Reddit) outputDirs() []string {
+	return append(r.classifiedDirs(), r.outputPath(skippedPreviewsDir), r.outputPath(variantsDir))
+}
+
+// classifiedDirs are the output folders holding the downloaded images themselves
+func (r *Reddit) classifiedDirs() []string {
+	names := []string{"hori", "vert", "other", animatedDir, hdrDir}
+	for _, aspect := range r.cfg.DisplayAspects {
+		names = append(names, aspect.Name)
+	}
+	if r.cfg.UnsupportedDir != "" && r.cfg.UnsupportedDir != "other" {
+		names = append(names, r.cfg.UnsupportedDir)
+	}
+	dirs := make([]string, 0, len(names))
+	for _, name := range names {
+		dirs = append(dirs, r.outputPath(name))
+	}
+	return dirs
+}
+
+// Prune deletes the images of every subreddit, local and stored, last modified more than
+// olderThan ago, and returns how many files it deleted. The images waiting to be posted are
+// kept whatever their age.
+func (r *Reddit) Prune(olderThan time.Duration) (int, error) {
+	closeStore, err := r.openStore()
+	if err != nil {
+		return 0, err
+	}
+	defer closeStore()
+	idx, err := loadIndex(r.cfg.Index)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := r.Clock.Now().Add(-olderThan)
+	total := 0
+	for _, sub := range r.subreddits() {
+		r.current = sub
+		purged, err := r.purge(cutoff, idx)
+		total += purged
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// purge deletes the files of the current subreddit last modified before cutoff, apart from
+// the images waiting to be posted, and drops the deleted images from idx. The store of the
+// run is open.
+func (r *Reddit) purge(cutoff time.Time, idx *index) (int, error) {
+	entries, err := readIndex(r.cfg.Index)
+	if err != nil {
+		return 0, err
+	}
+	queued, err := r.queued(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	keep := r.withVariants(queued)
+	removed := map[string]bool{}
+	purged, err := purgeOlderThan(r.outputDirs(), cutoff, keep, removed)
+	if err == nil && r.output != nil {
+		var stored int
+		stored, err = r.purgeStoredOlderThan(r.outputDirs(), cutoff, keep, removed)
+		purged += stored
+	}
+	// the files deleted before a failure are gone all the same
+	indexErr := idx.remove(removed)
+	if err == nil {
+		err = indexErr
+	}
+	return purged, err
+}
+
+// kept reports whether the file at key, an image, its sidecar or one of its variants, belongs
+// to an image of queued
+func kept(queued map[string]bool, key string) bool {
+	return queued[key] || queued[path.Dir(key)] || (isSidecar(key) && queued[strings.TrimSuffix(key, ".json")])
+}
+
+// variantsOf is the folder holding the variants of the image at p in the current subreddit
+func (r *Reddit) variantsOf(p string) string {
+	return filepath.ToSlash(filepath.Join(r.outputPath(variantsDir), filepath.Base(p)))
+}
+
+// withVariants returns the images of queued with the folders of their variants in the current
+// subreddit
+func (r *Reddit) withVariants(queued map[string]bool) map[string]bool {
+	keep := make(map[string]bool, 2*len(queued))
+	for p := range queued {
+		keep[p] = true
+		keep[r.variantsOf(p)] = true
+	}
+	return keep
+}
+
+// purgeOlderThan deletes the files under dirs, sidecars included, last modified before cutoff
+// unless they belong to the images of keep, and adds them to removed
+func purgeOlderThan(dirs []string, cutoff time.Time, keep, removed map[string]bool) (int, error) {
+	purged := 0
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) || kept(keep, filepath.ToSlash(path)) {
+				return nil
+			}
+
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+			removed[filepath.ToSlash(path)] = true
+			purged++
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// Retention orders
+const (
+	retainOldest      = "oldest"
+	retainLowestScore = "lowestScore"
+)
+
+// parseSize parses a number of bytes with an optional KB, MB, GB or TB suffix, or their KiB
+// style binary counterparts, such as "500MB" or "2GiB"
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+	}
+	unit := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s, unit = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * unit), nil
+}
+
+// retained is an image the retention limits may delete
+type retained struct {
+	path    string
+	size    int64
+	modTime time.Time
+	score   int
+	// stored images are only in the output storage, path is their key
+	stored bool
+	// queued images and their variants are kept whatever the limits
+	queued bool
+}
+
+// retentionEnabled reports whether any retention limit is set
+func (c *Config) retentionEnabled() bool {
+	return c.RetentionMaxSize > 0 || c.RetentionMaxFiles > 0 || c.RetentionMaxAge > 0
+}
+
+// ApplyRetention deletes the images of every subreddit, local and stored, their variants and the
+// skipped previews over the retention limits, in the retention order, and returns how many files
+// it deleted. The images waiting to be posted are kept whatever the limits.
+func (r *Reddit) ApplyRetention() (int, error) {
+	if !r.cfg.retentionEnabled() {
+		return 0, nil
+	}
+	closeStore, err := r.openStore()
+	if err != nil {
+		return 0, err
+	}
+	defer closeStore()
+	return r.retain()
+}
+
+// retain applies the retention limits, with the store of the run open
+func (r *Reddit) retain() (deleted int, err error) {
+	entries, err := readIndex(r.cfg.Index)
+	if err != nil {
+		return 0, err
+	}
+	queued, err := r.queued(entries)
+	if err != nil {
+		return 0, err
+	}
+	var images []retained
+	for _, sub := range r.subreddits() {
+		r.current = sub
+		// the variants rank with their image
+		scores := make(map[string]int, 2*len(entries))
+		for _, e := range entries {
+			scores[filepath.ToSlash(e.Path)] = e.Score
+			scores[r.variantsOf(e.Path)] = e.Score
+		}
+		found, err := r.retainedImages(scores, r.withVariants(queued))
+		if err != nil {
+			return 0, err
+		}
+		images = append(images, found...)
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		if r.cfg.RetentionOrder == retainLowestScore && images[i].score != images[j].score {
+			return images[i].score < images[j].score
+		}
+		return images[i].modTime.Before(images[j].modTime)
+	})
+	var count int
+	var size int64
+	for _, img := range images {
+		count++
+		size += img.size
+	}
+
+	var cutoff time.Time
+	if r.cfg.RetentionMaxAge > 0 {
+		cutoff = r.Clock.Now().Add(-r.cfg.RetentionMaxAge)
+	}
+	removed := map[string]bool{}
+	// the images deleted before a failure are gone all the same
+	defer func() {
+		indexErr := removeIndexed(r.cfg.Index, removed)
+		if err == nil {
+			err = indexErr
+		}
+	}()
+	for _, img := range images {
+		over := (r.cfg.RetentionMaxFiles > 0 && count > r.cfg.RetentionMaxFiles) ||
+			(r.cfg.RetentionMaxSize > 0 && size > r.cfg.RetentionMaxSize) ||
+			(!cutoff.IsZero() && img.modTime.Before(cutoff))
+		if !over || img.queued {
+			continue
+		}
+		err = r.deleteRetained(img)
+		if err != nil {
+			return deleted, err
+		}
+		removed[filepath.ToSlash(img.path)] = true
+		deleted++
+		count--
+		size -= img.size
+	}
+	return deleted, nil
+}
+
+// retainedImages lists the images of the current subreddit, the local files, variants and
+// skipped previews included, and the objects of the output storage without a local copy,
+// sidecars excluded. The files of keep are marked queued.
+func (r *Reddit) retainedImages(scores map[string]int, keep map[string]bool) ([]retained, error) {
+	var images []retained
+	local := map[string]bool{}
+	for _, dir := range r.outputDirs() {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || isSidecar(path) {
+				return nil
+			}
+			key := filepath.ToSlash(path)
+			local[key] = true
+			score, ok := scores[key]
+			if !ok {
+				score = scores[filepath.ToSlash(filepath.Dir(path))]
+			}
+			images = append(images, retained{path: path, size: info.Size(), modTime: info.ModTime(), score: score, queued: kept(keep, key)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.output == nil {
+		return images, nil
+	}
+
+	for _, dir := range r.classifiedDirs() {
+		objects, err := r.output.List(filepath.ToSlash(dir) + "/")
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objects {
+			if local[o.Key] || isSidecar(o.Key) {
+				continue
+			}
+			images = append(images, retained{path: o.Key, size: o.Size, modTime: o.ModTime, score: scores[o.Key], stored: true, queued: kept(keep, o.Key)})
+		}
+	}
+	return images, nil
+}
+
+// isSidecar reports whether path is the sidecar of an image
+func isSidecar(path string) bool {
+	return strings.HasSuffix(path, ".json")
+}
+
+// deleteRetained deletes img and its sidecar, locally and in the output storage
+func (r *Reddit) deleteRetained(img retained) error {
+	if !img.stored {
+		err := os.Remove(img.path)
+		if err != nil {
+			return err
+		}
+		os.Remove(sidecarPath(img.path))
+	}
+	if r.output != nil {
+		key := filepath.ToSlash(img.path)
+		for _, k := range []string{key, sidecarPath(key)} {
+			err := r.output.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	r.Logger.Info("deleted image", "path", img.path, "reason", "over the retention limits")
+	return nil
+}
+
+// queued returns the paths of the indexed images still to be posted, by the publishers when
+// there are some and else by PostNext, nothing when neither is configured
+func (r *Reddit) queued(entries []indexEntry) (map[string]bool, error) {
+	queued := map[string]bool{}
+	names := r.postingNames()
+	switch {
+	case len(names) > 0:
+		for _, e := range entries {
+			pending, err := r.unposted(e, names)
+			if err != nil {
+				return nil, err
+			}
+			if len(pending) > 0 {
+				queued[filepath.ToSlash(e.Path)] = true
+			}
+		}
+	case len(r.Publishers) > 0:
+		// without a store nothing was published, the publishers skip the entries without an id
+		for _, e := range entries {
+			if e.ID != "" {
+				queued[filepath.ToSlash(e.Path)] = true
+			}
+		}
+	case r.cfg.Twitter.ConsumerKey != "" && r.cfg.Twitter.AccessToken != "":
+		posted, err := readStringSet(r.cfg.Twitter.Posted)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !posted[e.Path] {
+				queued[filepath.ToSlash(e.Path)] = true
+			}
+		}
+	}
+	return queued, nil
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// writeFile writes its own path to path, last modified age ago
+func writeFile(t *testing.T, path string, age time.Duration) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, []byte(path), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-age)
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeImages writes the images of entries, each last modified age ago, and indexes them
+func writeImages(t *testing.T, indexPath string, ages map[string]time.Duration, entries ...indexEntry) {
+	t.Helper()
+	idx := &index{path: indexPath}
+	for _, e := range entries {
+		writeFile(t, e.Path, ages[e.Path])
+		err := idx.add(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// indexedPaths returns the paths left in the index
+func indexedPaths(t *testing.T, index string) map[string]bool {
+	t.Helper()
+	entries, err := readIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]bool{}
+	for _, e := range entries {
+		paths[e.Path] = true
+	}
+	return paths
+}
+
+func TestPruneKeepsTheQueuedImages(t *testing.T) {
+	defer inTempDir(t)()
+	day := 24 * time.Hour
+	writeImages(t, "index.jsonl", map[string]time.Duration{"hori/posted.png": 3 * day, "hori/queued.png": 3 * day},
+		indexEntry{ID: "posted", Path: "hori/posted.png"},
+		indexEntry{ID: "queued", Path: "hori/queued.png"},
+		indexEntry{ID: "recent", Path: "hori/recent.png"},
+	)
+	for _, path := range []string{"variants/posted.png/160x90.png", "variants/queued.png/160x90.png", "skipped-previews/dropped.jpg"} {
+		writeFile(t, path, 3*day)
+	}
+	posted, _ := json.Marshal([]string{"hori/posted.png"})
+	err := ioutil.WriteFile("posted.json", posted, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRedditFromConfig(&Config{
+		Index:   "index.jsonl",
+		Twitter: TwitterConfig{ConsumerKey: "key", AccessToken: "token", Posted: "posted.json"},
+	})
+	r.Logger = nil
+	purged, err := r.Prune(2 * day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 3 {
+		t.Errorf("purged %d files, want the posted image, its variant and the skipped preview", purged)
+	}
+	for path, want := range map[string]bool{
+		"hori/posted.png":                false,
+		"hori/queued.png":                true,
+		"hori/recent.png":                true,
+		"variants/posted.png/160x90.png": false,
+		"variants/queued.png/160x90.png": true,
+		"skipped-previews/dropped.jpg":   false,
+	} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: kept %v, want %v", path, err == nil, want)
+		}
+	}
+	if indexed := indexedPaths(t, "index.jsonl"); indexed["hori/posted.png"] || len(indexed) != 2 {
+		t.Errorf("indexed %v after the prune", indexed)
+	}
+}
+
+func TestRetentionOrder(t *testing.T) {
+	tests := []struct {
+		order string
+		kept  string
+	}{
+		{retainOldest, "hori/recent.png"},
+		{retainLowestScore, "hori/best.png"},
+	}
+	for _, test := range tests {
+		t.Run(test.order, func(t *testing.T) {
+			defer inTempDir(t)()
+			writeImages(t, "index.jsonl", map[string]time.Duration{"hori/old.png": 3 * time.Hour, "hori/best.png": 2 * time.Hour, "hori/recent.png": time.Hour},
+				indexEntry{ID: "old", Path: "hori/old.png", Score: 10},
+				indexEntry{ID: "best", Path: "hori/best.png", Score: 100},
+				indexEntry{ID: "recent", Path: "hori/recent.png", Score: 1},
+			)
+
+			r := NewRedditFromConfig(&Config{Index: "index.jsonl", RetentionMaxFiles: 1, RetentionOrder: test.order})
+			r.Logger = nil
+			deleted, err := r.ApplyRetention()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != 2 {
+				t.Errorf("deleted %d images, want 2", deleted)
+			}
+			left, _ := filepath.Glob("hori/*.png")
+			if len(left) != 1 || filepath.ToSlash(left[0]) != test.kept {
+				t.Errorf("kept %v, want %s", left, test.kept)
+			}
+			if indexed := indexedPaths(t, "index.jsonl"); len(indexed) != 1 || !indexed[test.kept] {
+				t.Errorf("indexed %v after the retention", indexed)
+			}
+		})
+	}
+}
+
+func TestRunsPurgeTheImagesPastTheRetention(t *testing.T) {
+	defer inTempDir(t)()
+	day := 24 * time.Hour
+	ages := map[string]time.Duration{"hori/old.png": 3 * day, "vert/recent.png": time.Hour}
+	writeImages(t, "index.jsonl", ages,
+		indexEntry{ID: "old", Path: "hori/old.png"},
+		indexEntry{ID: "recent", Path: "vert/recent.png"},
+	)
+	// the sidecars and previews are not indexed
+	others := map[string]time.Duration{
+		sidecarPath("hori/old.png"):      3 * day,
+		sidecarPath("vert/recent.png"):   time.Hour,
+		"skipped-previews/old-thumb.jpg": 3 * day,
+	}
+	for path, age := range others {
+		ages[path] = age
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(path), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := newTestReddit(&Config{Index: "index.jsonl", RetentionDays: 2}, nil)
+	err := r.FetchSubmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, age := range ages {
+		want := age < 2*day
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: kept %v, want %v", path, err == nil, want)
+		}
+	}
+	if indexed := indexedPaths(t, "index.jsonl"); len(indexed) != 1 || !indexed["vert/recent.png"] {
+		t.Errorf("indexed %v, want the recent image only", indexed)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"1024", 1024, true},
+		{"500MB", 500e6, true},
+		{" 2 GiB ", 2 << 30, true},
+		{"1.5kb", 1500, true},
+		{"10B", 10, true},
+		{"1TB", 1e12, true},
+		{"lots", 0, false},
+		{"-1GB", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseSize(test.in)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestRetentionLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		// posted are the images tweeted, the others are queued, when set
+		posted []string
+		kept   []string
+	}{
+		{"max files", Config{RetentionMaxFiles: 2}, nil, []string{"hori/b.png", "vert/c.png"}},
+		{"max size", Config{RetentionMaxSize: 25}, nil, []string{"hori/b.png", "vert/c.png"}},
+		{"max age", Config{RetentionMaxAge: 150 * time.Minute}, nil, []string{"hori/b.png", "vert/c.png"}},
+		{"every limit", Config{RetentionMaxFiles: 2, RetentionMaxSize: 15, RetentionMaxAge: 150 * time.Minute}, nil, []string{"vert/c.png"}},
+		{"under the limits", Config{RetentionMaxFiles: 3, RetentionMaxSize: 30}, nil, []string{"hori/a.png", "hori/b.png", "vert/c.png"}},
+		{"queued images kept", Config{RetentionMaxFiles: 1}, []string{"hori/a.png"}, []string{"hori/b.png", "vert/c.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			// the images are 10 bytes each
+			writeImages(t, "index.jsonl", map[string]time.Duration{"hori/a.png": 3 * time.Hour, "hori/b.png": 2 * time.Hour, "vert/c.png": time.Hour},
+				indexEntry{ID: "a", Path: "hori/a.png"},
+				indexEntry{ID: "b", Path: "hori/b.png"},
+				indexEntry{ID: "c", Path: "vert/c.png"},
+			)
+			cfg := tt.cfg
+			cfg.Index = "index.jsonl"
+			if tt.posted != nil {
+				posted, _ := json.Marshal(tt.posted)
+				err := ioutil.WriteFile("posted.json", posted, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.Twitter = TwitterConfig{ConsumerKey: "key", AccessToken: "token", Posted: "posted.json"}
+			}
+
+			r := NewRedditFromConfig(&cfg)
+			r.Logger = nil
+			deleted, err := r.ApplyRetention()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != 3-len(tt.kept) {
+				t.Errorf("deleted %d images, want %d", deleted, 3-len(tt.kept))
+			}
+			var left []string
+			for _, pattern := range []string{"hori/*.png", "vert/*.png"} {
+				found, _ := filepath.Glob(pattern)
+				for _, f := range found {
+					left = append(left, filepath.ToSlash(f))
+				}
+			}
+			if !reflect.DeepEqual(left, tt.kept) {
+				t.Errorf("kept %v, want %v", left, tt.kept)
+			}
+		})
+	}
+}
+
+func TestRetentionLimitsCoverTheVariantsAndSkippedPreviews(t *testing.T) {
+	defer inTempDir(t)()
+	day := 24 * time.Hour
+	writeImages(t, "index.jsonl", map[string]time.Duration{"hori/posted.png": 3 * day, "hori/queued.png": 3 * day},
+		indexEntry{ID: "posted", Path: "hori/posted.png"},
+		indexEntry{ID: "queued", Path: "hori/queued.png"},
+	)
+	for _, path := range []string{"variants/posted.png/160x90.png", "variants/queued.png/160x90.png", "skipped-previews/dropped.jpg"} {
+		writeFile(t, path, 3*day)
+	}
+	writeFile(t, "skipped-previews/recent.jpg", time.Hour)
+	posted, _ := json.Marshal([]string{"hori/posted.png"})
+	err := ioutil.WriteFile("posted.json", posted, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRedditFromConfig(&Config{
+		Index:           "index.jsonl",
+		RetentionMaxAge: 2 * day,
+		Twitter:         TwitterConfig{ConsumerKey: "key", AccessToken: "token", Posted: "posted.json"},
+	})
+	r.Logger = nil
+	deleted, err := r.ApplyRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 3 {
+		t.Errorf("deleted %d files, want the posted image, its variant and the old skipped preview", deleted)
+	}
+	for path, want := range map[string]bool{
+		"hori/posted.png":                false,
+		"hori/queued.png":                true,
+		"variants/posted.png/160x90.png": false,
+		"variants/queued.png/160x90.png": true,
+		"skipped-previews/dropped.jpg":   false,
+		"skipped-previews/recent.jpg":    true,
+	} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: kept %v, want %v", path, err == nil, want)
+		}
+	}
+}
+
+func TestRunsApplyTheRetentionLimits(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 60, 40, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+	writeImages(t, "index.jsonl", map[string]time.Duration{"hori/old.png": time.Hour},
+		indexEntry{ID: "old", Path: "hori/old.png"},
+	)
+
+	r := newTestReddit(&Config{Index: "index.jsonl", RetentionMaxFiles: 1}, srv, post("new", srv.URL+"/new.png"))
+	err := r.FetchSubmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, _ := filepath.Glob("hori/*.png")
+	if len(left) != 1 || filepath.Base(left[0]) != "new.png" {
+		t.Errorf("kept %v after the run, want the new image", left)
+	}
+}
+
+func TestRetentionConfig(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+subreddit:
+  output:
+    retention:
+      maxSize: 2GB
+      maxFiles: 500
+      maxAge: 30d
+      order: lowestScore
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := LoadConfig()
+	if cfg.RetentionMaxSize != 2e9 || cfg.RetentionMaxFiles != 500 || cfg.RetentionMaxAge != 30*24*time.Hour || cfg.RetentionOrder != retainLowestScore {
+		t.Errorf("got %d, %d, %v, %s", cfg.RetentionMaxSize, cfg.RetentionMaxFiles, cfg.RetentionMaxAge, cfg.RetentionOrder)
+	}
+
+	viper.Reset()
+	if cfg := LoadConfig(); cfg.retentionEnabled() || cfg.RetentionOrder != retainOldest {
+		t.Errorf("the retention is on by default, %+v", cfg)
+	}
+}
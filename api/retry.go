@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultRetryBackoff is the wait before the first retry when subreddit.submissions.retryBackoff is not set
+const defaultRetryBackoff = time.Second
+
+// fetchImageRetrying is fetchImage, tried again after a growing wait while it fails with a
+// transient error and retries are left
+func (r *Reddit) fetchImageRetrying(ctx context.Context, post *submission, idx *index) (*download, error) {
+	wait := r.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		d, err := r.fetchImage(ctx, post, idx)
+		if err == nil || attempt >= r.cfg.Retries || !transient(ctx, err) || r.budget.exhausted() {
+			return d, err
+		}
+
+		r.Logger.Warn("retrying download", "url", post.URL, "wait", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// transient reports whether err, returned by a download made on behalf of ctx, may not
+// happen again: throttling, server errors, timeouts and dropped connections
+func transient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	// the per-request deadlines cancel the request context, ctx itself is still live
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransient(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"throttled", context.Background(), &statusError{code: http.StatusTooManyRequests}, true},
+		{"server error", context.Background(), &statusError{code: http.StatusBadGateway}, true},
+		{"not found", context.Background(), &statusError{code: http.StatusNotFound}, false},
+		{"forbidden", context.Background(), fmt.Errorf("wrapped: %w", &statusError{code: http.StatusForbidden}), false},
+		{"dropped connection", context.Background(), &net.OpError{Op: "read", Err: errors.New("reset")}, true},
+		{"truncated body", context.Background(), fmt.Errorf("copy: %w", io.ErrUnexpectedEOF), true},
+		{"request deadline", context.Background(), context.DeadlineExceeded, true},
+		{"run cancelled", cancelled, &statusError{code: http.StatusServiceUnavailable}, false},
+		{"other", context.Background(), errors.New("not an image"), false},
+	}
+	for _, tt := range tests {
+		if got := transient(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: transient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadsAreRetriedWithBackoff(t *testing.T) {
+	img := testPNG(t, 30, 20, 1)
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		attempts int
+		saved    bool
+	}{
+		{"transient failures", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, 3, 3, true},
+		{"out of retries", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 2, 3, false},
+		{"permanent failure", []int{http.StatusNotFound}, 3, 1, false},
+		{"no retries", []int{http.StatusServiceUnavailable}, 0, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			var mu sync.Mutex
+			var times []time.Time
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				attempt := len(times)
+				times = append(times, time.Now())
+				mu.Unlock()
+				if attempt < len(tt.statuses) {
+					w.WriteHeader(tt.statuses[attempt])
+					return
+				}
+				w.Write(img)
+			}))
+			defer srv.Close()
+
+			backoff := 20 * time.Millisecond
+			r := newTestReddit(&Config{Retries: tt.retries, RetryBackoff: backoff}, srv, post("a", srv.URL+"/a.png"))
+			saved, err := r.FetchSubmissionsResults()
+			if (len(saved) == 1) != tt.saved || (err == nil) != tt.saved {
+				t.Errorf("saved %+v, %v", saved, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(times) != tt.attempts {
+				t.Fatalf("tried %d times, want %d", len(times), tt.attempts)
+			}
+			// the wait doubles before each retry
+			wait := backoff
+			for i := 1; i < len(times); i++ {
+				if gap := times[i].Sub(times[i-1]); gap < wait {
+					t.Errorf("retry %d after %v, want at least %v", i, gap, wait)
+				}
+				wait *= 2
+			}
+		})
+	}
+}
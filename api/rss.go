@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultRSSMaxEntries caps the feed when no cap is configured
+const defaultRSSMaxEntries = 50
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	GUID      string       `xml:"guid"`
+	PubDate   string       `xml:"pubDate"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// writeRSS adds the images of the current run to the RSS feed at path, newest first,
+// keeping at most maxEntries items. Enclosures point below baseURL, or to the local file
+func writeRSS(path, subreddit, baseURL string, maxEntries int, downloads []download, now time.Time) error {
+	if maxEntries <= 0 {
+		maxEntries = defaultRSSMaxEntries
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "r/" + subreddit + " wallpapers",
+			Link:        "https://www.reddit.com/r/" + subreddit,
+			Description: "Images downloaded from r/" + subreddit,
+		},
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		err = xml.Unmarshal(data, &feed)
+		if err != nil {
+			return err
+		}
+	}
+
+	items := make([]rssItem, 0, len(downloads)+len(feed.Channel.Items))
+	for _, d := range downloads {
+		link, err := enclosureURL(baseURL, d.path)
+		if err != nil {
+			return err
+		}
+		items = append(items, rssItem{
+			Title:   d.submission.Title,
+			Link:    d.submission.FullPermalink(),
+			GUID:    d.submission.FullPermalink(),
+			PubDate: now.Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL:    link,
+				Length: d.size,
+				Type:   mime.TypeByExtension(filepath.Ext(d.path)),
+			},
+		})
+	}
+	items = append(items, feed.Channel.Items...)
+	if len(items) > maxEntries {
+		items = items[:maxEntries]
+	}
+	feed.Channel.Items = items
+
+	data, err = xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+
+	// write aside and rename so readers never see a partial feed
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func enclosureURL(baseURL, path string) (string, error) {
+	if baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/") + "/" + filepath.ToSlash(path), nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String(), nil
+}
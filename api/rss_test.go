@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRSSFeedValidatesAndListsTheNewEntries(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{}
+	for i, id := range []string{"a", "b", "c", "d"} {
+		images[id+".png"] = testPNG(t, 30, 20, byte(i))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	runs := [][]string{{"a", "b"}, {"c", "d"}}
+	for _, ids := range runs {
+		var posts []*submission
+		for _, id := range ids {
+			p := post(id, srv.URL+"/"+id+".png")
+			p.Permalink = "/r/earthporn/comments/" + id
+			posts = append(posts, p)
+		}
+		r := newTestReddit(&Config{RSS: "feed.xml", RSSBaseURL: "https://example.com/walls/", RSSMaxEntries: 3},
+			srv, posts...)
+		err := r.FetchSubmissions()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile("feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Errorf("feed has no XML declaration: %.40q", data)
+	}
+	var feed rssFeed
+	err = xml.Unmarshal(data, &feed)
+	if err != nil {
+		t.Fatalf("feed does not parse: %v", err)
+	}
+	if feed.Version != "2.0" || feed.Channel.Title == "" || feed.Channel.Link == "" || feed.Channel.Description == "" {
+		t.Errorf("invalid channel: version %q, %+v", feed.Version, feed.Channel)
+	}
+
+	items := feed.Channel.Items
+	if len(items) != 3 {
+		t.Fatalf("feed has %d items, want the cap of 3", len(items))
+	}
+	for i, item := range items {
+		id := path.Base(item.Link)
+		if item.Title != id || item.GUID != "https://reddit.com/r/earthporn/comments/"+id {
+			t.Errorf("item %d: %+v", i, item)
+		}
+		_, err := time.Parse(time.RFC1123Z, item.PubDate)
+		if err != nil {
+			t.Errorf("item %d: invalid pubDate: %v", i, err)
+		}
+		enc := item.Enclosure
+		if enc.URL != "https://example.com/walls/hori/"+id+".png" || enc.Type != "image/png" ||
+			enc.Length != int64(len(images[id+".png"])) {
+			t.Errorf("item %d: enclosure %+v", i, enc)
+		}
+		// the entries of the latest run come first
+		newest := id == "c" || id == "d"
+		if newest != (i < 2) {
+			t.Errorf("item %d is %s, want the newest entries first", i, id)
+		}
+	}
+}
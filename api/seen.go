@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// downloadName is the file name of the image at link, its last path segment without the query
+func downloadName(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// seenSet tells which images were already downloaded, by the links recorded in its manifest and
+// by the file names already classified
+type seenSet struct {
+	// path is the manifest, the links are not persisted when empty
+	path string
+
+	mu    sync.Mutex
+	urls  map[string]bool
+	names map[string]bool
+}
+
+// loadSeen reads the manifest at path, if any, and the names of the files under dirs
+func loadSeen(path string, dirs []string) (*seenSet, error) {
+	s := &seenSet{path: path, urls: map[string]bool{}, names: map[string]bool{}}
+
+	if path != "" {
+		urls, err := readStringSet(path)
+		if err != nil {
+			return nil, err
+		}
+		s.urls = urls
+	}
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				s.names[info.Name()] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// has reports whether the image at link was downloaded before
+func (s *seenSet) has(link string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.urls[link] || s.names[downloadName(link)]
+}
+
+// add records the image at link, saved as name
+func (s *seenSet) add(link, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.urls[link] = true
+	s.names[name] = true
+}
+
+// addLink records the image at link, which was not saved
+func (s *seenSet) addLink(link string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.urls[link] = true
+}
+
+// addName records that an image named name is stored
+func (s *seenSet) addName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names[name] = true
+}
+
+// save replaces the manifest with the links seen so far
+func (s *seenSet) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeStringSet(s.path, s.urls)
+}
+
+// readStringSet reads a set saved by writeStringSet, a missing file is an empty set
+func readStringSet(path string) (map[string]bool, error) {
+	set := map[string]bool{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return set, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set, nil
+}
+
+// writeStringSet replaces the file at path with the sorted JSON list of the values of set
+func writeStringSet(path string, set map[string]bool) error {
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
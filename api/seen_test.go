@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownloadName(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://i.redd.it/abc.jpg", "abc.jpg"},
+		{"https://i.redd.it/abc.jpg?width=640&s=sig", "abc.jpg"},
+		{"https://i.imgur.com/a/b/abc.png#frag", "abc.png"},
+		{"https://i.redd.it/", ""},
+		{"https://i.redd.it", ""},
+		{"://bad", ""},
+	}
+	for _, tt := range tests {
+		if got := downloadName(tt.link); got != tt.want {
+			t.Errorf("downloadName(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadedImagesAreSkipped(t *testing.T) {
+	img := testPNG(t, 30, 20, 1)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		manifest string
+		// fetched is whether the image deleted after the first run is downloaded again
+		fetched bool
+	}{
+		{"with a manifest", "seen.json", false},
+		{"without a manifest", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			atomic.StoreInt32(&requests, 0)
+			run := func(link string) {
+				t.Helper()
+				r := newTestReddit(&Config{SeenManifest: tt.manifest}, srv, post("a", link))
+				err := r.FetchSubmissions()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			run(srv.URL + "/a.png")
+			// the file in hori is found by its name, whatever the rest of the link
+			run(srv.URL + "/mirror/a.png")
+			if n := atomic.LoadInt32(&requests); n != 1 {
+				t.Fatalf("requested %d times, want once", n)
+			}
+
+			err := os.Remove(filepath.Join("hori", "a.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			run(srv.URL + "/a.png")
+			want := int32(1)
+			if tt.fetched {
+				want = 2
+			}
+			if n := atomic.LoadInt32(&requests); n != want {
+				t.Errorf("requested %d times once deleted, want %d", n, want)
+			}
+		})
+	}
+}
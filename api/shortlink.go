@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shortLinkHosts are the url shorteners whose links are expanded before filtering
+var shortLinkHosts = []string{
+	"t.co",
+	"bit.ly",
+	"tinyurl.com",
+	"goo.gl",
+	"ow.ly",
+	"buff.ly",
+	"is.gd",
+	"redd.it",
+}
+
+func isShortLink(link *url.URL) bool {
+	host := strings.TrimPrefix(strings.ToLower(link.Hostname()), "www.")
+	for _, h := range shortLinkHosts {
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// shortLinkExpander follows the redirects of short links, remembering them for the run
+type shortLinkExpander struct {
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func newShortLinkExpander() *shortLinkExpander {
+	return &shortLinkExpander{cache: make(map[string]string)}
+}
+
+func (e *shortLinkExpander) reset() {
+	e.mu.Lock()
+	e.cache = make(map[string]string)
+	e.mu.Unlock()
+}
+
+// expand returns where a short link redirects to, other links are returned as is
+func (e *shortLinkExpander) expand(ctx context.Context, client *http.Client, timeout time.Duration, rawLink string) (string, error) {
+	link, err := url.Parse(rawLink)
+	if err != nil || !isShortLink(link) {
+		return rawLink, nil
+	}
+
+	e.mu.Lock()
+	final, ok := e.cache[rawLink]
+	e.mu.Unlock()
+	if ok {
+		return final, nil
+	}
+
+	ctx, cancel := requestContext(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawLink, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	final = resp.Request.URL.String()
+
+	e.mu.Lock()
+	e.cache[rawLink] = final
+	e.mu.Unlock()
+	return final, nil
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShortLinksAreExpanded(t *testing.T) {
+	img := testPNG(t, 30, 20, 1)
+	var expanded int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/abc":
+			atomic.AddInt32(&expanded, 1)
+			http.Redirect(w, req, srv.URL+"/photo.png", http.StatusMovedPermanently)
+		case "/photo.png":
+			w.Write(img)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		expand bool
+		saved  int
+	}{
+		{"expanded", true, 2},
+		{"dropped by the extension filter", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			atomic.StoreInt32(&expanded, 0)
+			// both submissions share the link, which is expanded once
+			r := newTestReddit(&Config{ExpandShortLinks: tt.expand}, nil,
+				post("a", "https://t.co/abc"), post("b", "https://t.co/abc"))
+			r.client = &http.Client{Transport: redirectTransport{target}}
+			saved, err := r.FetchSubmissionsResults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != tt.saved {
+				t.Fatalf("saved %+v, want %d images", saved, tt.saved)
+			}
+			for _, d := range saved {
+				if d.URL != srv.URL+"/photo.png" {
+					t.Errorf("saved %s, want the expanded link", d.URL)
+				}
+			}
+			if n := atomic.LoadInt32(&expanded); tt.expand && n != 1 {
+				t.Errorf("expanded the link %d times, want once", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// listingState is the pagination progress of a listing
+type listingState struct {
+	// After is the cursor of the next page
+	After string `json:"after"`
+	// Listed is how many submissions the previous pages held
+	Listed int `json:"listed"`
+}
+
+// loadListingState reads the state saved by an interrupted run, an empty path or
+// a missing file start from the top
+func loadListingState(path string) (listingState, error) {
+	var state listingState
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// saveListingState records the progress after each page
+func saveListingState(path string, state listingState) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// write aside and rename so an interruption never leaves a truncated state
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// clearListingState forgets the progress once a listing completed
+func clearListingState(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// listingProgress saves the cursor of a listing once every submission of the pages before it
+// was handled, so an interrupted run lists again the submissions still buffered or downloading
+type listingProgress struct {
+	// path is where the state is saved, nothing is saved when empty
+	path string
+
+	mu    sync.Mutex
+	pages []*listingPage
+	err   error
+}
+
+// listingPage is a listed page whose submissions may still be downloading
+type listingPage struct {
+	progress *listingProgress
+	// state is saved once the page and those before it were handled, last clears it instead
+	state   listingState
+	last    bool
+	pending int
+	sent    bool
+}
+
+func newListingProgress(path string) *listingProgress {
+	return &listingProgress{path: path}
+}
+
+// page adds the next page, after which the listing stands at state, or is complete when last
+func (p *listingProgress) page(state listingState, last bool) *listingPage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	page := &listingPage{progress: p, state: state, last: last}
+	p.pages = append(p.pages, page)
+	return page
+}
+
+// add counts a submission of the page sent to download
+func (page *listingPage) add() {
+	if page == nil {
+		return
+	}
+	page.progress.mu.Lock()
+	defer page.progress.mu.Unlock()
+	page.pending++
+}
+
+// done records that a submission of the page was handled
+func (page *listingPage) done() {
+	if page == nil {
+		return
+	}
+	page.progress.mu.Lock()
+	defer page.progress.mu.Unlock()
+	page.pending--
+	page.progress.advance()
+}
+
+// listed records that every submission of the page was sent
+func (page *listingPage) listed() {
+	page.progress.mu.Lock()
+	defer page.progress.mu.Unlock()
+	page.sent = true
+	page.progress.advance()
+}
+
+// advance saves the state of the leading pages handled completely, p.mu must be held
+func (p *listingProgress) advance() {
+	for len(p.pages) > 0 && p.pages[0].sent && p.pages[0].pending == 0 {
+		page := p.pages[0]
+		p.pages = p.pages[1:]
+		if p.path == "" {
+			continue
+		}
+		var err error
+		if page.last {
+			// the listing is complete, the next run starts from the top
+			err = clearListingState(p.path)
+		} else {
+			err = saveListingState(p.path, page.state)
+		}
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+}
+
+// saveErr returns the first error met saving the state
+func (p *listingProgress) saveErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
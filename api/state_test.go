@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestListingProgressWaitsForTheDownloads(t *testing.T) {
+	defer inTempDir(t)()
+	progress := newListingProgress("state.json")
+
+	first := progress.page(listingState{After: "t3_b", Listed: 2}, false)
+	first.add()
+	first.add()
+	first.listed()
+	second := progress.page(listingState{After: "t3_d", Listed: 4}, false)
+	second.add()
+	second.listed()
+
+	saved := func() listingState {
+		t.Helper()
+		state, err := loadListingState("state.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+	if state := saved(); state.After != "" {
+		t.Fatalf("saved %+v before any download finished", state)
+	}
+	// the second page is done first, the first one still holds the cursor back
+	second.done()
+	first.done()
+	if state := saved(); state.After != "" {
+		t.Fatalf("saved %+v with a download of the first page in flight", state)
+	}
+	first.done()
+	if state := saved(); state.After != "t3_d" || state.Listed != 4 {
+		t.Fatalf("saved %+v, want the cursor after the second page", state)
+	}
+
+	progress.page(listingState{}, true).listed()
+	if _, err := os.Stat("state.json"); !os.IsNotExist(err) {
+		t.Fatalf("the state of a complete listing was kept: %v", err)
+	}
+	if err := progress.saveErr(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListingStateOverALoweredLimit(t *testing.T) {
+	defer inTempDir(t)()
+	err := saveListingState("state.json", listingState{After: "t3_z", Listed: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRedditFromConfig(&Config{Limit: 10, Sort: HotSubmissions, StateFile: "state.json", AllowedExtensions: []string{"png"}})
+	r.Logger = nil
+	r.API = fakeAPI{[]*submission{post("a", "https://i.redd.it/a.png")}}
+	r.current = r.subreddits()[0]
+
+	out := make(chan *submission, 1)
+	_, err = r.listSubmissions(context.Background(), out, make(chan struct{}), newListingProgress("state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Errorf("listed %d submissions past the limit", len(out))
+	}
+	if _, err := os.Stat("state.json"); !os.IsNotExist(err) {
+		t.Errorf("the state past the limit was kept: %v", err)
+	}
+}
+
+func TestListingStopsOnFilteredPages(t *testing.T) {
+	r := NewRedditFromConfig(&Config{Limit: 1000, Sort: HotSubmissions, AllowedExtensions: []string{"png"}})
+	r.Logger = nil
+	// not an image, every page is filtered out
+	api := &endlessAPI{fakeAPI{[]*submission{post("a", "https://example.com/a.html")}}, 0}
+	r.API = api
+	r.current = r.subreddits()[0]
+
+	stop := make(chan struct{})
+	close(stop)
+	_, err := r.listSubmissions(context.Background(), make(chan *submission), stop, newListingProgress(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api.calls > 0 {
+		t.Errorf("listed %d pages after being stopped", api.calls)
+	}
+}
+
+// endlessAPI lists the same page over and over
+type endlessAPI struct {
+	fakeAPI
+	calls int
+}
+
+func (a *endlessAPI) Do(req *http.Request) (*http.Response, error) {
+	a.calls++
+	q := req.URL.Query()
+	q.Del("after")
+	req.URL.RawQuery = q.Encode()
+	return a.fakeAPI.Do(req)
+}
+
+func TestInterruptedListingResumesFromTheCursor(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	var posts []*submission
+	for i := 0; i < 15; i++ {
+		posts = append(posts, post(fmt.Sprint(i), fmt.Sprintf("%s/%d.png", srv.URL, i)))
+	}
+	run := func(failAt int32) (*pagedAPI, []Download, error) {
+		api := &pagedAPI{posts: posts, size: 5, failAt: failAt}
+		r := newTestReddit(&Config{StateFile: "state.json"}, srv)
+		r.API = api
+		saved, err := r.FetchSubmissionsResults()
+		return api, saved, err
+	}
+
+	// the connection drops listing the third page
+	_, saved, err := run(3)
+	if err == nil {
+		t.Fatal("the interrupted run succeeded")
+	}
+	if len(saved) != 10 {
+		t.Errorf("the interrupted run saved %d images, want the 10 of the first two pages", len(saved))
+	}
+	state, err := loadListingState("state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.After != "t3_9" || state.Listed != 10 {
+		t.Fatalf("saved %+v, want the cursor after the second page", state)
+	}
+
+	api, saved, err := run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(api.afters) == 0 || api.afters[0] != "t3_9" {
+		t.Errorf("listed after %q, want to start after t3_9", api.afters)
+	}
+	if len(saved) != 5 {
+		t.Errorf("the resumed run saved %d images, want the 5 of the last page", len(saved))
+	}
+	if _, err := os.Stat("state.json"); !os.IsNotExist(err) {
+		t.Errorf("the state of the complete listing was kept: %v", err)
+	}
+}
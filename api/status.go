@@ -0,0 +1,92 @@
+package api
+
+import (
+	"os"
+	"time"
+)
+
+// Status sums up what the previous runs left behind
+type Status struct {
+	// Records counts the submissions of the store, Posted those whose image was posted
+	Records int
+	Posted  int
+	// Indexed counts the images of the index, Pending those still there and not posted yet
+	Indexed int
+	Pending int
+	// PendingTo counts the pending images by publisher, when the store records the posts
+	PendingTo map[string]int
+	// LastDownload is when the index was last written, zero when there is none
+	LastDownload time.Time
+}
+
+// Status reads the store, the index and the posted images, those not configured count nothing
+func (r *Reddit) Status() (Status, error) {
+	var s Status
+	closeStore, err := r.openStore()
+	if err != nil {
+		return s, err
+	}
+	defer closeStore()
+	if r.records != nil {
+		records, err := r.records.Records()
+		if err != nil {
+			return s, err
+		}
+		s.Records = len(records)
+		for _, rec := range records {
+			if rec.Posted {
+				s.Posted++
+			}
+		}
+	}
+
+	if r.cfg.Index == "" {
+		return s, nil
+	}
+	info, err := os.Stat(r.cfg.Index)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	s.LastDownload = info.ModTime()
+
+	entries, err := readIndex(r.cfg.Index)
+	if err != nil {
+		return s, err
+	}
+	s.Indexed = len(entries)
+	names := r.postingNames()
+	if len(names) > 0 {
+		s.PendingTo = make(map[string]int, len(names))
+	}
+	posted, err := readStringSet(r.cfg.Twitter.Posted)
+	if err != nil {
+		return s, err
+	}
+	for _, e := range entries {
+		if len(names) == 0 && posted[e.Path] {
+			continue
+		}
+		_, err := r.imageSize(e.Path)
+		if err != nil {
+			continue
+		}
+		if len(names) == 0 {
+			s.Pending++
+			continue
+		}
+		pending, err := r.unposted(e, names)
+		if err != nil {
+			return s, err
+		}
+		for _, name := range pending {
+			s.PendingTo[name]++
+		}
+		if len(pending) > 0 {
+			s.Pending++
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/lucbarr/earthpornbot/storage"
+)
+
+// Mirror is a directory receiving a copy of each downloaded image
+type Mirror struct {
+	Dir string
+	// Required fails the download when the copy fails, it is only logged otherwise
+	Required bool
+}
+
+// newMirrorStorage returns the storage copying to mirrors, nil when there is none
+func newMirrorStorage(mirrors []Mirror) storage.Backend {
+	if len(mirrors) == 0 {
+		return nil
+	}
+	backends := make(storage.Multi, 0, len(mirrors))
+	for _, m := range mirrors {
+		backends = append(backends, storage.Member{Backend: storage.Local{Root: m.Dir}, Name: m.Dir, Required: m.Required})
+	}
+	return backends
+}
+
+// mirror copies the file at path to s, keyed by the path
+func mirror(s storage.Backend, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.Put(filepath.ToSlash(path), file)
+}
+
+// StorageConfig selects where the classified images are kept
+type StorageConfig struct {
+	// Type is local, the working directory, or s3
+	Type string
+	S3   storage.S3Config
+	// KeepLocal keeps the local copy of the images stored remotely, they are only in the
+	// output storage otherwise
+	KeepLocal bool `mapstructure:"keepLocal"`
+}
+
+// storedOnly reports whether the images are kept in the output storage rather than on disk
+func (c *Config) storedOnly() bool {
+	return c.Storage.Type == "s3" && !c.Storage.KeepLocal
+}
+
+// checkLocalCopies fails when the images are only in the output storage, for the feature
+// reading them from disk
+func (c *Config) checkLocalCopies(feature string) error {
+	if !c.storedOnly() {
+		return nil
+	}
+	return fmt.Errorf("%s reads the images from disk, it needs subreddit.output.storage.keepLocal", feature)
+}
+
+// CheckLocalCopies fails when the images are only in the output storage, for the feature
+// reading them from disk such as serve
+func (r *Reddit) CheckLocalCopies(feature string) error {
+	return r.cfg.checkLocalCopies(feature)
+}
+
+// localCopiesNeeded returns the errors of the features set that read the images from disk
+func (c *Config) localCopiesNeeded() []error {
+	if !c.storedOnly() {
+		return nil
+	}
+	var errs []error
+	if c.Playlist != "" {
+		errs = append(errs, c.checkLocalCopies("subreddit.output.playlist"))
+	}
+	if len(c.Process.Targets) > 0 {
+		errs = append(errs, c.checkLocalCopies("subreddit.process.targets"))
+	}
+	return errs
+}
+
+// newOutputStorage returns the remote backend the images are stored to through client, nil when
+// they stay in the working directory
+func newOutputStorage(client *http.Client, cfg StorageConfig) (storage.Backend, error) {
+	switch cfg.Type {
+	case "", "local":
+		return nil, nil
+	case "s3":
+		return storage.NewS3(client, cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown type %q, expected local or s3", cfg.Type)
+	}
+}
+
+// localCopy returns a file holding the image at path: path itself when it is on disk, or else a
+// copy of its object in the output storage, under the same name in a temporary directory that
+// done removes. The error satisfies os.IsNotExist when the image is in neither.
+func (r *Reddit) localCopy(path string) (local string, done func(), err error) {
+	done = func() {}
+	_, err = os.Stat(path)
+	if err == nil || !os.IsNotExist(err) || r.output == nil {
+		return path, done, err
+	}
+
+	src, err := r.output.Open(filepath.ToSlash(path))
+	if err == storage.ErrNotFound {
+		return "", done, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return "", done, err
+	}
+	defer src.Close()
+	dir, err := ioutil.TempDir("", "earthpornbot")
+	if err != nil {
+		return "", done, err
+	}
+	done = func() { os.RemoveAll(dir) }
+	local = filepath.Join(dir, filepath.Base(path))
+	dst, err := os.Create(local)
+	if err == nil {
+		_, err = io.Copy(dst, src)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		done()
+		return "", func() {}, err
+	}
+	return local, done, nil
+}
+
+// imageSize returns the size of the image at path, on disk or else in the output storage. The
+// error satisfies os.IsNotExist when the image is in neither.
+func (r *Reddit) imageSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err == nil {
+		return info.Size(), nil
+	}
+	if !os.IsNotExist(err) || r.output == nil {
+		return 0, err
+	}
+	key := filepath.ToSlash(path)
+	objects, err := r.output.List(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, o := range objects {
+		if o.Key == key {
+			return o.Size, nil
+		}
+	}
+	return 0, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+// storedNames records in the seen set the names of the images already in the output storage
+func (r *Reddit) storedNames() error {
+	for _, dir := range r.classifiedDirs() {
+		objects, err := r.output.List(filepath.ToSlash(dir) + "/")
+		if err != nil {
+			return err
+		}
+		for _, o := range objects {
+			r.seen.addName(path.Base(o.Key))
+		}
+	}
+	return nil
+}
+
+// purgeStoredOlderThan deletes the objects of the output storage below dirs stored before cutoff,
+// apart from those of the images of keep, and adds them to removed
+func (r *Reddit) purgeStoredOlderThan(dirs []string, cutoff time.Time, keep, removed map[string]bool) (int, error) {
+	purged := 0
+	for _, dir := range dirs {
+		objects, err := r.output.List(filepath.ToSlash(dir) + "/")
+		if err != nil {
+			return purged, err
+		}
+		for _, o := range objects {
+			if !o.ModTime.Before(cutoff) || kept(keep, o.Key) {
+				continue
+			}
+			err = r.output.Delete(o.Key)
+			if err != nil {
+				return purged, err
+			}
+			removed[o.Key] = true
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	imageproc "github.com/lucbarr/earthpornbot/image"
+	"github.com/lucbarr/earthpornbot/storage"
+)
+
+// fakeBucket is an S3 bucket in memory, its objects keyed below /bucket/
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := strings.TrimPrefix(req.URL.Path, "/bucket/")
+	switch req.Method {
+	case http.MethodPut:
+		b.objects[key], _ = ioutil.ReadAll(req.Body)
+	case http.MethodHead:
+		if _, ok := b.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		if req.URL.Query().Get("list-type") == "" {
+			object, ok := b.objects[key]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write(object)
+			return
+		}
+		var keys []string
+		for k := range b.objects {
+			if strings.HasPrefix(k, req.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, "<ListBucketResult>")
+		for _, k := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>2020-01-02T03:04:05.000Z</LastModified></Contents>", k, len(b.objects[k]))
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	}
+}
+
+// keys returns the keys of the objects stored
+func (b *fakeBucket) keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for k := range b.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readingPublisher posts nothing, it reads the images it was given by file name
+type readingPublisher struct {
+	read map[string][]byte
+}
+
+func (p readingPublisher) Name() string {
+	return "reader"
+}
+
+func (p readingPublisher) Publish(ctx context.Context, rec ManifestRecord) error {
+	data, err := ioutil.ReadFile(rec.Path)
+	if err != nil {
+		return err
+	}
+	p.read[filepath.Base(rec.Path)] = data
+	return nil
+}
+
+func TestImagesAreOnlyInTheBucket(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{"a.png": testPNG(t, 60, 40, 1), "b.png": testPNG(t, 40, 60, 2)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+	bucket := &fakeBucket{objects: map[string][]byte{}}
+	bucketSrv := httptest.NewServer(bucket)
+	defer bucketSrv.Close()
+
+	r := newTestReddit(&Config{Index: "index.jsonl", Store: "store.jsonl", Sidecars: true, Storage: StorageConfig{
+		Type: "s3",
+		S3:   storage.S3Config{Bucket: "bucket", Endpoint: bucketSrv.URL, AccessKey: "key", SecretKey: "secret"},
+	}}, srv, post("a", srv.URL+"/a.png"), post("b", srv.URL+"/b.png"))
+	read := map[string][]byte{}
+	r.Publishers = []Publisher{readingPublisher{read}}
+	err := r.FetchSubmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"hori/a.png", "hori/a.png.json", "vert/b.png", "vert/b.png.json"}
+	if keys := bucket.keys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("stored %v, want %v", keys, want)
+	}
+	if !bytes.Equal(bucket.objects["hori/a.png"], images["a.png"]) || !bytes.Equal(bucket.objects["vert/b.png"], images["b.png"]) {
+		t.Error("the stored images are not those downloaded")
+	}
+	for _, key := range want {
+		if _, err := os.Stat(filepath.FromSlash(key)); !os.IsNotExist(err) {
+			t.Errorf("%s: got %v, want no local copy", key, err)
+		}
+	}
+
+	// the images are read back from the bucket
+	status, err := r.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Pending != 2 {
+		t.Errorf("%d images pending, want 2", status.Pending)
+	}
+	for i := 0; i < 2; i++ {
+		err = r.Publish(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(read, images) {
+		t.Errorf("published %d images, want both as stored", len(read))
+	}
+	purged, err := r.Prune(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := bucket.keys(); purged != 4 || len(keys) != 0 {
+		t.Errorf("purged %d objects, left %v, want every image and sidecar purged", purged, keys)
+	}
+	if r.CheckLocalCopies("serve") == nil {
+		t.Error("serve accepted images kept in the bucket only")
+	}
+}
+
+func TestFeaturesReadingTheDiskNeedTheLocalCopies(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepLocal bool
+		cfg       Config
+		// broken is the feature reported, none when empty
+		broken string
+	}{
+		{"playlist", false, Config{Playlist: "playlist.txt"}, "subreddit.output.playlist"},
+		{"variants", false, Config{Process: imageproc.Config{Targets: []string{"160x90"}}}, "subreddit.process.targets"},
+		{"playlist with the local copies", true, Config{Playlist: "playlist.txt"}, ""},
+		{"nothing reading the disk", false, Config{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Storage = StorageConfig{Type: "s3", S3: storage.S3Config{Bucket: "bucket", AccessKey: "key", SecretKey: "secret"}, KeepLocal: tt.keepLocal}
+			errs := tt.cfg.localCopiesNeeded()
+			if tt.broken == "" {
+				if len(errs) > 0 {
+					t.Errorf("got %v, want none", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.broken) {
+				t.Errorf("got %v, want an error about %s", errs, tt.broken)
+			}
+			// the run keeps the images on disk for the feature
+			r := NewRedditFromConfig(&tt.cfg)
+			if !r.keepLocal {
+				t.Error("the local copies are dropped")
+			}
+		})
+	}
+}
+
+func TestOutputStorageCountsAgainstTheBudget(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer srv.Close()
+
+	r := NewRedditFromConfig(&Config{MaxRequests: 1, Storage: StorageConfig{
+		Type:      "s3",
+		S3:        storage.S3Config{Bucket: "bucket", Endpoint: srv.URL, AccessKey: "key", SecretKey: "secret"},
+		KeepLocal: true,
+	}})
+	r.Logger = nil
+	for i := 0; i < 2; i++ {
+		r.output.Put("hori/a.png", strings.NewReader("image"))
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("the storage sent %d requests over a budget of 1", n)
+	}
+}
+
+func TestImagesAreStoredInTheBucket(t *testing.T) {
+	defer inTempDir(t)()
+	images := map[string][]byte{"a.png": testPNG(t, 60, 40, 1), "b.png": testPNG(t, 40, 60, 2), "old.png": testPNG(t, 60, 40, 3)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+	var mu sync.Mutex
+	stored := map[string][]byte{}
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.Method {
+		case http.MethodPut:
+			stored[req.URL.Path], _ = ioutil.ReadAll(req.Body)
+		case http.MethodGet:
+			// old.png is stored already
+			fmt.Fprint(w, "<ListBucketResult>")
+			if req.URL.Query().Get("prefix") == "hori/" {
+				fmt.Fprint(w, "<Contents><Key>hori/old.png</Key></Contents>")
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		}
+	}))
+	defer bucket.Close()
+
+	r := newTestReddit(&Config{Storage: StorageConfig{
+		Type:      "s3",
+		S3:        storage.S3Config{Bucket: "bucket", Endpoint: bucket.URL, AccessKey: "key", SecretKey: "secret"},
+		KeepLocal: true,
+	}}, srv, post("a", srv.URL+"/a.png"), post("b", srv.URL+"/b.png"), post("old", srv.URL+"/old.png"))
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 {
+		t.Errorf("saved %+v, want the images not in the bucket", saved)
+	}
+	want := map[string][]byte{"/bucket/hori/a.png": images["a.png"], "/bucket/vert/b.png": images["b.png"]}
+	if !reflect.DeepEqual(stored, want) {
+		var keys []string
+		for k := range stored {
+			keys = append(keys, k)
+		}
+		t.Errorf("stored %v, want %s and %s", keys, "/bucket/hori/a.png", "/bucket/vert/b.png")
+	}
+	// the local copies are kept
+	for _, p := range []string{filepath.Join("hori", "a.png"), filepath.Join("vert", "b.png")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestClassifiedImagesAreMirrored(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	r := newTestReddit(&Config{Mirrors: []Mirror{{Dir: "backup", Required: true}}}, srv, post("a", srv.URL+"/a.png"))
+	err := r.FetchSubmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join("hori", "a.png"), filepath.Join("backup", "hori", "a.png")} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil || !bytes.Equal(data, img) {
+			t.Errorf("%s: read %d bytes, %v, want the image", path, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// SubredditConfig is a subreddit listed by the run, its unset fields fall back to the
+// subreddit.submissions ones
+type SubredditConfig struct {
+	Name      string
+	Limit     int32
+	Sort      PopularitySort
+	TimeRange string `mapstructure:"timeRange"`
+	// Dir is where its images are classified, the current directory when empty
+	Dir string
+}
+
+// subredditConfigs fills the unset fields of subs from the defaults, a listed subreddit is
+// classified into a folder named after it unless told otherwise
+func subredditConfigs(subs []SubredditConfig, limit int32, sort PopularitySort, timeRange string) ([]SubredditConfig, error) {
+	for i := range subs {
+		s := &subs[i]
+		s.Name = strings.TrimPrefix(strings.TrimSpace(s.Name), "r/")
+		if s.Name == "" {
+			return nil, fmt.Errorf("entry %d has no name", i+1)
+		}
+		if s.Limit == 0 {
+			s.Limit = limit
+		}
+		if s.Sort == "" {
+			s.Sort = sort
+		}
+		if s.TimeRange == "" {
+			s.TimeRange = timeRange
+		}
+		if s.Dir == "" {
+			s.Dir = s.Name
+		}
+	}
+	return subs, nil
+}
+
+// subreddits are the subreddits of a run, subreddit.name alone unless a list is configured
+func (r *Reddit) subreddits() []SubredditConfig {
+	if len(r.cfg.Subreddits) > 0 {
+		return r.cfg.Subreddits
+	}
+	return []SubredditConfig{{
+		Name:      r.subreddit,
+		Limit:     r.cfg.Limit,
+		Sort:      r.cfg.Sort,
+		TimeRange: r.cfg.TimeRange,
+	}}
+}
+
+// subredditNames joins the names of the run subreddits the way Reddit combines them, a+b
+func (r *Reddit) subredditNames() string {
+	var names []string
+	for _, s := range r.subreddits() {
+		names = append(names, s.Name)
+	}
+	return strings.Join(names, "+")
+}
+
+// outputPath is elem within the output folder of the subreddit being fetched
+func (r *Reddit) outputPath(elem ...string) string {
+	return filepath.Join(append([]string{r.current.Dir}, elem...)...)
+}
+
+// listingStateFile is where the pagination progress of the subreddit being fetched is saved,
+// each listed subreddit gets its own file
+func (r *Reddit) listingStateFile() string {
+	path := r.cfg.StateFile
+	if path == "" || len(r.cfg.Subreddits) == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + r.current.Name + ext
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// recordingAPI lists nothing, remembering the listings it was asked for
+type recordingAPI struct {
+	mu      sync.Mutex
+	paths   []string
+	queries []url.Values
+}
+
+func (*recordingAPI) Login(ctx context.Context) error {
+	return nil
+}
+
+func (a *recordingAPI) Do(req *http.Request) (*http.Response, error) {
+	a.mu.Lock()
+	a.paths = append(a.paths, req.URL.Path)
+	a.queries = append(a.queries, req.URL.Query())
+	a.mu.Unlock()
+	return fakeAPI{}.Do(req)
+}
+
+func TestTheConfiguredSubredditIsListed(t *testing.T) {
+	defer viper.Reset()
+	tests := []struct {
+		label string
+		name  string
+		want  string
+	}{
+		{"configured", "ExposurePorn", "/r/ExposurePorn/hot.json"},
+		{"default", "", "/r/earthporn/hot.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			defer inTempDir(t)()
+			viper.Reset()
+			viper.Set("subreddit.name", tt.name)
+			viper.Set("subreddit.submissions.limit", 10)
+			r := NewReddit()
+			r.Logger = nil
+			api := &recordingAPI{}
+			r.API = api
+			err := r.FetchSubmissions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(api.paths) != 1 || api.paths[0] != tt.want {
+				t.Errorf("listed %v, want %s", api.paths, tt.want)
+			}
+		})
+	}
+}
+
+// subredditAPI lists the posts of each subreddit, by the path of its listing
+type subredditAPI map[string][]*submission
+
+func (subredditAPI) Login(ctx context.Context) error {
+	return nil
+}
+
+func (a subredditAPI) Do(req *http.Request) (*http.Response, error) {
+	return fakeAPI{a[req.URL.Path]}.Do(req)
+}
+
+func TestSubredditConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      SubredditConfig
+		want    SubredditConfig
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			in:   SubredditConfig{Name: "pics"},
+			want: SubredditConfig{Name: "pics", Limit: 25, Sort: HotSubmissions, Dir: "pics"},
+		},
+		{
+			name: "prefixed",
+			in:   SubredditConfig{Name: " r/pics "},
+			want: SubredditConfig{Name: "pics", Limit: 25, Sort: HotSubmissions, Dir: "pics"},
+		},
+		{
+			name: "overrides",
+			in:   SubredditConfig{Name: "pics", Limit: 5, Sort: TopSubmissions, TimeRange: "week", Dir: "photos"},
+			want: SubredditConfig{Name: "pics", Limit: 5, Sort: TopSubmissions, TimeRange: "week", Dir: "photos"},
+		},
+		{name: "no name", in: SubredditConfig{Dir: "photos"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := subredditConfigs([]SubredditConfig{tt.in}, 25, HotSubmissions, "")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s: got %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestEachSubredditIsFetchedIntoItsDir(t *testing.T) {
+	defer inTempDir(t)()
+	defer viper.Reset()
+	images := map[string][]byte{"a.png": testPNG(t, 60, 40, 1), "b.png": testPNG(t, 40, 60, 2)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(images[path.Base(req.URL.Path)])
+	}))
+	defer srv.Close()
+
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+subreddit:
+  submissions:
+    limit: 10
+    allowedExtensions: [png]
+subreddits:
+  - name: r/pics
+    sort: top
+    timeRange: week
+  - name: EarthPorn
+    dir: landscapes
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRedditFromConfig(defaultConfig())
+	r.Logger = nil
+	r.client = srv.Client()
+	a, b := post("a", srv.URL+"/a.png"), post("b", srv.URL+"/b.png")
+	a.Subreddit, b.Subreddit = "pics", "EarthPorn"
+	r.API = subredditAPI{"/r/pics/top.json": {a}, "/r/EarthPorn/hot.json": {b}}
+	saved, err := r.FetchSubmissionsResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Path < saved[j].Path })
+	want := []struct{ path, subreddit string }{
+		{filepath.Join("landscapes", "vert", "b.png"), "EarthPorn"},
+		{filepath.Join("pics", "hori", "a.png"), "pics"},
+	}
+	if len(saved) != len(want) {
+		t.Fatalf("saved %+v, want %v", saved, want)
+	}
+	for i, w := range want {
+		if saved[i].Path != w.path || saved[i].Subreddit != w.subreddit {
+			t.Errorf("saved %+v, want %s from r/%s", saved[i], w.path, w.subreddit)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const telegramSendPhotoURL = "https://api.telegram.org/bot%s/sendPhoto"
+
+// maxTelegramPhotoBytes is the largest photo the Telegram bot API accepts
+const maxTelegramPhotoBytes = 10 << 20
+
+// telegramCaptionLength is the longest caption of a Telegram photo
+const telegramCaptionLength = 1024
+
+// telegramPublisher posts the images to a Telegram chat or channel through a bot
+type telegramPublisher struct {
+	name   string
+	client *http.Client
+	token  string
+	chatID string
+}
+
+func (t telegramPublisher) Name() string {
+	return t.name
+}
+
+func (t telegramPublisher) Publish(ctx context.Context, rec ManifestRecord) error {
+	err := checkSize(rec.Path, maxTelegramPhotoBytes)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(rec.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	err = w.WriteField("chat_id", t.chatID)
+	if err != nil {
+		return err
+	}
+	err = w.WriteField("caption", caption(rec, telegramCaptionLength, len(rec.Permalink)))
+	if err != nil {
+		return err
+	}
+	part, err := w.CreateFormFile("photo", filepath.Base(rec.Path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(telegramSendPhotoURL, t.token), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// the url holds the token, which must not end up in the logs
+		return fmt.Errorf("telegram request failed: %v", errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+
+	var answer struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&answer)
+	if err != nil {
+		return fmt.Errorf("telegram answered %s", resp.Status)
+	}
+	if !answer.OK {
+		return fmt.Errorf("telegram answered %s: %s", resp.Status, answer.Description)
+	}
+	return nil
+}
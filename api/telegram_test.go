@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTelegramSendsThePhotoWithItsCaption(t *testing.T) {
+	defer inTempDir(t)()
+	img := testPNG(t, 30, 20, 1)
+	err := ioutil.WriteFile("lake.png", img, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path, chatID, caption, filename string
+	var photo []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path, chatID, caption = req.URL.Path, req.FormValue("chat_id"), req.FormValue("caption")
+		file, header, err := req.FormFile("photo")
+		if err != nil {
+			fmt.Fprint(w, `{"ok":false,"description":"no photo"}`)
+			return
+		}
+		defer file.Close()
+		filename = header.Filename
+		photo, _ = ioutil.ReadAll(file)
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := telegramPublisher{"telegram", &http.Client{Transport: redirectTransport{target}}, "123:token", "@landscapes"}
+	err = p.Publish(context.Background(), ManifestRecord{
+		Path: "lake.png", Title: "Lake Bled", Author: "photographer", Permalink: "https://reddit.com/r/EarthPorn/comments/lake/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bot123:token/sendPhoto" || chatID != "@landscapes" || filename != "lake.png" || string(photo) != string(img) {
+		t.Errorf("sent %s to %s, %s of %d bytes", path, chatID, filename, len(photo))
+	}
+	if want := "Lake Bled by u/photographer\nhttps://reddit.com/r/EarthPorn/comments/lake/"; caption != want {
+		t.Errorf("captioned %q, want %q", caption, want)
+	}
+}
+
+func TestTelegramErrors(t *testing.T) {
+	defer inTempDir(t)()
+	err := ioutil.WriteFile("lake.png", testPNG(t, 30, 20, 1), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huge, err := os.Create("huge.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = huge.Truncate(maxTelegramPhotoBytes + 1)
+	huge.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		answer func(w http.ResponseWriter)
+		want   string
+	}{
+		{"refused", "lake.png", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"ok":false,"description":"Bad Request: chat not found"}`)
+		}, "chat not found"},
+		{"not json", "lake.png", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, "502"},
+		{"too large", "huge.png", nil, errTooLarge.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if tt.answer == nil {
+					t.Error("sent an image over the limit")
+					return
+				}
+				tt.answer(w)
+			}))
+			defer srv.Close()
+			target, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := telegramPublisher{"telegram", &http.Client{Transport: redirectTransport{target}}, "123:token", "@landscapes"}
+			err = p.Publish(context.Background(), ManifestRecord{Path: tt.path})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	// the token is part of the url, a failed request must not reveal it
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	target, err := url.Parse(closed.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := telegramPublisher{"telegram", &http.Client{Transport: redirectTransport{target}}, "123:token", "@landscapes"}
+	err = p.Publish(context.Background(), ManifestRecord{Path: "lake.png"})
+	if err == nil || strings.Contains(err.Error(), "token") {
+		t.Errorf("got %v, want an error without the token", err)
+	}
+}
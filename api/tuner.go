@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"time"
+)
+
+// maxAdaptiveWorkers caps the adaptive concurrency
+const maxAdaptiveWorkers = 32
+
+// concurrencyTuner adapts the number of simultaneous downloads to the measured throughput:
+// after each window of as many downloads as workers it adds a worker while the throughput
+// improves, removes one when it degrades, and halves the workers when a host throttles us.
+// It is only used by the dispatching goroutine.
+type concurrencyTuner struct {
+	workers int
+	max     int
+	clock   Clock
+
+	window      int
+	windowBytes int64
+	windowStart time.Time
+	lastRate    float64
+}
+
+func newConcurrencyTuner(max int, c Clock) *concurrencyTuner {
+	workers := 2
+	if workers > max {
+		workers = max
+	}
+	return &concurrencyTuner{
+		workers:     workers,
+		max:         max,
+		clock:       c,
+		windowStart: c.Now(),
+	}
+}
+
+// limit is the number of downloads that may currently be in flight
+func (t *concurrencyTuner) limit() int {
+	return t.workers
+}
+
+// done records a finished download
+func (t *concurrencyTuner) done(d *download, err error) {
+	if isThrottled(err) {
+		t.workers /= 2
+		if t.workers < 1 {
+			t.workers = 1
+		}
+		t.resetWindow(0)
+		return
+	}
+
+	t.window++
+	if d != nil {
+		t.windowBytes += d.size
+	}
+	if t.window < t.workers {
+		return
+	}
+
+	elapsed := t.clock.Now().Sub(t.windowStart).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(t.windowBytes) / elapsed
+	switch {
+	case rate > t.lastRate*1.05 && t.workers < t.max:
+		t.workers++
+	case rate < t.lastRate*0.9 && t.workers > 1:
+		t.workers--
+	}
+	t.resetWindow(rate)
+}
+
+func (t *concurrencyTuner) resetWindow(rate float64) {
+	t.window = 0
+	t.windowBytes = 0
+	t.windowStart = t.clock.Now()
+	t.lastRate = rate
+}
+
+// isThrottled tells whether err, or an error it wraps, means the host wants us to slow down
+func isThrottled(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && (se.code == 429 || se.code >= 500)
+}
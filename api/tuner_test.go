@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// saturatingHost serves at most best simultaneous downloads at full speed, more of them
+// slow every download down
+func saturatingHost(workers, best int) float64 {
+	const bytesPerSecond = 1 << 20
+	if workers <= best {
+		return float64(workers * bytesPerSecond)
+	}
+	// contention costs more than the extra workers bring
+	return float64(best*bytesPerSecond) * float64(best) / float64(workers)
+}
+
+func TestTunerSettlesNearTheBestConcurrency(t *testing.T) {
+	const size = 1 << 20
+	clock := fixedClock(time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+	tuner := newConcurrencyTuner(maxAdaptiveWorkers, &clock)
+
+	var most int
+	for window := 0; window < 200; window++ {
+		workers := tuner.limit()
+		if workers > most {
+			most = workers
+		}
+		// a window of downloads, finishing one after the other at the aggregate throughput
+		elapsed := time.Duration(float64(workers*size) / saturatingHost(workers, 6) * float64(time.Second))
+		for i := 0; i < workers; i++ {
+			clock = fixedClock(time.Time(clock).Add(elapsed / time.Duration(workers)))
+			tuner.done(&download{size: size}, nil)
+		}
+	}
+	if got := tuner.limit(); got < 4 || got > 8 {
+		t.Errorf("settled at %d workers, want about 6", got)
+	}
+	if most > 10 {
+		t.Errorf("went up to %d workers", most)
+	}
+}
+
+func TestTunerBacksOffWhenThrottled(t *testing.T) {
+	clock := fixedClock(time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+	tuner := newConcurrencyTuner(maxAdaptiveWorkers, &clock)
+	tuner.workers = 8
+
+	tests := []struct {
+		err     error
+		workers int
+	}{
+		{&statusError{code: http.StatusTooManyRequests}, 4},
+		{&statusError{code: http.StatusNotFound}, 4},
+		{&statusError{code: http.StatusServiceUnavailable}, 2},
+		{&statusError{code: http.StatusTooManyRequests}, 1},
+		{&statusError{code: http.StatusTooManyRequests}, 1},
+	}
+	for _, tt := range tests {
+		tuner.done(nil, tt.err)
+		if got := tuner.limit(); got != tt.workers {
+			t.Errorf("after %v: %d workers, want %d", tt.err, got, tt.workers)
+		}
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"too many requests", &statusError{code: http.StatusTooManyRequests}, true},
+		{"server error", &statusError{code: http.StatusBadGateway}, true},
+		{"not found", &statusError{code: http.StatusNotFound}, false},
+		{"wrapped", fmt.Errorf("attempt 2: %w", &statusError{code: http.StatusServiceUnavailable}), true},
+		{"other", errors.New("connection reset"), false},
+		{"none", nil, false},
+	}
+	for _, tt := range tests {
+		if got := isThrottled(tt.err); got != tt.want {
+			t.Errorf("%s: isThrottled(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TwitterConfig holds the OAuth 1.0a keys of the account the images are posted to
+type TwitterConfig struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	AccessToken    string
+	AccessSecret   string
+	// Posted records the images already posted so none is posted twice
+	Posted string
+}
+
+const (
+	twitterUploadURL = "https://upload.twitter.com/1.1/media/upload.json"
+	twitterTweetURL  = "https://api.twitter.com/2/tweets"
+)
+
+// maxTweetImageBytes is the largest image Twitter accepts
+const maxTweetImageBytes = 5 << 20
+
+const (
+	tweetLength = 280
+	// tweetURLLength is what any link counts for once shortened by t.co
+	tweetURLLength = 23
+)
+
+// errNothingToPost is returned by PostNext when every indexed image was posted
+var errNothingToPost = errors.New("nothing new to post")
+
+// PostNext tweets the newest indexed image not posted yet, with the submission title and
+// a credit to its Reddit author. It needs the index, which is where the images are picked from.
+// The posts are recorded in the store, as those of a twitter publisher, or without one in
+// notify.twitter.posted.
+func (r *Reddit) PostNext(ctx context.Context) error {
+	tw := r.cfg.Twitter
+	if tw.ConsumerKey == "" || tw.AccessToken == "" {
+		return errors.New("notify.twitter is not configured")
+	}
+	if r.cfg.Index == "" {
+		return errors.New("posting picks the images from subreddit.output.index, which is not set")
+	}
+	closeStore, err := r.openStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	entries, err := readIndex(r.cfg.Index)
+	if err != nil {
+		return err
+	}
+	if r.records != nil {
+		return r.publishNext(ctx, r.twitterPublisher(), entries)
+	}
+	posted, err := readStringSet(tw.Posted)
+	if err != nil {
+		return err
+	}
+
+	entry, err := r.nextToPost(entries, posted)
+	if err == errNothingToPost {
+		r.Logger.Info("nothing new to post")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r.cfg.DryRun {
+		r.Logger.Info("would post image", "path", entry.Path, "publisher", "twitter")
+		return nil
+	}
+
+	signer := oauth1{tw.ConsumerKey, tw.ConsumerSecret, tw.AccessToken, tw.AccessSecret, redditClock{r}}
+	local, done, err := r.localCopy(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer done()
+	mediaID, err := uploadMedia(ctx, r.client, signer, local)
+	if err != nil {
+		return fmt.Errorf("could not upload %s: %v", entry.Path, err)
+	}
+	err = tweet(ctx, r.client, signer, caption(manifestRecord(entry), tweetLength, tweetURLLength), mediaID)
+	if err != nil {
+		return fmt.Errorf("could not tweet %s: %v", entry.Path, err)
+	}
+	r.Logger.Info("posted image", "path", entry.Path, "publisher", "twitter")
+	postsPublished.Inc("twitter")
+
+	posted[entry.Path] = true
+	return writeStringSet(tw.Posted, posted)
+}
+
+// twitterPublisher returns the twitter publisher among the publishers, or else one tweeting
+// with notify.twitter, so PostNext records its posts under the same name
+func (r *Reddit) twitterPublisher() Publisher {
+	for _, p := range r.Publishers {
+		if _, ok := p.(twitterPublisher); ok {
+			return p
+		}
+	}
+	tw := r.cfg.Twitter
+	return twitterPublisher{"twitter", r.client, oauth1{tw.ConsumerKey, tw.ConsumerSecret, tw.AccessToken, tw.AccessSecret, redditClock{r}}}
+}
+
+// nextToPost returns the newest entry not posted yet whose image is still there, on disk or in
+// the output storage, and small enough
+func (r *Reddit) nextToPost(entries []indexEntry, posted map[string]bool) (indexEntry, error) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if posted[e.Path] {
+			continue
+		}
+		size, err := r.imageSize(e.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return indexEntry{}, err
+		}
+		if size > maxTweetImageBytes {
+			continue
+		}
+		return e, nil
+	}
+	return indexEntry{}, errNothingToPost
+}
+
+// caption is the title, shortened to fit in limit characters, the author credit and the
+// permalink, which counts for linkLength characters
+func caption(rec ManifestRecord, limit, linkLength int) string {
+	credit := ""
+	if rec.Author != "" {
+		credit = " by u/" + rec.Author
+	}
+	room := limit - len([]rune(credit))
+	if rec.Permalink != "" {
+		room -= 1 + linkLength
+	}
+
+	title := []rune(rec.Title)
+	if len(title) > room {
+		title = append(title[:room-1], '…')
+	}
+	text := string(title) + credit
+	if rec.Permalink != "" {
+		text += "\n" + rec.Permalink
+	}
+	return strings.TrimSpace(text)
+}
+
+// twitterPublisher tweets the images to the account of notify.twitter
+type twitterPublisher struct {
+	name   string
+	client *http.Client
+	signer oauth1
+}
+
+func (t twitterPublisher) Name() string {
+	return t.name
+}
+
+func (t twitterPublisher) Publish(ctx context.Context, rec ManifestRecord) error {
+	err := checkSize(rec.Path, maxTweetImageBytes)
+	if err != nil {
+		return err
+	}
+	mediaID, err := uploadMedia(ctx, t.client, t.signer, rec.Path)
+	if err != nil {
+		return fmt.Errorf("could not upload: %v", err)
+	}
+	return tweet(ctx, t.client, t.signer, caption(rec, tweetLength, tweetURLLength), mediaID)
+}
+
+// uploadMedia uploads the image at path and returns its media id
+func uploadMedia(ctx context.Context, client *http.Client, signer oauth1, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("media", filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, twitterUploadURL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	// multipart fields are not part of the signature
+	signer.sign(req)
+
+	var uploaded struct {
+		MediaID string `json:"media_id_string"`
+	}
+	err = doTwitter(client, req, &uploaded)
+	if err != nil {
+		return "", err
+	}
+	if uploaded.MediaID == "" {
+		return "", errors.New("no media id in the upload response")
+	}
+	return uploaded.MediaID, nil
+}
+
+// tweet posts text with the uploaded media attached
+func tweet(ctx context.Context, client *http.Client, signer oauth1, text, mediaID string) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"text":  text,
+		"media": map[string][]string{"media_ids": {mediaID}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, twitterTweetURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signer.sign(req)
+	return doTwitter(client, req, nil)
+}
+
+// doTwitter sends req and decodes the JSON answer into out, if not nil
+func doTwitter(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("twitter answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// oauth1 signs requests with OAuth 1.0a HMAC-SHA1, as the Twitter media upload requires
+type oauth1 struct {
+	consumerKey    string
+	consumerSecret string
+	token          string
+	tokenSecret    string
+	// clock dates the signatures
+	clock Clock
+}
+
+// sign sets the Authorization header of req, whose body must not be url-encoded parameters
+func (o oauth1) sign(req *http.Request) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	oauth := map[string]string{
+		"oauth_consumer_key":     o.consumerKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(o.clock.Now().Unix(), 10),
+		"oauth_token":            o.token,
+		"oauth_version":          "1.0",
+	}
+
+	var params []string
+	for k, v := range oauth {
+		params = append(params, oauthEscape(k)+"="+oauthEscape(v))
+	}
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			params = append(params, oauthEscape(k)+"="+oauthEscape(v))
+		}
+	}
+	sort.Strings(params)
+
+	baseURL := *req.URL
+	baseURL.RawQuery = ""
+	baseURL.Fragment = ""
+	base := req.Method + "&" + oauthEscape(baseURL.String()) + "&" + oauthEscape(strings.Join(params, "&"))
+
+	mac := hmac.New(sha1.New, []byte(oauthEscape(o.consumerSecret)+"&"+oauthEscape(o.tokenSecret)))
+	mac.Write([]byte(base))
+	oauth["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	header := make([]string, 0, len(oauth))
+	for k, v := range oauth {
+		header = append(header, fmt.Sprintf(`%s="%s"`, oauthEscape(k), oauthEscape(v)))
+	}
+	sort.Strings(header)
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+}
+
+// oauthEscape percent-encodes s as RFC 3986 requires, which differs from query escaping
+// only by the space
+func oauthEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaption(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name string
+		rec  ManifestRecord
+		want string
+	}{
+		{
+			"title, credit and link",
+			ManifestRecord{Title: "Valley [4000x3000]", Author: "someone", Permalink: "https://reddit.com/r/earthporn/comments/a"},
+			"Valley [4000x3000] by u/someone\nhttps://reddit.com/r/earthporn/comments/a",
+		},
+		{"no author", ManifestRecord{Title: "Valley"}, "Valley"},
+		{"no title", ManifestRecord{Author: "someone"}, "by u/someone"},
+		{
+			"long title",
+			ManifestRecord{Title: long, Author: "someone", Permalink: "https://reddit.com/a"},
+			// the link counts for 23 characters, whatever its length
+			strings.Repeat("a", 280-len(" by u/someone")-1-23-1) + "… by u/someone\nhttps://reddit.com/a",
+		},
+	}
+	for _, tt := range tests {
+		if got := caption(tt.rec, tweetLength, tweetURLLength); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// oauthParams parses the parameters of an OAuth Authorization header
+func oauthParams(t *testing.T, header string) map[string]string {
+	t.Helper()
+	if !strings.HasPrefix(header, "OAuth ") {
+		t.Fatalf("Authorization: %q", header)
+	}
+	params := map[string]string{}
+	for _, kv := range strings.Split(strings.TrimPrefix(header, "OAuth "), ", ") {
+		eq := strings.Index(kv, "=")
+		value, err := url.PathUnescape(strings.Trim(kv[eq+1:], `"`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params[kv[:eq]] = value
+	}
+	return params
+}
+
+func TestOAuth1Signature(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	signer := oauth1{"key", "consumer secret", "token", "token secret", fixedClock(now)}
+	req, err := http.NewRequest(http.MethodPost, "https://upload.twitter.com/1.1/media/upload.json?media_category=tweet_image", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.sign(req)
+
+	params := oauthParams(t, req.Header.Get("Authorization"))
+	if params["oauth_consumer_key"] != "key" || params["oauth_token"] != "token" ||
+		params["oauth_signature_method"] != "HMAC-SHA1" || params["oauth_timestamp"] != "1591012800" ||
+		params["oauth_version"] != "1.0" || params["oauth_nonce"] == "" {
+		t.Fatalf("signed with %v", params)
+	}
+
+	// the signature base string of RFC 5849 section 3.4.1, built by hand
+	var pairs []string
+	for k, v := range params {
+		if k != "oauth_signature" {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	pairs = append(pairs, "media_category=tweet_image")
+	sort.Strings(pairs)
+	base := "POST&" + url.QueryEscape("https://upload.twitter.com/1.1/media/upload.json") + "&" +
+		url.QueryEscape(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte("consumer%20secret&token%20secret"))
+	mac.Write([]byte(base))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); params["oauth_signature"] != want {
+		t.Errorf("signature %s, want %s", params["oauth_signature"], want)
+	}
+}
+
+func TestPostNextUploadsTheImageWithItsCaption(t *testing.T) {
+	defer inTempDir(t)()
+	var uploaded []byte
+	var text string
+	var mediaIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "OAuth ") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/1.1/media/upload.json":
+			file, _, err := req.FormFile("media")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			uploaded, _ = ioutil.ReadAll(file)
+			w.Write([]byte(`{"media_id_string": "42"}`))
+		case "/2/tweets":
+			var payload struct {
+				Text  string `json:"text"`
+				Media struct {
+					MediaIDs []string `json:"media_ids"`
+				} `json:"media"`
+			}
+			json.NewDecoder(req.Body).Decode(&payload)
+			text, mediaIDs = payload.Text, payload.Media.MediaIDs
+		}
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeImages(t, "index.jsonl", nil, indexEntry{
+		ID: "a", Path: "hori/a.png", Title: "Valley", Author: "someone", Permalink: "https://reddit.com/r/earthporn/comments/a",
+	})
+	r := NewRedditFromConfig(&Config{
+		Index:   "index.jsonl",
+		Twitter: TwitterConfig{ConsumerKey: "key", AccessToken: "token", Posted: "posted.json"},
+	})
+	r.Logger = nil
+	r.client = &http.Client{Transport: redirectTransport{target}}
+	err = r.PostNext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(uploaded) != "hori/a.png" {
+		t.Errorf("uploaded %q, want the image", uploaded)
+	}
+	if text != "Valley by u/someone\nhttps://reddit.com/r/earthporn/comments/a" || len(mediaIDs) != 1 || mediaIDs[0] != "42" {
+		t.Errorf("tweeted %q with %v", text, mediaIDs)
+	}
+	posted, err := readStringSet("posted.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !posted["hori/a.png"] {
+		t.Errorf("recorded %v, want the image posted", posted)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lucbarr/earthpornbot/filter"
+	imageproc "github.com/lucbarr/earthpornbot/image"
+)
+
+// Validate reports every problem of the config at once, nil when it is usable
+func (c *Config) Validate() error {
+	errs := append(multiError(nil), c.problems...)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.ClientID != "", "credentials.app.client-id is not set")
+	switch c.AuthMode {
+	case AuthScript:
+		check(c.User != "", "credentials.user is not set")
+		check(c.Password != "", "credentials.password is not set")
+		check(c.ClientSecret != "", "credentials.app.client-secret is not set")
+	case AuthAppOnly:
+		// the client id is enough, installed apps have no secret
+	case AuthRefreshToken:
+		check(c.RefreshToken != "", "credentials.refresh-token is not set")
+	default:
+		check(false, "credentials.auth-mode must be script, app-only or refresh-token, got %q", c.AuthMode)
+	}
+
+	err := checkListing(c.Sort, c.TimeRange)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	check(c.Limit > 0, "subreddit.submissions.limit must be positive, got %d", c.Limit)
+	for _, s := range c.Subreddits {
+		err := checkListing(s.Sort, s.TimeRange)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subreddits: r/%s: %v", s.Name, err))
+		}
+		check(s.Limit > 0, "subreddits: r/%s: limit must be positive, got %d", s.Name, s.Limit)
+	}
+	check(c.PerHostConcurrency >= 0, "subreddit.submissions.perHostConcurrency must not be negative")
+	check(c.ListingBuffer >= 0, "subreddit.submissions.listingBuffer must not be negative")
+	check(c.MaxPerAuthor >= 0, "subreddit.submissions.maxPerAuthor must not be negative")
+	check(c.MaxRequests >= 0, "subreddit.submissions.maxRequests must not be negative")
+	check(c.Retries >= 0, "subreddit.submissions.retries must not be negative")
+	check(c.MaxPixels >= 0, "subreddit.submissions.maxPixels must not be negative")
+	check(c.Timeout >= 0, "subreddit.submissions.timeout must not be negative")
+	check(c.BytesPerSecondFloor >= 0, "subreddit.submissions.bytesPerSecondFloor must not be negative")
+	check(c.PlaylistFormat == "" || c.PlaylistFormat == playlistList || c.PlaylistFormat == playlistGNOME,
+		"subreddit.output.playlist.format must be list or gnome, got %q", c.PlaylistFormat)
+	check(c.RetentionDays >= 0, "subreddit.output.retentionDays must not be negative")
+	check(c.RetentionMaxFiles >= 0, "subreddit.output.retention.maxFiles must not be negative")
+	check(c.RetentionOrder == "" || c.RetentionOrder == retainOldest || c.RetentionOrder == retainLowestScore,
+		"subreddit.output.retention.order must be oldest or lowestScore, got %q", c.RetentionOrder)
+	check(c.RSSMaxEntries >= 0, "subreddit.output.rss.maxEntries must not be negative")
+	check(c.VerifyTimeout >= 0, "subreddit.output.verifyTimeout must not be negative")
+	check(c.DedupeThreshold >= 0 && c.DedupeThreshold <= 64,
+		"subreddit.dedupe.threshold must be between 0 and 64, got %d", c.DedupeThreshold)
+	check(c.SanityAspectMin <= 0 || c.SanityAspectMax <= 0 || c.SanityAspectMin <= c.SanityAspectMax,
+		"subreddit.classify.sanityAspect.min is above max")
+	check(c.DisplayAspectTolerance >= 0, "subreddit.classify.byDisplayAspect.tolerance must not be negative")
+	check(c.HorizontalLimit >= 0 && c.VerticalLimit >= 0, "subreddit.classify.limits must not be negative")
+	check(c.Concurrency >= 0, "runtime.concurrency must not be negative")
+
+	_, err = filter.New(c.Filters)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("subreddit.filters: %v", err))
+	}
+	_, err = imageproc.New(c.Process)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("subreddit.process: %v", err))
+	}
+	_, err = newOutputStorage(http.DefaultClient, c.Storage)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("subreddit.output.storage: %v", err))
+	}
+	errs = append(errs, c.localCopiesNeeded()...)
+	_, err = newPublishers(http.DefaultClient, RealClock{}, c.Publishers, c.Twitter)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("publishers: %v", err))
+	}
+	check(len(c.Publishers) == 0 || (c.Index != "" && c.Store != ""),
+		"publishers need subreddit.output.index and subreddit.output.store")
+
+	patterns, err := extensionPatterns(c.AllowedExtensions)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("subreddit.submissions.allowedExtensions: %v", err))
+	} else if len(patterns) == 0 {
+		errs = append(errs, errors.New("subreddit.submissions.allowedExtensions is empty"))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
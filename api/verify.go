@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// defaultVerifyTimeout bounds the verify command when no timeout is configured
+const defaultVerifyTimeout = 30 * time.Second
+
+// errVerifyRejected is wrapped by the errors of verifyFile for files the command rejected
+var errVerifyRejected = errors.New("rejected by the verify command")
+
+// verifyFile runs command with path appended as its last argument,
+// a non-zero exit, wrapping errVerifyRejected, or a timeout means the file failed verification
+func verifyFile(command []string, timeout time.Duration, path string) error {
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := append(append([]string(nil), command[1:]...), path)
+	out, err := exec.CommandContext(ctx, command[0], args...).CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("verify command timed out after %s", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%w: %v: %s", errVerifyRejected, err, out)
+	}
+	if err != nil {
+		return fmt.Errorf("verify command failed: %v: %s", err, out)
+	}
+	return nil
+}
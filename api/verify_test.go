@@ -0,0 +1,63 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyCommand(t *testing.T) {
+	img := testPNG(t, 30, 20, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		script string
+		saved  bool
+		failed bool
+	}{
+		{"accepted", "exit 0", true, false},
+		{"rejected", "echo corrupt >&2; exit 1", false, false},
+		{"timed out", "exec sleep 5", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer inTempDir(t)()
+			err := ioutil.WriteFile("verify.sh", []byte("#!/bin/sh\n"+tt.script+"\n"), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := newTestReddit(&Config{
+				VerifyCommand: []string{filepath.Join(wd, "verify.sh")},
+				VerifyTimeout: 200 * time.Millisecond,
+			}, srv, post("a", srv.URL+"/a.png"))
+			saved, err := r.FetchSubmissionsResults()
+			if (err != nil) != tt.failed {
+				t.Errorf("got %v, want failed %v", err, tt.failed)
+			}
+			if (len(saved) == 1) != tt.saved {
+				t.Errorf("saved %+v, want saved %v", saved, tt.saved)
+			}
+			if tt.saved {
+				return
+			}
+			for _, path := range []string{"a.png", filepath.Join("hori", "a.png")} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s is left: %v", path, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lucbarr/earthpornbot/api"
+	"github.com/lucbarr/earthpornbot/logging"
+	"github.com/spf13/viper"
+)
+
+// defaultInterval is the time between two runs of the daemon when schedule.interval is not set
+const defaultInterval = time.Hour
+
+// daemon fetches every interval of clock until ctx is cancelled, and posts the next image after
+// each run when publishers or Twitter are configured. A failed run is logged and the next one
+// happens anyway.
+func daemon(ctx context.Context, interval time.Duration, clock api.Clock) error {
+	publish := viper.IsSet("publishers") || viper.GetString("notify.twitter.consumer-key") != ""
+	if addr := viper.GetString("metrics.listen"); addr != "" {
+		go serveMetrics(addr)
+	}
+	logging.Default().Info("running", "interval", interval)
+	for {
+		start := clock.Now()
+		err := fetch(ctx, publish)
+		if ctx.Err() != nil {
+			logging.Default().Info("stopping")
+			return nil
+		}
+		if err != nil {
+			logging.Default().Error("run failed", "err", err)
+		}
+
+		// the interval is from start to start, a run longer than it is followed at once
+		select {
+		case <-ctx.Done():
+			logging.Default().Info("stopping")
+			return nil
+		case <-clock.After(start.Add(interval).Sub(clock.Now())):
+		}
+	}
+}
+
+// signalContext returns a context cancelled by the first SIGINT or SIGTERM, a second one
+// kills the process as usual
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lucbarr/earthpornbot/api"
+	"github.com/spf13/viper"
+)
+
+// inTempDir runs the test from a new directory and returns what restores the working directory
+func inTempDir(t *testing.T) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "earthpornbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// fakeReddit serves a token and a listing of the image at /a.png, which image serves, through
+// http.DefaultTransport until the returned function restores it
+func fakeReddit(t *testing.T, listings *int32, image http.HandlerFunc) func() {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v1/access_token":
+			fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+		case "/r/earthporn/hot.json":
+			if req.FormValue("after") != "" {
+				fmt.Fprint(w, `{"data":{"children":[]}}`)
+				return
+			}
+			atomic.AddInt32(listings, 1)
+			fmt.Fprintf(w, `{"data":{"children":[{"data":{"id":"a","name":"t3_a","url":"%s/a.png"}}]}}`, srv.URL)
+		case "/a.png":
+			image(w, req)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := http.DefaultTransport
+	http.DefaultTransport = redditTransport{target, transport}
+	viper.Reset()
+	viper.Set("credentials.auth-mode", api.AuthAppOnly)
+	viper.Set("credentials.app.client-id", "id")
+	viper.Set("subreddit.submissions.limit", 1)
+	viper.Set("subreddit.submissions.allowedExtensions", []string{"png"})
+	return func() {
+		http.DefaultTransport = transport
+		srv.Close()
+		viper.Reset()
+	}
+}
+
+// manualClock only moves when advanced, and sends every wait on it to waits
+type manualClock struct {
+	waits chan time.Duration
+
+	mu     sync.Mutex
+	now    time.Time
+	timers []manualTimer
+}
+
+type manualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newManualClock(now time.Time) *manualClock {
+	return &manualClock{waits: make(chan time.Duration, 16), now: now}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+	} else {
+		c.timers = append(c.timers, manualTimer{c.now.Add(d), ch})
+	}
+	c.mu.Unlock()
+	c.waits <- d
+	return ch
+}
+
+// Advance moves the clock by d, firing the timers due
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.ch <- c.now
+	}
+	c.timers = pending
+}
+
+func TestDaemonRunsOnScheduleUntilCancelled(t *testing.T) {
+	defer inTempDir(t)()
+	var listings int32
+	defer fakeReddit(t, &listings, http.NotFound)()
+
+	clock := newManualClock(time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- daemon(ctx, time.Hour, clock) }()
+	for run := int32(1); run <= 3; run++ {
+		select {
+		case wait := <-clock.waits:
+			if wait != time.Hour {
+				t.Errorf("run %d waits %v, want the interval", run, wait)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run %d did not wait for the next one", run)
+		}
+		// the failed downloads don't stop the schedule, and no run happens before its time
+		if n := atomic.LoadInt32(&listings); n != run {
+			t.Fatalf("ran %d times, want %d", n, run)
+		}
+		if run < 3 {
+			clock.Advance(time.Hour)
+		}
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("stopped with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not stop once cancelled")
+	}
+	if n := atomic.LoadInt32(&listings); n != 3 {
+		t.Errorf("ran %d times, want 3", n)
+	}
+}
+
+func TestSignalsStopTheDownloadsInFlight(t *testing.T) {
+	defer inTempDir(t)()
+	var listings int32
+	downloading := make(chan struct{})
+	defer fakeReddit(t, &listings, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		w.(http.Flusher).Flush()
+		close(downloading)
+		<-req.Context().Done()
+	})()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- daemon(ctx, time.Hour, api.RealClock{}) }()
+	<-downloading
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = self.Signal(syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("stopped with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not stop on SIGTERM")
+	}
+	parts, err := filepath.Glob("*.part")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) > 0 {
+		t.Errorf("left %v behind", parts)
+	}
+}
@@ -0,0 +1,57 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+
+	imageproc "github.com/lucbarr/earthpornbot/image"
+)
+
+// Config declares the filters, its zero value keeps everything
+type Config struct {
+	MinScore int `mapstructure:"minScore"`
+	// MinTitleResolution is a <width>x<height> the resolution declared in titles must reach
+	MinTitleResolution string `mapstructure:"minTitleResolution"`
+	ExcludeNSFW        bool   `mapstructure:"excludeNSFW"`
+	// TitleInclude are regexps the titles must all match, TitleExclude regexps they must not
+	TitleInclude []string `mapstructure:"titleInclude"`
+	TitleExclude []string `mapstructure:"titleExclude"`
+	// Flairs, when set, are the only flairs kept
+	Flairs []string `mapstructure:"flairs"`
+}
+
+// New builds the filter declared by cfg
+func New(cfg Config) (Filter, error) {
+	var filters []Filter
+	if cfg.MinScore != 0 {
+		filters = append(filters, MinScore(cfg.MinScore))
+	}
+	if cfg.MinTitleResolution != "" {
+		res, err := imageproc.ParseResolution(cfg.MinTitleResolution)
+		if err != nil {
+			return nil, fmt.Errorf("minTitleResolution: %v", err)
+		}
+		filters = append(filters, MinTitleResolution(res.Width, res.Height))
+	}
+	if cfg.ExcludeNSFW {
+		filters = append(filters, ExcludeNSFW())
+	}
+	for _, expr := range cfg.TitleInclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("titleInclude: %v", err)
+		}
+		filters = append(filters, TitleMatches(re))
+	}
+	for _, expr := range cfg.TitleExclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("titleExclude: %v", err)
+		}
+		filters = append(filters, TitleExcludes(re))
+	}
+	if len(cfg.Flairs) > 0 {
+		filters = append(filters, FlairIn(cfg.Flairs))
+	}
+	return All(filters...), nil
+}
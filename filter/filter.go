@@ -0,0 +1,126 @@
+// Package filter decides which submissions are worth downloading, from what the listing tells
+// about them.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Post is what the filters see of a submission
+type Post struct {
+	Title string
+	Score int
+	NSFW  bool
+	Flair string
+}
+
+// Filter returns why p is dropped, empty when it is kept
+type Filter func(p Post) string
+
+// All keeps the posts every filter keeps, the reason is that of the first filter dropping it
+func All(filters ...Filter) Filter {
+	return func(p Post) string {
+		for _, f := range filters {
+			if reason := f(p); reason != "" {
+				return reason
+			}
+		}
+		return ""
+	}
+}
+
+// MinScore drops the posts scored below min
+func MinScore(min int) Filter {
+	return func(p Post) string {
+		if p.Score < min {
+			return fmt.Sprintf("score %d is below %d", p.Score, min)
+		}
+		return ""
+	}
+}
+
+// ExcludeNSFW drops the posts marked NSFW
+func ExcludeNSFW() Filter {
+	return func(p Post) string {
+		if p.NSFW {
+			return "nsfw"
+		}
+		return ""
+	}
+}
+
+// TitleMatches drops the posts whose title does not match re
+func TitleMatches(re *regexp.Regexp) Filter {
+	return func(p Post) string {
+		if !re.MatchString(p.Title) {
+			return fmt.Sprintf("title does not match %s", re)
+		}
+		return ""
+	}
+}
+
+// TitleExcludes drops the posts whose title matches re
+func TitleExcludes(re *regexp.Regexp) Filter {
+	return func(p Post) string {
+		if re.MatchString(p.Title) {
+			return fmt.Sprintf("title matches %s", re)
+		}
+		return ""
+	}
+}
+
+// FlairIn drops the posts whose flair is not one of flairs, compared regardless of case
+func FlairIn(flairs []string) Filter {
+	allowed := make(map[string]bool, len(flairs))
+	for _, f := range flairs {
+		allowed[strings.ToLower(strings.TrimSpace(f))] = true
+	}
+	return func(p Post) string {
+		if !allowed[strings.ToLower(strings.TrimSpace(p.Flair))] {
+			return fmt.Sprintf("flair %q is not allowed", p.Flair)
+		}
+		return ""
+	}
+}
+
+// titleResolution matches the resolution r/earthporn asks for in titles, e.g. [6000x4000]
+var titleResolution = regexp.MustCompile(`[\[(]\s*(\d{2,5})\s*[xX×*]\s*(\d{2,5})\s*[\])]`)
+
+// TitleResolution returns the resolution declared in title, zeros when there is none
+func TitleResolution(title string) (width, height int) {
+	m := titleResolution.FindStringSubmatch(title)
+	if m == nil {
+		return 0, 0
+	}
+	width, _ = strconv.Atoi(m[1])
+	height, _ = strconv.Atoi(m[2])
+	return width, height
+}
+
+// MinTitleResolution drops the posts whose title declares a resolution smaller than
+// width x height, in either orientation. Posts declaring none are kept, the downloaded
+// image is checked anyway.
+func MinTitleResolution(width, height int) Filter {
+	minLong, minShort := longShort(width, height)
+	return func(p Post) string {
+		w, h := TitleResolution(p.Title)
+		if w == 0 || h == 0 {
+			return ""
+		}
+		long, short := longShort(w, h)
+		if long < minLong || short < minShort {
+			return fmt.Sprintf("title resolution %dx%d is below %dx%d", w, h, width, height)
+		}
+		return ""
+	}
+}
+
+func longShort(a, b int) (int, int) {
+	if a < b {
+		return b, a
+	}
+	return a, b
+}
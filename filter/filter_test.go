@@ -0,0 +1,101 @@
+package filter
+
+import "testing"
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		post Post
+		kept bool
+	}{
+		{"score above the minimum", Config{MinScore: 10}, Post{Score: 10}, true},
+		{"score below the minimum", Config{MinScore: 10}, Post{Score: 9}, false},
+
+		{"landscape resolution", Config{MinTitleResolution: "3840x2160"}, Post{Title: "Alps [4000x3000]"}, true},
+		{"portrait resolution", Config{MinTitleResolution: "3840x2160"}, Post{Title: "Falls (2160 x 3840)"}, true},
+		{"portrait minimum", Config{MinTitleResolution: "2160x3840"}, Post{Title: "Alps [4000x3000]"}, true},
+		{"small resolution", Config{MinTitleResolution: "3840x2160"}, Post{Title: "Alps [1920x1080]"}, false},
+		{"small portrait resolution", Config{MinTitleResolution: "3840x2160"}, Post{Title: "Falls [1080×1920]"}, false},
+		{"no resolution", Config{MinTitleResolution: "3840x2160"}, Post{Title: "Alps"}, true},
+
+		{"nsfw excluded", Config{ExcludeNSFW: true}, Post{NSFW: true}, false},
+		{"sfw kept", Config{ExcludeNSFW: true}, Post{}, true},
+		{"nsfw allowed", Config{}, Post{NSFW: true}, true},
+
+		{"title included", Config{TitleInclude: []string{`(?i)mountain`}}, Post{Title: "Mountain lake"}, true},
+		{"title not included", Config{TitleInclude: []string{`(?i)mountain`}}, Post{Title: "Desert"}, false},
+		{"every include must match", Config{TitleInclude: []string{`lake`, `dawn`}}, Post{Title: "lake at dusk"}, false},
+		{"title excluded", Config{TitleExclude: []string{`(?i)\bOC\b`}}, Post{Title: "Fjord [OC]"}, false},
+		{"title not excluded", Config{TitleExclude: []string{`(?i)\bOC\b`}}, Post{Title: "Fjord"}, true},
+
+		{"flair of another case", Config{Flairs: []string{"Landscape "}}, Post{Flair: "landscape"}, true},
+		{"flair not allowed", Config{Flairs: []string{"Landscape"}}, Post{Flair: "Meta"}, false},
+		{"no flair", Config{Flairs: []string{"Landscape"}}, Post{}, false},
+
+		{"nothing configured", Config{}, Post{Title: "anything", Score: -5}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := New(test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reason := f(test.post)
+			if (reason == "") != test.kept {
+				t.Errorf("kept %v (%q), want %v", reason == "", reason, test.kept)
+			}
+		})
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{MinTitleResolution: "4k"},
+		{TitleInclude: []string{"("}},
+		{TitleExclude: []string{"["}},
+	} {
+		_, err := New(cfg)
+		if err == nil {
+			t.Errorf("accepted %+v", cfg)
+		}
+	}
+}
+
+func TestTitleResolution(t *testing.T) {
+	tests := []struct {
+		title         string
+		width, height int
+	}{
+		{"Lake Bled [6000x4000] [OC]", 6000, 4000},
+		{"Dunes (3000 X 2000)", 3000, 2000},
+		{"Glacier [4032×3024]", 4032, 3024},
+		{"Glacier [4032*3024]", 4032, 3024},
+		{"Forest 1920x1080", 0, 0},
+		{"Forest", 0, 0},
+	}
+	for _, test := range tests {
+		w, h := TitleResolution(test.title)
+		if w != test.width || h != test.height {
+			t.Errorf("TitleResolution(%q) = %dx%d, want %dx%d", test.title, w, h, test.width, test.height)
+		}
+	}
+}
+
+func TestAllGivesTheReasonOfTheFirstDroppingFilter(t *testing.T) {
+	f := All(MinScore(10), ExcludeNSFW(), All(), FlairIn([]string{"OC"}))
+	tests := []struct {
+		post   Post
+		reason string
+	}{
+		{Post{Score: 10, Flair: "OC"}, ""},
+		{Post{Score: 1, NSFW: true}, MinScore(10)(Post{Score: 1})},
+		{Post{Score: 10, NSFW: true}, ExcludeNSFW()(Post{NSFW: true})},
+		{Post{Score: 10}, FlairIn([]string{"OC"})(Post{})},
+	}
+	for _, test := range tests {
+		if reason := f(test.post); reason != test.reason {
+			t.Errorf("%+v dropped for %q, want %q", test.post, reason, test.reason)
+		}
+	}
+}
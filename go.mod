@@ -3,8 +3,6 @@ module github.com/lucbarr/earthpornbot
 go 1.13
 
 require (
-	github.com/beefsack/go-rate v0.0.0-20180408011153-efa7637bb9b6 // indirect
-	github.com/google/go-querystring v1.0.0 // indirect
-	github.com/jzelinskie/geddit v0.0.0-20190913104144-95ef6806b073
 	github.com/spf13/viper v1.6.1
+	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5
 )
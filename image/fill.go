@@ -0,0 +1,58 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Resolution is a target size in pixels
+type Resolution struct {
+	Width  int
+	Height int
+}
+
+// ParseResolution reads a resolution written <width>x<height>, e.g. 1920x1080
+func ParseResolution(s string) (Resolution, error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) != 2 {
+		return Resolution{}, fmt.Errorf("invalid resolution %q, expected <width>x<height>", s)
+	}
+	w, errW := strconv.Atoi(strings.TrimSpace(parts[0]))
+	h, errH := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return Resolution{}, fmt.Errorf("invalid resolution %q, expected <width>x<height>", s)
+	}
+	return Resolution{Width: w, Height: h}, nil
+}
+
+// Fill crops the center of the image to the aspect ratio of target, then scales it down to
+// target. Smaller images are cropped but never scaled up.
+func Fill(target Resolution) Step {
+	return func(img image.Image) image.Image {
+		b := img.Bounds()
+
+		crop := b
+		if b.Dx()*target.Height > b.Dy()*target.Width {
+			// wider than the target, the sides go
+			w := b.Dy() * target.Width / target.Height
+			crop.Min.X += (b.Dx() - w) / 2
+			crop.Max.X = crop.Min.X + w
+		} else {
+			h := b.Dx() * target.Height / target.Width
+			crop.Min.Y += (b.Dy() - h) / 2
+			crop.Max.Y = crop.Min.Y + h
+		}
+
+		size := image.Rect(0, 0, target.Width, target.Height)
+		if crop.Dx() < target.Width {
+			size = image.Rect(0, 0, crop.Dx(), crop.Dy())
+		}
+		dst := image.NewRGBA(size)
+		draw.CatmullRom.Scale(dst, size, img, crop, draw.Src, nil)
+		return dst
+	}
+}
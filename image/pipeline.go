@@ -0,0 +1,157 @@
+// Package image transforms the downloaded images before they are classified: cropping and
+// resizing them to wallpaper resolutions and re-encoding them without their metadata.
+package image
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// Step is a transform of the pipeline
+type Step func(img image.Image) image.Image
+
+// Pipeline decodes an image, runs its steps in order and encodes the result in the same format.
+// Encoding drops the EXIF, XMP and ICC metadata of the original.
+type Pipeline struct {
+	Steps []Step
+	// Targets are the resolutions of the variants, the original is kept as it is
+	Targets []Resolution
+	// Quality is the JPEG quality, 1 to 100
+	Quality int
+	// StripMetadata re-encodes the images even without steps
+	StripMetadata bool
+}
+
+// Rewrites reports whether ProcessFile changes the images
+func (p *Pipeline) Rewrites() bool {
+	return len(p.Steps) > 0 || p.StripMetadata
+}
+
+// ProcessFile replaces the JPEG or PNG image at path by its processed version and returns its
+// new dimensions
+func (p *Pipeline) ProcessFile(path string) (width, height int, err error) {
+	img, format, err := decodeFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, step := range p.Steps {
+		img = step(img)
+	}
+
+	err = p.encodeFile(path, img, format)
+	if err != nil {
+		return 0, 0, err
+	}
+	b := img.Bounds()
+	return b.Dx(), b.Dy(), nil
+}
+
+// WriteVariants writes into dir the JPEG or PNG image at path filled to each of the targets, as
+// <width>x<height>.<ext>, and returns their paths
+func (p *Pipeline) WriteVariants(path, dir string) ([]string, error) {
+	if len(p.Targets) == 0 {
+		return nil, nil
+	}
+	img, format, err := decodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, target := range p.Targets {
+		variant := filepath.Join(dir, fmt.Sprintf("%dx%d%s", target.Width, target.Height, filepath.Ext(path)))
+		err = p.encodeFile(variant, Fill(target)(img), format)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, variant)
+	}
+	return paths, nil
+}
+
+// decodeFile decodes the image at path, and returns it with its format
+func decodeFile(path string) (image.Image, string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer src.Close()
+	return image.Decode(src)
+}
+
+// encodeFile writes img to path in format, replacing what was there
+func (p *Pipeline) encodeFile(path string, img image.Image, format string) error {
+	// write aside and rename so a failure leaves the original in place
+	tmp := path + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(dst, img, &jpeg.Options{Quality: p.Quality})
+	case "png":
+		err = png.Encode(dst, img)
+	default:
+		err = fmt.Errorf("cannot encode %s images", format)
+	}
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Config declares the pipeline, its zero value processes nothing
+type Config struct {
+	// Targets are the <width>x<height> resolutions of the variants written of each image,
+	// cropped and resized to them
+	Targets []string `mapstructure:"targets"`
+	// Quality is the JPEG quality of the re-encoded images, 90 when unset
+	Quality int `mapstructure:"quality"`
+	// StripMetadata re-encodes the images even without targets, so they lose their metadata
+	StripMetadata bool `mapstructure:"stripMetadata"`
+}
+
+// defaultQuality is the JPEG quality when Config.Quality is not set
+const defaultQuality = 90
+
+// New builds the pipeline declared by cfg, nil when it has nothing to do
+func New(cfg Config) (*Pipeline, error) {
+	quality := cfg.Quality
+	if quality == 0 {
+		quality = defaultQuality
+	}
+	if quality < 1 || quality > 100 {
+		return nil, errors.New("quality must be between 1 and 100")
+	}
+
+	var targets []Resolution
+	for _, s := range cfg.Targets {
+		res, err := ParseResolution(s)
+		if err != nil {
+			return nil, fmt.Errorf("targets: %v", err)
+		}
+		targets = append(targets, res)
+	}
+
+	if len(targets) == 0 && !cfg.StripMetadata {
+		return nil, nil
+	}
+	return &Pipeline{Targets: targets, Quality: quality, StripMetadata: cfg.StripMetadata}, nil
+}
@@ -0,0 +1,233 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Resolution
+		ok   bool
+	}{
+		{"1920x1080", Resolution{1920, 1080}, true},
+		{" 1080 X 1920 ", Resolution{1080, 1920}, true},
+		{"1920", Resolution{}, false},
+		{"1920x", Resolution{}, false},
+		{"0x1080", Resolution{}, false},
+		{"-1x10", Resolution{}, false},
+		{"1x2x3", Resolution{}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseResolution(test.in)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("ParseResolution(%q) = %v, %v", test.in, got, err)
+		}
+	}
+}
+
+func TestWriteVariantsKeepsTheOriginal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(file, image.NewRGBA(image.Rect(0, 0, 400, 300)))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(Config{Targets: []string{"160x90", "90x160"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Rewrites() {
+		t.Error("the targets alone rewrite the original")
+	}
+	variants, err := p.WriteVariants(path, filepath.Join(dir, "variants"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 2 {
+		t.Fatalf("wrote %v, want a variant per target", variants)
+	}
+	for i, want := range []image.Point{{160, 90}, {90, 160}} {
+		if got := size(t, variants[i]); got != want {
+			t.Errorf("%s is %v, want %v", variants[i], got, want)
+		}
+	}
+	if got := size(t, path); got != (image.Point{400, 300}) {
+		t.Errorf("the original became %v", got)
+	}
+}
+
+// size returns the dimensions of the image at path
+func size(t *testing.T, path string) image.Point {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image.Point{cfg.Width, cfg.Height}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		none    bool
+		quality int
+		ok      bool
+	}{
+		{"nothing to do", Config{}, true, 0, true},
+		{"default quality", Config{StripMetadata: true}, false, defaultQuality, true},
+		{"quality", Config{Targets: []string{"1920x1080"}, Quality: 75}, false, 75, true},
+		{"quality too high", Config{StripMetadata: true, Quality: 101}, false, 0, false},
+		{"negative quality", Config{StripMetadata: true, Quality: -1}, false, 0, false},
+		{"invalid target", Config{Targets: []string{"1080p"}}, false, 0, false},
+	}
+	for _, test := range tests {
+		p, err := New(test.cfg)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.name, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if (p == nil) != test.none {
+			t.Errorf("%s: got the pipeline %+v", test.name, p)
+			continue
+		}
+		if p != nil && p.Quality != test.quality {
+			t.Errorf("%s: quality is %d, want %d", test.name, p.Quality, test.quality)
+		}
+	}
+}
+
+func TestFill(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    image.Rectangle
+		target Resolution
+		want   image.Point
+	}{
+		{"scaled down", image.Rect(0, 0, 400, 300), Resolution{40, 30}, image.Point{40, 30}},
+		{"sides cropped", image.Rect(0, 0, 400, 100), Resolution{20, 10}, image.Point{20, 10}},
+		{"top and bottom cropped", image.Rect(0, 0, 100, 400), Resolution{20, 10}, image.Point{20, 10}},
+		{"never scaled up", image.Rect(0, 0, 100, 300), Resolution{1920, 1080}, image.Point{100, 56}},
+	}
+	for _, test := range tests {
+		got := Fill(test.target)(image.NewRGBA(test.src)).Bounds().Size()
+		if got != test.want {
+			t.Errorf("%s: filled %v to %v, want %v", test.name, test.src.Size(), got, test.want)
+		}
+	}
+}
+
+func TestFillKeepsTheCenter(t *testing.T) {
+	// a wide image with red sides and a blue center
+	src := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for x := 0; x < 300; x++ {
+		c := color.RGBA{255, 0, 0, 255}
+		if x >= 100 && x < 200 {
+			c = color.RGBA{0, 0, 255, 255}
+		}
+		for y := 0; y < 100; y++ {
+			src.Set(x, y, c)
+		}
+	}
+	dst := Fill(Resolution{10, 10})(src)
+	for _, p := range []image.Point{{0, 0}, {9, 9}, {5, 5}} {
+		if r, _, b, _ := dst.At(p.X, p.Y).RGBA(); r != 0 || b == 0 {
+			t.Errorf("kept %v at %v, want the blue center", dst.At(p.X, p.Y), p)
+		}
+	}
+}
+
+func TestProcessFileStripsTheMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 300)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an APP1 segment right after the start of image, as cameras write their EXIF
+	exif := append([]byte("Exif\x00\x00"), bytes.Repeat([]byte{0}, 32)...)
+	segment := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	path := filepath.Join(dir, "a.jpg")
+	err = ioutil.WriteFile(path, append(append([]byte{0xff, 0xd8}, segment...), buf.Bytes()[2:]...), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Pipeline{Steps: []Step{Fill(Resolution{160, 90})}, Quality: 80}
+	width, height, err := p.ProcessFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 160 || height != 90 || size(t, path) != (image.Point{160, 90}) {
+		t.Errorf("processed into %dx%d, %v, want 160x90", width, height, size(t, path))
+	}
+	processed, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(processed, []byte("Exif")) {
+		t.Error("the EXIF is still there")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("left the temporary file: %v", err)
+	}
+}
+
+func TestProcessFileLeavesUnsupportedFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.gif")
+	var buf bytes.Buffer
+	err = gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = (&Pipeline{StripMetadata: true}).ProcessFile(path)
+	if err == nil {
+		t.Error("processed a GIF")
+	}
+	kept, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(kept, buf.Bytes()) {
+		t.Errorf("the original changed: %v", err)
+	}
+}
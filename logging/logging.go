@@ -0,0 +1,194 @@
+// Package logging writes leveled log lines made of a message and key value pairs, as logfmt
+// text or as JSON objects.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a line, those below the level of a Logger are dropped
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn or error, regardless of case
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range levelNames {
+		if name == s {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown level %q, expected debug, info, warn or error", s)
+}
+
+const (
+	// Text writes logfmt lines, e.g. time=... level=info msg="saved image" path=hori/a.jpg
+	Text = "text"
+	// JSON writes a JSON object per line
+	JSON = "json"
+)
+
+// Logger writes the lines of at least its level to its output, it is safe for concurrent use.
+// A nil Logger drops every line.
+type Logger struct {
+	out   io.Writer
+	level Level
+	json  bool
+
+	mu sync.Mutex
+}
+
+// New creates a Logger writing the lines of at least level to out in format, Text or JSON
+func New(out io.Writer, level Level, format string) (*Logger, error) {
+	switch format {
+	case "", Text:
+		return &Logger{out: out, level: level}, nil
+	case JSON:
+		return &Logger{out: out, level: level, json: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected text or json", format)
+	}
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger = &Logger{out: os.Stderr, level: Info}
+)
+
+// Default returns the Logger of the program, info text lines to standard error unless replaced
+func Default() *Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the Logger returned by Default
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// Debug logs msg and keyvals, alternating keys and values, at the debug level
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(Debug, msg, keyvals)
+}
+
+// Info logs msg and keyvals, alternating keys and values, at the info level
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(Info, msg, keyvals)
+}
+
+// Warn logs msg and keyvals, alternating keys and values, at the warn level
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(Warn, msg, keyvals)
+}
+
+// Error logs msg and keyvals, alternating keys and values, at the error level
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(Error, msg, keyvals)
+}
+
+// Enabled reports whether the lines of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	// an odd value out is kept rather than lost
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals[:len(keyvals)-1:len(keyvals)-1], "extra", keyvals[len(keyvals)-1])
+	}
+
+	fields := append([]interface{}{
+		"time", time.Now().UTC().Format(time.RFC3339),
+		"level", level.String(),
+		"msg", msg,
+	}, keyvals...)
+	var line []byte
+	if l.json {
+		line = jsonLine(fields)
+	} else {
+		line = textLine(fields)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+// value is how v is written, errors and stringers by their text
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case time.Duration:
+		return v.String()
+	}
+	return v
+}
+
+func textLine(fields []interface{}) []byte {
+	var b strings.Builder
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteByte('=')
+		s := fmt.Sprint(value(fields[i+1]))
+		if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(s)
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+func jsonLine(fields []interface{}) []byte {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		b.Write(key)
+		b.WriteByte(':')
+		v, err := json.Marshal(value(fields[i+1]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		b.Write(v)
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
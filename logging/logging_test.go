@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in    string
+		level Level
+		ok    bool
+	}{
+		{"debug", Debug, true},
+		{"info", Info, true},
+		{" WARN ", Warn, true},
+		{"Error", Error, true},
+		{"verbose", Info, false},
+		{"", Info, false},
+	}
+	for _, tt := range tests {
+		level, err := ParseLevel(tt.in)
+		if level != tt.level || (err == nil) != tt.ok {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v and ok %v", tt.in, level, err, tt.level, tt.ok)
+		}
+	}
+}
+
+func TestNewRejectsUnknownFormats(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, Info, "xml")
+	if err == nil {
+		t.Error("created a logger writing xml")
+	}
+}
+
+// withoutTime drops the time field leading a text line
+func withoutTime(line string) string {
+	i := strings.IndexByte(line, ' ')
+	return line[i+1:]
+}
+
+func TestTextLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyvals []interface{}
+		line    string
+	}{
+		{"plain", []interface{}{"path", "hori/a.jpg"}, `level=info msg="saved image" path=hori/a.jpg`},
+		{"quoted", []interface{}{"title", `a "b" c`, "empty", ""}, `level=info msg="saved image" title="a \"b\" c" empty=""`},
+		{"error and duration", []interface{}{"err", errors.New("boom"), "took", 2 * time.Second},
+			`level=info msg="saved image" err=boom took=2s`},
+		{"odd value", []interface{}{"path", "a.jpg", "lost"}, `level=info msg="saved image" path=a.jpg extra=lost`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			l, err := New(&out, Info, Text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.Info("saved image", tt.keyvals...)
+			line := out.String()
+			if !strings.HasPrefix(line, "time=") || !strings.HasSuffix(line, "\n") {
+				t.Fatalf("got %q, want a line starting with the time", line)
+			}
+			if got := withoutTime(strings.TrimSuffix(line, "\n")); got != tt.line {
+				t.Errorf("got %s, want %s", got, tt.line)
+			}
+		})
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	var out bytes.Buffer
+	l, err := New(&out, Info, JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Warn("could not save", "err", errors.New("boom"), "width", 1920)
+
+	var line map[string]interface{}
+	err = json.Unmarshal(out.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("%q: %v", out.String(), err)
+	}
+	want := map[string]interface{}{"level": "warn", "msg": "could not save", "err": "boom", "width": 1920.0}
+	for key, v := range want {
+		if line[key] != v {
+			t.Errorf("%s is %v, want %v", key, line[key], v)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, line["time"].(string)); err != nil {
+		t.Errorf("time: %v", err)
+	}
+}
+
+func TestLinesBelowTheLevelAreDropped(t *testing.T) {
+	var out bytes.Buffer
+	l, err := New(&out, Warn, Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("a")
+	l.Info("b")
+	l.Warn("c")
+	l.Error("d")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "msg=c") || !strings.Contains(lines[1], "msg=d") {
+		t.Errorf("got %q, want the warn and error lines", lines)
+	}
+
+	var nop *Logger
+	nop.Error("dropped")
+	if nop.Enabled(Error) {
+		t.Error("a nil logger is enabled")
+	}
+}
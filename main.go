@@ -1,30 +1,361 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/lucbarr/earthpornbot/api"
+	"github.com/lucbarr/earthpornbot/logging"
 	"github.com/spf13/viper"
 )
 
+// exit codes, so scripts can tell failures apart
+const (
+	exitOK       = 0
+	exitFailure  = 1
+	exitConfig   = 2
+	exitAuth     = 3
+	exitDownload = 4
+	exitPost     = 5
+)
+
+// exitError ties an error to the exit code it is reported with
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// exitCode maps an error returned by run to the process exit code
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailure
+}
+
 func main() {
-	err := setupConfig()
+	err := run()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
 	}
+	os.Exit(exitCode(err))
+}
+
+// commands are the subcommands by name, with what they do. fetch runs when none is given.
+var commands = map[string]string{
+	"fetch":    "download the new submissions, every schedule.interval with -daemon",
+	"post":     "post the newest downloaded image not posted yet to the publishers, or tweet it",
+	"prune":    "delete the images older than -older-than, subreddit.output.retentionDays by default, and those over subreddit.output.retention",
+	"serve":    "serve the downloaded images over HTTP on serve.listen",
+	"status":   "show the store and index counts, the images left to post and the last download",
+	"validate": "check the config without authenticating or downloading",
+}
+
+func run() error {
+	args := os.Args[1:]
+	command := "fetch"
+	if len(args) > 0 && commands[args[0]] != "" {
+		command, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("earthpornbot "+command, flag.ContinueOnError)
+	flags.Usage = func() { usage(flags) }
+	olderThan := ""
+	if command == "prune" {
+		flags.StringVar(&olderThan, "older-than", "", "age of the images deleted, e.g. 36h, 30d or 2w")
+	}
+	err := setupConfig(flags, args, os.Stdin)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return &exitError{exitConfig, fmt.Errorf("could not read the config: %v", err)}
+	}
+	err = setupLogging()
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+
+	switch command {
+	case "validate":
+		err = api.LoadConfig().Validate()
+		if err != nil {
+			return &exitError{exitConfig, fmt.Errorf("invalid config: %v", err)}
+		}
+		fmt.Println("config ok")
+		return nil
+	case "post":
+		return post(context.Background(), api.NewReddit())
+	case "prune":
+		return prune(olderThan)
+	case "status":
+		return status()
+	case "serve":
+		ctx, cancel := signalContext()
+		defer cancel()
+		return serve(ctx)
+	}
+
+	err = checkRequired()
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+
+	// the first SIGINT or SIGTERM cancels the downloads, which remove their partial files
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	interval := viper.GetDuration("schedule.interval")
+	if viper.GetBool("schedule.daemon") || interval > 0 {
+		if interval <= 0 {
+			interval = defaultInterval
+		}
+		return daemon(ctx, interval, api.RealClock{})
+	}
+	return fetch(ctx, false)
+}
+
+// fetch authenticates and downloads once, then posts the next image when publish is set
+func fetch(ctx context.Context, publish bool) error {
 	reddit := api.NewReddit()
-	err = reddit.Authenticate()
+	err := reddit.AuthenticateContext(ctx)
+	if err != nil {
+		return &exitError{exitAuth, fmt.Errorf("could not authenticate: %v", err)}
+	}
+
+	err = reddit.FetchSubmissionsContext(ctx)
 	if err != nil {
-		panic(err)
+		return &exitError{exitDownload, err}
 	}
 
-	err = reddit.FetchSubmissions()
-	fmt.Println(err)
+	if publish {
+		return post(ctx, reddit)
+	}
+	return nil
 }
 
-func setupConfig() error {
-	viper.SetConfigName("default")
-	viper.AddConfigPath(".")
+// post publishes the next image to the publishers, or tweets it when there is none
+func post(ctx context.Context, reddit *api.Reddit) error {
+	var err error
+	if len(reddit.Publishers) > 0 {
+		err = reddit.Publish(ctx)
+	} else {
+		err = reddit.PostNext(ctx)
+	}
+	if err != nil {
+		return &exitError{exitPost, err}
+	}
+	return nil
+}
+
+// prune deletes the images older than olderThan, or than subreddit.output.retentionDays when empty,
+// then those over the retention limits
+func prune(olderThan string) error {
+	age, err := api.ParseAge(olderThan)
+	if err != nil {
+		return &exitError{exitConfig, fmt.Errorf("invalid -older-than: %v", err)}
+	}
+	if age == 0 {
+		age = time.Duration(viper.GetInt("subreddit.output.retentionDays")) * 24 * time.Hour
+	}
+	retention := viper.IsSet("subreddit.output.retention")
+	if age <= 0 && !retention {
+		return &exitError{exitConfig, errors.New("set -older-than, subreddit.output.retentionDays or subreddit.output.retention")}
+	}
+
+	reddit := api.NewReddit()
+	if age > 0 {
+		purged, err := reddit.Prune(age)
+		fmt.Printf("deleted %d files\n", purged)
+		if err != nil {
+			return err
+		}
+	}
+	if retention {
+		deleted, err := reddit.ApplyRetention()
+		fmt.Printf("deleted %d images over the retention limits\n", deleted)
+		return err
+	}
+	return nil
+}
+
+// status prints what the previous runs left behind
+func status() error {
+	s, err := api.NewReddit().Status()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("store: %d submissions, %d posted\n", s.Records, s.Posted)
+	fmt.Printf("index: %d images, %d left to post\n", s.Indexed, s.Pending)
+	names := make([]string, 0, len(s.PendingTo))
+	for name := range s.PendingTo {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %d left to post\n", name, s.PendingTo[name])
+	}
+	if s.LastDownload.IsZero() {
+		fmt.Println("last download: never")
+	} else {
+		fmt.Printf("last download: %s\n", s.LastDownload.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// usage lists the commands above the flags of the current one
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "usage: earthpornbot [command] [flags]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-9s %s\n", name, commands[name])
+	}
+	fmt.Fprintf(out, "\nflags of %s:\n", flags.Name())
+	flags.PrintDefaults()
+}
+
+// setupLogging replaces the default logger by one of logging.level and logging.format
+func setupLogging() error {
+	level, err := logging.ParseLevel(viper.GetString("logging.level"))
+	if err != nil {
+		return fmt.Errorf("invalid logging.level: %v", err)
+	}
+	logger, err := logging.New(os.Stderr, level, viper.GetString("logging.format"))
+	if err != nil {
+		return fmt.Errorf("invalid logging.format: %v", err)
+	}
+	logging.SetDefault(logger)
+	return nil
+}
+
+// envPrefix prefixes the environment variables overriding the config, e.g.
+// EARTHPORNBOT_CREDENTIALS_USER for credentials.user
+const envPrefix = "EARTHPORNBOT"
+
+// flagKeys maps the command line flags to the config keys they set
+var flagKeys = map[string]string{
+	"user":          "credentials.user",
+	"password":      "credentials.password",
+	"client-id":     "credentials.app.client-id",
+	"client-secret": "credentials.app.client-secret",
+	"auth-mode":     "credentials.auth-mode",
+	"subreddit":     "subreddit.name",
+	"limit":         "subreddit.submissions.limit",
+	"sort":          "subreddit.submissions.sort",
+	"time-range":    "subreddit.submissions.timeRange",
+	"index":         "subreddit.output.index",
+	"store":         "subreddit.output.store",
+	"listen":        "serve.listen",
+	"log-level":     "logging.level",
+}
+
+// boolFlagKeys maps the on/off command line flags to the config keys they set
+var boolFlagKeys = map[string]string{
+	"daemon":  "schedule.daemon",
+	"dry-run": "runtime.dryRun",
+}
+
+// requiredKeys must be set by the config file, the environment or the flags, by auth mode
+var requiredKeys = map[string][]string{
+	api.AuthScript: {
+		"credentials.user",
+		"credentials.password",
+		"credentials.app.client-id",
+		"credentials.app.client-secret",
+	},
+	api.AuthAppOnly:      {"credentials.app.client-id"},
+	api.AuthRefreshToken: {"credentials.app.client-id", "credentials.refresh-token"},
+}
+
+// setupConfig layers the flags, parsed from args by flags, over the environment over the config
+// file. The file, default.yaml unless -config names another one, is optional so the bot can run
+// from flags and environment alone, -config - reads it from stdin
+func setupConfig(flags *flag.FlagSet, args []string, stdin io.Reader) error {
+	viper.SetDefault("subreddit.name", "earthporn")
+	viper.SetDefault("subreddit.submissions.limit", 25)
+	viper.SetDefault("subreddit.submissions.allowedExtensions", []string{"jpg", "png"})
+	viper.SetDefault("serve.listen", ":8080")
+	viper.SetDefault("logging.level", "info")
+
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+
+	values := make(map[string]*string, len(flagKeys))
+	for name, key := range flagKeys {
+		values[name] = flags.String(name, "", "overrides "+key)
+	}
+	bools := make(map[string]*bool, len(boolFlagKeys))
+	for name, key := range boolFlagKeys {
+		bools[name] = flags.Bool(name, false, "overrides "+key)
+	}
+	configFile := flags.String("config", "", "config file, - reads it from stdin")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if key, ok := flagKeys[f.Name]; ok {
+			viper.Set(key, *values[f.Name])
+		}
+		if key, ok := boolFlagKeys[f.Name]; ok {
+			viper.Set(key, *bools[f.Name])
+		}
+	})
+
 	viper.SetConfigType("yaml")
-	return viper.ReadInConfig()
+	switch *configFile {
+	case "-":
+		return viper.ReadConfig(stdin)
+	case "":
+		viper.SetConfigName("default")
+		viper.AddConfigPath(".")
+	default:
+		viper.SetConfigFile(*configFile)
+		return viper.ReadInConfig()
+	}
+	err = viper.ReadInConfig()
+	if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// checkRequired reports the first required key left unset
+func checkRequired() error {
+	mode := viper.GetString("credentials.auth-mode")
+	if mode == "" {
+		mode = api.AuthScript
+	}
+	keys, ok := requiredKeys[mode]
+	if !ok {
+		return fmt.Errorf("credentials.auth-mode must be script, app-only or refresh-token, got %q", mode)
+	}
+	for _, key := range keys {
+		if viper.GetString(key) == "" {
+			env := envPrefix + "_" + strings.NewReplacer(".", "_", "-", "_").Replace(strings.ToUpper(key))
+			return fmt.Errorf("%s is not set, set it in default.yaml or %s", key, env)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lucbarr/earthpornbot/api"
+	"github.com/spf13/viper"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, exitOK},
+		{errors.New("failed"), exitFailure},
+		{&exitError{exitAuth, errors.New("denied")}, exitAuth},
+		{fmt.Errorf("run: %w", &exitError{exitPost, errors.New("refused")}), exitPost},
+	}
+	for _, test := range tests {
+		if code := exitCode(test.err); code != test.code {
+			t.Errorf("exitCode(%v) = %d, want %d", test.err, code, test.code)
+		}
+	}
+}
+
+// redditTransport sends the requests to reddit to the fake at target
+type redditTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t redditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Host, "reddit.com") {
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	}
+	return t.base.RoundTrip(req)
+}
+
+func TestCheckRequiredByAuthMode(t *testing.T) {
+	tests := []struct {
+		values map[string]string
+		ok     bool
+	}{
+		{map[string]string{"credentials.app.client-id": "id"}, false},
+		{map[string]string{
+			"credentials.user": "user", "credentials.password": "password",
+			"credentials.app.client-id": "id", "credentials.app.client-secret": "secret",
+		}, true},
+		{map[string]string{"credentials.auth-mode": "app-only", "credentials.app.client-id": "id"}, true},
+		{map[string]string{"credentials.auth-mode": "app-only"}, false},
+		{map[string]string{"credentials.auth-mode": "refresh-token", "credentials.app.client-id": "id"}, false},
+		{map[string]string{
+			"credentials.auth-mode": "refresh-token", "credentials.app.client-id": "id", "credentials.refresh-token": "token",
+		}, true},
+		{map[string]string{"credentials.auth-mode": "device", "credentials.app.client-id": "id"}, false},
+	}
+	for _, test := range tests {
+		viper.Reset()
+		for key, value := range test.values {
+			viper.Set(key, value)
+		}
+		err := checkRequired()
+		if (err == nil) != test.ok {
+			t.Errorf("%v: got %v", test.values, err)
+		}
+	}
+	viper.Reset()
+}
+
+func TestRunWithoutAConfigFile(t *testing.T) {
+	var img bytes.Buffer
+	err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 30, 20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v1/access_token":
+			user, _, _ := req.BasicAuth()
+			if user != "id" || req.FormValue("username") != "user" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+		case "/r/pics/hot.json":
+			if req.Header.Get("Authorization") != "bearer token" || req.FormValue("after") != "" {
+				fmt.Fprint(w, `{"data":{"children":[]}}`)
+				return
+			}
+			fmt.Fprintf(w, `{"data":{"children":[{"data":{"id":"a","name":"t3_a","url":"%s/a.png","subreddit":"pics"}}]}}`,
+				srv.URL)
+		case "/a.png":
+			w.Write(img.Bytes())
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := http.DefaultTransport
+	http.DefaultTransport = redditTransport{target, transport}
+	defer func() { http.DefaultTransport = transport }()
+
+	dir, err := ioutil.TempDir("", "earthpornbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// the secrets come from the environment, the rest from the flags
+	for key, value := range map[string]string{
+		"EARTHPORNBOT_CREDENTIALS_PASSWORD":          "password",
+		"EARTHPORNBOT_CREDENTIALS_APP_CLIENT_SECRET": "secret",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"earthpornbot", "fetch", "-user", "user", "-client-id", "id", "-subreddit", "pics", "-limit", "1"}
+	viper.Reset()
+	defer viper.Reset()
+
+	err = run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join("hori", "a.png"))
+	if err != nil {
+		t.Errorf("the image was not saved: %v", err)
+	}
+}
+
+// offlineTransport fails the test on any request
+type offlineTransport struct{ t *testing.T }
+
+func (o offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	o.t.Errorf("requested %s while validating", req.URL)
+	return nil, errors.New("offline")
+}
+
+func TestValidateCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthpornbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	transport := http.DefaultTransport
+	http.DefaultTransport = offlineTransport{t}
+	defer func() { http.DefaultTransport = transport }()
+
+	credentials := "credentials:\n  user: user\n  password: password\n  app:\n    client-id: id\n    client-secret: secret\n"
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"good", credentials + "subreddit:\n  submissions:\n    sort: top\n    timeRange: week\n", nil},
+		{"missing secrets", "credentials:\n  app:\n    client-id: id\n", []string{
+			"credentials.user is not set", "credentials.password is not set", "credentials.app.client-secret is not set",
+		}},
+		{"bad listing", credentials + "subreddit:\n  submissions:\n    limit: -1\n    sort: best\n", []string{
+			"subreddit.submissions.limit must be positive", "best",
+		}},
+		{"bad extensions", credentials + "subreddit:\n  submissions:\n    allowedExtensions: [\"\"]\n", []string{
+			"subreddit.submissions.allowedExtensions",
+		}},
+	}
+	args := os.Args
+	defer func() { os.Args = args }()
+	defer viper.Reset()
+	for _, tt := range tests {
+		path := filepath.Join(dir, strings.Replace(tt.name, " ", "-", -1)+".yaml")
+		err := ioutil.WriteFile(path, []byte(tt.config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		viper.Reset()
+		os.Args = []string{"earthpornbot", "validate", "-config", path}
+		err = run()
+		if len(tt.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if code := exitCode(err); code != exitConfig {
+			t.Errorf("%s: exited %d (%v), want %d", tt.name, code, err, exitConfig)
+			continue
+		}
+		for _, problem := range tt.want {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("%s: %v does not report %q", tt.name, err, problem)
+			}
+		}
+	}
+}
+
+func TestSetupConfigReadsStdin(t *testing.T) {
+	defer viper.Reset()
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		check func(*api.Config) bool
+	}{
+		{
+			"the config from stdin",
+			[]string{"-config", "-"},
+			"credentials:\n  user: user\nsubreddit:\n  name: pics\n  submissions:\n    limit: 7\n    sort: top\n",
+			func(c *api.Config) bool { return c.User == "user" && c.Limit == 7 && c.Sort == api.TopSubmissions },
+		},
+		{
+			"flags over stdin",
+			[]string{"-config", "-", "-limit", "3"},
+			"subreddit:\n  submissions:\n    limit: 7\n",
+			func(c *api.Config) bool { return c.Limit == 3 },
+		},
+		{
+			"defaults under stdin",
+			[]string{"-config", "-"},
+			"credentials:\n  user: user\n",
+			func(c *api.Config) bool { return c.User == "user" && c.Limit == 25 },
+		},
+	}
+	for _, tt := range tests {
+		viper.Reset()
+		flags := flag.NewFlagSet("earthpornbot", flag.ContinueOnError)
+		err := setupConfig(flags, tt.args, strings.NewReader(tt.stdin))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if cfg := api.LoadConfig(); !tt.check(cfg) {
+			t.Errorf("%s: loaded %+v", tt.name, cfg)
+		}
+	}
+
+	viper.Reset()
+	flags := flag.NewFlagSet("earthpornbot", flag.ContinueOnError)
+	err := setupConfig(flags, []string{"-config", "-"}, strings.NewReader("subreddit: [\n"))
+	if err == nil {
+		t.Error("read a malformed config from stdin")
+	}
+}
+
+// runCommand runs the command line args from an empty config file and returns what it printed
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	err := ioutil.WriteFile("empty.yaml", []byte("subreddit:\n  name: earthporn\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.TempFile("", "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	stdout, osArgs := os.Stdout, os.Args
+	defer func() { os.Stdout, os.Args = stdout, osArgs }()
+
+	viper.Reset()
+	os.Stdout = out
+	os.Args = append([]string{"earthpornbot", args[0], "-config", "empty.yaml"}, args[1:]...)
+	err = run()
+	os.Stdout = stdout
+	printed, readErr := ioutil.ReadFile(out.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return string(printed), err
+}
+
+func TestPruneCommand(t *testing.T) {
+	defer inTempDir(t)()
+	defer viper.Reset()
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	for name, modTime := range map[string]time.Time{
+		filepath.Join("hori", "old.png"):    old,
+		filepath.Join("vert", "old.png"):    old,
+		filepath.Join("hori", "recent.png"): time.Now().Add(-24 * time.Hour),
+	} {
+		err := os.MkdirAll(filepath.Dir(name), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(name, []byte("image"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(name, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := runCommand(t, "prune", "-older-than", "30d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "deleted 2 files\n" {
+		t.Errorf("printed %q", out)
+	}
+	for name, kept := range map[string]bool{
+		filepath.Join("hori", "old.png"):    false,
+		filepath.Join("vert", "old.png"):    false,
+		filepath.Join("hori", "recent.png"): true,
+	} {
+		if _, err := os.Stat(name); (err == nil) != kept {
+			t.Errorf("%s kept %v, want %v", name, err == nil, kept)
+		}
+	}
+
+	// without an age the command has nothing to do
+	_, err = runCommand(t, "prune")
+	if code := exitCode(err); code != exitConfig {
+		t.Errorf("pruning without an age exited %d (%v), want %d", code, err, exitConfig)
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	defer inTempDir(t)()
+	defer viper.Reset()
+	err := os.MkdirAll("hori", os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "b.png"} {
+		err = ioutil.WriteFile(filepath.Join("hori", name), []byte("image"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"store.jsonl": `{"id":"a","path":"hori/a.png","posted":true}` + "\n" + `{"id":"b","path":"hori/b.png"}` + "\n",
+		"index.jsonl": `{"id":"a","url":"https://i.redd.it/a.png","path":"hori/a.png"}` + "\n" +
+			`{"id":"b","url":"https://i.redd.it/b.png","path":"hori/b.png"}` + "\n" +
+			`{"id":"c","url":"https://i.redd.it/c.png","path":"hori/deleted.png"}` + "\n",
+		"posted.json": `["hori/a.png"]`,
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	written := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	err = os.Chtimes("index.jsonl", written, written)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runCommand(t, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "store: 0 submissions, 0 posted\nindex: 0 images, 0 left to post\nlast download: never\n" {
+		t.Errorf("printed without the store and the index:\n%s", out)
+	}
+
+	// the flags point at the files
+	os.Setenv("EARTHPORNBOT_NOTIFY_TWITTER_POSTED", "posted.json")
+	defer os.Unsetenv("EARTHPORNBOT_NOTIFY_TWITTER_POSTED")
+	out, err = runCommand(t, "status", "-store", "store.jsonl", "-index", "index.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "store: 2 submissions, 1 posted\nindex: 3 images, 1 left to post\nlast download: " + written.Format(time.RFC3339) + "\n"
+	if out != want {
+		t.Errorf("printed\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestFlagsOverrideTheConfig(t *testing.T) {
+	defer inTempDir(t)()
+	defer viper.Reset()
+	// a file setting every key the flags override
+	viper.Reset()
+	for _, key := range flagKeys {
+		viper.Set(key, "file")
+	}
+	for _, key := range boolFlagKeys {
+		viper.Set(key, false)
+	}
+	err := viper.WriteConfigAs("config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range flagKeys {
+		t.Run(name, func(t *testing.T) {
+			viper.Reset()
+			flags := flag.NewFlagSet("earthpornbot", flag.ContinueOnError)
+			err := setupConfig(flags, []string{"-config", "config.yaml", "-" + name, "flag"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := viper.GetString(key); got != "flag" {
+				t.Errorf("-%s set %s to %q", name, key, got)
+			}
+		})
+	}
+	for name, key := range boolFlagKeys {
+		t.Run(name, func(t *testing.T) {
+			viper.Reset()
+			flags := flag.NewFlagSet("earthpornbot", flag.ContinueOnError)
+			err := setupConfig(flags, []string{"-config", "config.yaml", "-" + name}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !viper.GetBool(key) {
+				t.Errorf("-%s left %s unset", name, key)
+			}
+		})
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthpornbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	empty := config("empty.yaml", "subreddit:\n  name: earthporn\n")
+	invalid := config("invalid.yaml", "subreddit:\n  submissions:\n    limit: -1\n")
+	unknownMode := config("mode.yaml", "credentials:\n  auth-mode: device\n")
+	valid := config("valid.yaml", "credentials:\n  auth-mode: app-only\n  app:\n    client-id: id\n")
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"fetch", "-config", filepath.Join(dir, "missing.yaml")}, exitConfig},
+		{[]string{"fetch", "-config", empty}, exitConfig},
+		{[]string{"-config", unknownMode}, exitConfig},
+		{[]string{"validate", "-config", invalid}, exitConfig},
+		{[]string{"prune", "-config", empty, "-older-than", "soon"}, exitConfig},
+		{[]string{"post", "-config", empty}, exitPost},
+		{[]string{"validate", "-config", valid}, exitOK},
+		{[]string{"status", "-help"}, exitOK},
+	}
+	args := os.Args
+	defer func() { os.Args = args }()
+	for _, test := range tests {
+		viper.Reset()
+		os.Args = append([]string{"earthpornbot"}, test.args...)
+		err := run()
+		if code := exitCode(err); code != test.code {
+			t.Errorf("%v: exited %d (%v), want %d", test.args, code, err, test.code)
+		}
+	}
+}
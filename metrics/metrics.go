@@ -0,0 +1,199 @@
+// Package metrics keeps counters and histograms and exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is a family of samples written under a name
+type metric interface {
+	write(w io.Writer) error
+}
+
+// Registry holds the metrics exposed together, it is safe for concurrent use
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// Default is the registry NewCounter and NewHistogram register to
+var Default = &Registry{}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the Prometheus text format, in the order they were created
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		err := m.write(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the metrics of r, typically on /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Counter is a value only going up, one per combination of its label values
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter creates a counter labelled by labels and registers it to Default
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: map[string]float64{}}
+	Default.register(c)
+	return c
+}
+
+// Inc adds 1 to the counter of labelValues, given in the order of the labels
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter of labelValues
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	key := labelPairs(c.labels, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *Counter) write(w io.Writer) error {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	header(&b, c.name, c.help, "counter")
+	// a counter without labels is exposed before anything is counted
+	if len(c.labels) == 0 && len(keys) == 0 {
+		keys = append(keys, "")
+	}
+	for _, k := range keys {
+		sample(&b, c.name, k, c.values[k])
+	}
+	c.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// DefaultBuckets suit durations in seconds, from 10ms to a minute
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram counts the observed values into buckets
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the upper bounds buckets, sorted, and registers it to
+// Default. The bucket counting everything is implied.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	Default.register(h)
+	return h
+}
+
+// Observe adds v to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	var b strings.Builder
+	header(&b, h.name, h.help, "histogram")
+	h.mu.Lock()
+	for i, bound := range h.buckets {
+		sample(&b, h.name+"_bucket", labelPairs([]string{"le"}, []string{formatValue(bound)}), float64(h.counts[i]))
+	}
+	sample(&b, h.name+"_bucket", `le="+Inf"`, float64(h.count))
+	sample(&b, h.name+"_sum", "", h.sum)
+	sample(&b, h.name+"_count", "", float64(h.count))
+	h.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func header(b *strings.Builder, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sample(b *strings.Builder, name, labels string, v float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s%s %s\n", name, labels, formatValue(v))
+}
+
+// labelPairs formats the labels and their values as name="value",..., missing values are empty
+func labelPairs(labels, values []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = label + `="` + escape.Replace(v) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// isolated replaces Default with an empty registry, returning the function restoring it
+func isolated() func() {
+	saved := Default
+	Default = &Registry{}
+	return func() { Default = saved }
+}
+
+func TestHandlerWritesTheTextFormat(t *testing.T) {
+	defer isolated()()
+	downloads := NewCounter("images_downloaded_total", "Images saved.", "orientation")
+	// exposed at 0 before anything is counted
+	NewCounter("runs_total", "Runs,\nended or not.")
+	durations := NewHistogram("download_seconds", "Download durations.", []float64{0.5, 1})
+
+	downloads.Inc("vert")
+	downloads.Add(2, "hori")
+	downloads.Add(-1, "hori")
+	downloads.Inc(`a"b`)
+	durations.Observe(0.25)
+	durations.Observe(0.75)
+	durations.Observe(3)
+
+	rec := httptest.NewRecorder()
+	Default.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP images_downloaded_total Images saved.
+# TYPE images_downloaded_total counter
+images_downloaded_total{orientation="a\"b"} 1
+images_downloaded_total{orientation="hori"} 2
+images_downloaded_total{orientation="vert"} 1
+# HELP runs_total Runs,\nended or not.
+# TYPE runs_total counter
+runs_total 0
+# HELP download_seconds Download durations.
+# TYPE download_seconds histogram
+download_seconds_bucket{le="0.5"} 1
+download_seconds_bucket{le="1"} 2
+download_seconds_bucket{le="+Inf"} 3
+download_seconds_sum 4
+download_seconds_count 3
+`
+	if string(body) != want {
+		t.Errorf("got\n%s\nwant\n%s", body, want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/lucbarr/earthpornbot/api"
+	"github.com/lucbarr/earthpornbot/logging"
+	"github.com/lucbarr/earthpornbot/metrics"
+	"github.com/lucbarr/earthpornbot/server"
+	"github.com/spf13/viper"
+)
+
+// shutdownTimeout bounds how long the requests in flight may take once the server is stopping
+const shutdownTimeout = 5 * time.Second
+
+// serve answers the HTTP API, and the metrics on /metrics, on serve.listen until ctx is cancelled
+func serve(ctx context.Context) error {
+	reddit := api.NewReddit()
+	err := reddit.CheckLocalCopies("serve")
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+	// the images are listed from the manifest, so it must be readable from the start
+	_, err = reddit.Manifest()
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.Handle("/", server.New(reddit.Manifest, reddit.Clock))
+	srv := &http.Server{Addr: viper.GetString("serve.listen"), Handler: mux}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	logging.Default().Info("serving", "addr", srv.Addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	logging.Default().Info("stopping")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// serveMetrics answers the metrics on addr/metrics until the process exits
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	logging.Default().Info("serving the metrics", "addr", addr)
+	err := http.ListenAndServe(addr, mux)
+	logging.Default().Error("could not serve the metrics", "addr", addr, "err", err)
+}
@@ -0,0 +1,168 @@
+// Package server serves the downloaded images over HTTP, so wallpaper tools and galleries can
+// use the collection without access to the filesystem of the bot.
+package server
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/lucbarr/earthpornbot/api"
+	"github.com/lucbarr/earthpornbot/logging"
+)
+
+// Manifest returns the records of the downloaded images, api.Reddit.Manifest typically
+type Manifest func() ([]api.ManifestRecord, error)
+
+// Image is an entry of the /images listing
+type Image struct {
+	api.ManifestRecord
+	// Link is where the image is served, /images/<id> or /images/<id>/<gallery> for the images of
+	// galleries
+	Link string `json:"link"`
+}
+
+// link is the path the image of rec is served at
+func link(rec api.ManifestRecord) string {
+	if rec.Gallery == 0 {
+		return "/images/" + rec.ID
+	}
+	return "/images/" + rec.ID + "/" + strconv.Itoa(rec.Gallery)
+}
+
+// New returns the handler serving:
+//
+//	GET /images                   the images as JSON, newest first
+//	GET /images/<id>              the image of a submission
+//	GET /images/<id>/<gallery>    an image of a gallery submission, by its position from 1
+//	GET /random                   a random image
+//
+// /images and /random accept an orientation parameter, hori or vert, keeping only those images.
+// Only the images of a submission whose file is still there are served. The random images are
+// seeded by clock.
+func New(manifest Manifest, clock api.Clock) http.Handler {
+	s := &server{manifest: manifest, rand: rand.New(rand.NewSource(clock.Now().UnixNano()))}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", s.list)
+	mux.HandleFunc("/images/", s.image)
+	mux.HandleFunc("/random", s.random)
+	return mux
+}
+
+type server struct {
+	manifest Manifest
+
+	// rand is not safe for concurrent use
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// images returns the records of a submission still on disk matching orientation, if set, newest
+// first and the newest only of an image indexed again. It answers the request itself and returns
+// false when it fails.
+func (s *server) images(w http.ResponseWriter, req *http.Request) ([]api.ManifestRecord, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	orientation := req.URL.Query().Get("orientation")
+	if orientation != "" && orientation != "hori" && orientation != "vert" {
+		http.Error(w, "orientation must be hori or vert", http.StatusBadRequest)
+		return nil, false
+	}
+
+	records, err := s.manifest()
+	if err != nil {
+		logging.Default().Error("could not read the manifest", "err", err)
+		http.Error(w, "could not read the manifest", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	kept := make([]api.ManifestRecord, 0, len(records))
+	links := map[string]bool{}
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := records[i]
+		// the images imported without a submission have no link
+		if rec.ID == "" || links[link(rec)] || (orientation != "" && rec.Orientation != orientation) {
+			continue
+		}
+		_, err := os.Stat(rec.Path)
+		if err != nil {
+			continue
+		}
+		links[link(rec)] = true
+		kept = append(kept, rec)
+	}
+	return kept, true
+}
+
+func (s *server) list(w http.ResponseWriter, req *http.Request) {
+	records, ok := s.images(w, req)
+	if !ok {
+		return
+	}
+	images := make([]Image, 0, len(records))
+	for _, rec := range records {
+		images = append(images, Image{rec, link(rec)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(images)
+	if err != nil {
+		logging.Default().Warn("could not write the image listing", "err", err)
+	}
+}
+
+func (s *server) image(w http.ResponseWriter, req *http.Request) {
+	target := req.URL.Path
+	records, ok := s.images(w, req)
+	if !ok {
+		return
+	}
+	// only the files of the manifest are served, by the submission and position in the gallery
+	for _, rec := range records {
+		if link(rec) == target {
+			serveFile(w, req, rec.Path)
+			return
+		}
+	}
+	http.NotFound(w, req)
+}
+
+func (s *server) random(w http.ResponseWriter, req *http.Request) {
+	records, ok := s.images(w, req)
+	if !ok {
+		return
+	}
+	if len(records) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	s.mu.Lock()
+	rec := records[s.rand.Intn(len(records))]
+	s.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	serveFile(w, req, rec.Path)
+}
+
+// serveFile streams the file at path, answering range and conditional requests
+func serveFile(w http.ResponseWriter, req *http.Request, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "could not read the image", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, req, filepath.Base(path), info.ModTime(), file)
+}
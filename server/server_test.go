@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lucbarr/earthpornbot/api"
+	"github.com/lucbarr/earthpornbot/logging"
+)
+
+// fixedClock is always at the same time, so the random images are the same every run
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// After fires at once for d <= 0 only, as the time never comes otherwise
+func (c fixedClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- time.Time(c)
+	}
+	return ch
+}
+
+// testImages writes the files of a manifest into a temporary directory, the last one is missing.
+// It returns the manifest, oldest first, and the function removing the directory.
+func testImages(t *testing.T) ([]api.ManifestRecord, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []api.ManifestRecord{
+		{ID: "a", Path: filepath.Join(dir, "hori", "a.jpg"), Orientation: "hori"},
+		{ID: "b", Path: filepath.Join(dir, "vert", "b.jpg"), Orientation: "vert"},
+		{ID: "c", Path: filepath.Join(dir, "hori", "c.jpg"), Orientation: "hori"},
+		{ID: "gone", Path: filepath.Join(dir, "hori", "gone.jpg"), Orientation: "hori"},
+	}
+	for _, rec := range records[:len(records)-1] {
+		err = os.MkdirAll(filepath.Dir(rec.Path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(rec.Path, []byte(rec.ID), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return records, func() { os.RemoveAll(dir) }
+}
+
+func TestListing(t *testing.T) {
+	records, cleanup := testImages(t)
+	defer cleanup()
+	h := New(func() ([]api.ManifestRecord, error) { return records, nil }, fixedClock(time.Unix(0, 0)))
+
+	tests := []struct {
+		target string
+		status int
+		ids    []string
+	}{
+		{"/images", http.StatusOK, []string{"c", "b", "a"}},
+		{"/images?orientation=hori", http.StatusOK, []string{"c", "a"}},
+		{"/images?orientation=vert", http.StatusOK, []string{"b"}},
+		{"/images?orientation=square", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var images []Image
+			err := json.Unmarshal(rec.Body.Bytes(), &images)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(images) != len(tt.ids) {
+				t.Fatalf("listed %+v, want %v", images, tt.ids)
+			}
+			for i, id := range tt.ids {
+				if images[i].ID != id || images[i].Link != "/images/"+id {
+					t.Errorf("image %d is %s at %s, want %s", i, images[i].ID, images[i].Link, id)
+				}
+			}
+		})
+	}
+}
+
+func TestImages(t *testing.T) {
+	records, cleanup := testImages(t)
+	defer cleanup()
+	h := New(func() ([]api.ManifestRecord, error) { return records, nil }, fixedClock(time.Unix(0, 0)))
+
+	tests := []struct {
+		method string
+		target string
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/images/b", http.StatusOK, "b"},
+		{http.MethodGet, "/images/b.jpg", http.StatusNotFound, ""},
+		{http.MethodGet, "/images/gone", http.StatusNotFound, ""},
+		{http.MethodGet, "/images/manifest.json", http.StatusNotFound, ""},
+		{http.MethodPost, "/images/b", http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/random?orientation=vert", http.StatusOK, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("served %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestImagesWithTheSameFileName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	records := []api.ManifestRecord{
+		{ID: "x", Path: filepath.Join(dir, "hori", "photo.jpg")},
+		{ID: "y", Path: filepath.Join(dir, "vert", "photo.jpg")},
+		{ID: "g", Gallery: 1, Path: filepath.Join(dir, "sub-4k", "photo.jpg")},
+		{ID: "g", Gallery: 2, Path: filepath.Join(dir, "4k", "photo.jpg")},
+		{Path: filepath.Join(dir, "imported", "photo.jpg")},
+	}
+	for i, rec := range records {
+		err = os.MkdirAll(filepath.Dir(rec.Path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(rec.Path, []byte{'0' + byte(i)}, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := New(func() ([]api.ManifestRecord, error) { return records, nil }, fixedClock(time.Unix(0, 0)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images", nil))
+	var images []Image
+	err = json.Unmarshal(rec.Body.Bytes(), &images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, img := range images {
+		links = append(links, img.Link)
+	}
+	if want := []string{"/images/g/2", "/images/g/1", "/images/y", "/images/x"}; !reflect.DeepEqual(links, want) {
+		t.Errorf("linked %v, want %v", links, want)
+	}
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/images/x", http.StatusOK, "0"},
+		{"/images/y", http.StatusOK, "1"},
+		{"/images/g/1", http.StatusOK, "2"},
+		{"/images/g/2", http.StatusOK, "3"},
+		{"/images/g", http.StatusNotFound, ""},
+		{"/images/g/3", http.StatusNotFound, ""},
+		{"/images/photo.jpg", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, rec.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s: served %q, want %q", tt.target, rec.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestRandomServesTheImagesLeft(t *testing.T) {
+	records, cleanup := testImages(t)
+	defer cleanup()
+	h := New(func() ([]api.ManifestRecord, error) { return records, nil }, fixedClock(time.Unix(0, 0)))
+
+	served := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/random?orientation=hori", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200", rec.Code)
+		}
+		if rec.Header().Get("Cache-Control") != "no-store" {
+			t.Error("a random image may be cached")
+		}
+		served[rec.Body.String()] = true
+	}
+	if len(served) != 2 || !served["a"] || !served["c"] {
+		t.Errorf("served %v, want a and c", served)
+	}
+
+	empty := New(func() ([]api.ManifestRecord, error) { return nil, nil }, fixedClock(time.Unix(0, 0)))
+	rec := httptest.NewRecorder()
+	empty.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/random", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d without images, want 404", rec.Code)
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	saved := logging.Default()
+	defer logging.SetDefault(saved)
+	logging.SetDefault(nil)
+
+	h := New(func() ([]api.ManifestRecord, error) { return nil, errors.New("corrupt") }, fixedClock(time.Unix(0, 0)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", rec.Code)
+	}
+}
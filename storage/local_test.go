@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	root, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := Local{Root: root}
+
+	for _, key := range []string{"hori/a.png", "hori/b.png", "vert/c.png"} {
+		err = s.Put(key, strings.NewReader(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := s.List("hori/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Key != "hori/a.png" || objects[1].Key != "hori/b.png" {
+		t.Errorf("listed %+v below hori/", objects)
+	}
+	file, err := s.Open("vert/c.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(file)
+	file.Close()
+	if string(data) != "vert/c.png" {
+		t.Errorf("read %q from vert/c.png", data)
+	}
+
+	err = s.Delete("hori/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Delete("hori/a.png")
+	if err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	for key, want := range map[string]bool{"hori/a.png": false, "hori/b.png": true} {
+		exists, err := s.Exists(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != want {
+			t.Errorf("%s exists %v, want %v", key, exists, want)
+		}
+	}
+	_, err = s.Open("hori/a.png")
+	if err != ErrNotFound {
+		t.Errorf("opening a deleted key: got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// memory is an in-memory backend, failing every call once down
+type memory struct {
+	objects map[string]string
+	down    bool
+}
+
+func newMemory() *memory {
+	return &memory{objects: make(map[string]string)}
+}
+
+var errDown = errors.New("backend down")
+
+func (m *memory) Put(key string, src io.ReadSeeker) error {
+	if m.down {
+		return errDown
+	}
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	m.objects[key] = string(data)
+	return nil
+}
+
+func (m *memory) Exists(key string) (bool, error) {
+	if m.down {
+		return false, errDown
+	}
+	_, ok := m.objects[key]
+	return ok, nil
+}
+
+func (m *memory) Open(key string) (io.ReadCloser, error) {
+	if m.down {
+		return nil, errDown
+	}
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+func (m *memory) List(prefix string) ([]Object, error) {
+	var objects []Object
+	for key, data := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Size: int64(len(data))})
+		}
+	}
+	return objects, nil
+}
+
+func (m *memory) Delete(key string) error {
+	if m.down {
+		return errDown
+	}
+	delete(m.objects, key)
+	return nil
+}
+
+func TestMultiWritesToEveryBackend(t *testing.T) {
+	local, remote := newMemory(), newMemory()
+	m := Multi{{Backend: local, Name: "local", Required: true}, {Backend: remote, Name: "remote"}}
+	for _, key := range []string{"hori/a.png", "vert/b.png"} {
+		// the source is rewound for every backend
+		err := m.Put(key, strings.NewReader(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range []*memory{local, remote} {
+		if len(b.objects) != 2 || b.objects["hori/a.png"] != "hori/a.png" || b.objects["vert/b.png"] != "vert/b.png" {
+			t.Errorf("stored %v, want both images", b.objects)
+		}
+	}
+
+	// the reads go to the required backend
+	remote.objects["vert/b.png"] = "remote"
+	file, err := m.Open("vert/b.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(file)
+	file.Close()
+	if string(data) != "vert/b.png" {
+		t.Errorf("read %q, want the object of the required backend", data)
+	}
+
+	err = m.Delete("hori/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []*memory{local, remote} {
+		if _, ok := b.objects["hori/a.png"]; ok {
+			t.Errorf("hori/a.png left in %v", b.objects)
+		}
+	}
+}
+
+func TestMultiFailsOnTheRequiredBackends(t *testing.T) {
+	tests := []struct {
+		name     string
+		required bool
+		wantErr  bool
+	}{
+		{"required", true, true},
+		{"optional", false, false},
+	}
+	for _, tt := range tests {
+		local, mirror := newMemory(), newMemory()
+		mirror.down = true
+		m := Multi{{Backend: local, Name: "local", Required: true}, {Backend: mirror, Name: "mirror", Required: tt.required}}
+		err := m.Put("hori/a.png", strings.NewReader("a"))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: put got %v", tt.name, err)
+		}
+		if local.objects["hori/a.png"] != "a" {
+			t.Errorf("%s: the local copy is missing", tt.name)
+		}
+		_, err = m.Exists("hori/a.png")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: exists got %v", tt.name, err)
+		}
+		err = m.Delete("hori/a.png")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: delete got %v", tt.name, err)
+		}
+	}
+}
+
+func TestMultiExists(t *testing.T) {
+	tests := []struct {
+		name     string
+		required []bool
+		stored   []bool
+		want     bool
+	}{
+		{"every required backend has it", []bool{true, true, false}, []bool{true, true, false}, true},
+		{"a required backend misses it", []bool{true, true}, []bool{true, false}, false},
+		{"only an optional backend has it", []bool{true, false}, []bool{false, true}, false},
+		{"none required, one has it", []bool{false, false}, []bool{false, true}, true},
+		{"none required, none has it", []bool{false, false}, []bool{false, false}, false},
+	}
+	for _, tt := range tests {
+		var m Multi
+		for i, required := range tt.required {
+			b := newMemory()
+			if tt.stored[i] {
+				b.objects["hori/a.png"] = "a"
+			}
+			m = append(m, Member{Backend: b, Required: required})
+		}
+		got, err := m.Exists("hori/a.png")
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config locates a bucket and the credentials to write to it
+type S3Config struct {
+	Bucket string
+	Region string
+	// Endpoint, when set, replaces AWS, e.g. for MinIO or another S3 compatible service
+	Endpoint string
+	// Prefix is prepended to every key, e.g. wallpapers/
+	Prefix string
+	// AccessKey and SecretKey default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	// environment variables
+	AccessKey string `mapstructure:"accessKey"`
+	SecretKey string `mapstructure:"secretKey"`
+}
+
+// S3 stores the objects in a bucket through the S3 REST API, signed with AWS Signature V4
+type S3 struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	prefix    string
+	accessKey string
+	secretKey string
+	// sessionToken is set for temporary credentials
+	sessionToken string
+	now          func() time.Time
+}
+
+// NewS3 returns the backend of the bucket of cfg
+func NewS3(client *http.Client, cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("no bucket")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &S3{
+		client:       client,
+		endpoint:     endpoint,
+		bucket:       cfg.Bucket,
+		region:       cfg.Region,
+		prefix:       cfg.Prefix,
+		accessKey:    cfg.AccessKey,
+		secretKey:    cfg.SecretKey,
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		now:          time.Now,
+	}
+	if s.accessKey == "" && s.secretKey == "" {
+		s.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	} else {
+		s.sessionToken = ""
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("no credentials")
+	}
+	return s, nil
+}
+
+// objectURL is the path-style URL of key, with query
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	// sent as signed, Go leaves characters such as ( ) ! + unescaped in paths
+	u.RawPath = canonicalURI(u.Path)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (s *S3) Put(key string, src io.ReadSeeker) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, src)
+	if err != nil {
+		return err
+	}
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(s.prefix+key, nil).String(), ioutil.NopCloser(src))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	_, err = s.do(req, hex.EncodeToString(hash.Sum(nil)))
+	return err
+}
+
+func (s *S3) Exists(key string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, s.objectURL(s.prefix+key, nil).String(), nil)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.do(req, emptyHash)
+	var status *statusError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *S3) Open(key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(s.prefix+key, nil).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.send(req, emptyHash)
+	var status *statusError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) List(prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.prefix+prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, s.objectURL("", query).String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := s.do(req, emptyHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.Unmarshal(body, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			objects = append(objects, Object{
+				Key:     strings.TrimPrefix(c.Key, s.prefix),
+				Size:    c.Size,
+				ModTime: c.LastModified,
+			})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(s.prefix+key, nil).String(), nil)
+	if err != nil {
+		return err
+	}
+	// S3 answers 204 whether the key existed or not
+	_, err = s.do(req, emptyHash)
+	return err
+}
+
+// statusError is an answer of S3 with a non 2xx status
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("s3: unexpected status %d: %s", e.code, e.msg)
+}
+
+// maxResponseBytes bounds the answers read, listings are at most 1000 keys
+const maxResponseBytes = 4 << 20
+
+// do signs and sends req, whose body hashes to payloadHash, and returns the answer body
+func (s *S3) do(req *http.Request, payloadHash string) ([]byte, error) {
+	resp, err := s.send(req, payloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+}
+
+// send signs and sends req, whose body hashes to payloadHash, and returns the answer for the
+// caller to close, a statusError unless it is a success
+func (s *S3) send(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash, s.now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+		if err != nil {
+			return nil, err
+		}
+		return nil, &statusError{code: resp.StatusCode, msg: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+// emptyHash is the SHA-256 of an empty payload
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign sets the Authorization header of req as AWS Signature V4 requires, signing the host and
+// every header already set
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalURI encodes every segment of path as Signature V4 requires, all but the
+// unreserved characters of RFC 3986
+func canonicalURI(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		var b strings.Builder
+		for _, c := range []byte(segment) {
+			if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,134 @@
+// Package storage abstracts where the classified images are kept: a local directory, an S3
+// bucket, or several of them at once.
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/lucbarr/earthpornbot/logging"
+)
+
+// Backend stores objects by key, keys use / as separator whatever the platform
+type Backend interface {
+	// Put writes the content of src as key, replacing any previous object
+	Put(key string, src io.ReadSeeker) error
+	// Exists reports whether key was stored
+	Exists(key string) (bool, error)
+	// Open reads key, ErrNotFound when it was not stored
+	Open(key string) (io.ReadCloser, error)
+	// List returns the objects whose key starts with prefix
+	List(prefix string) ([]Object, error)
+	// Delete removes key, deleting a missing key is not an error
+	Delete(key string) error
+}
+
+// ErrNotFound is the error of Open for a key never stored
+var ErrNotFound = errors.New("storage: object not found")
+
+// Object describes a stored object
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Member is a backend of a Multi, the failures of optional ones are only logged
+type Member struct {
+	Backend
+	Name     string
+	Required bool
+}
+
+// Multi fans the writes out to several backends and reads from the required ones
+type Multi []Member
+
+// Put writes to every backend, it fails when a required backend fails
+func (m Multi) Put(key string, src io.ReadSeeker) error {
+	var errs []error
+	for _, b := range m {
+		_, err := src.Seek(0, io.SeekStart)
+		if err == nil {
+			err = b.Put(key, src)
+		}
+		if err == nil {
+			continue
+		}
+		if b.Required {
+			errs = append(errs, err)
+		} else {
+			logging.Default().Warn("could not save to a mirror", "key", key, "mirror", b.Name, "err", err)
+		}
+	}
+	return firstError(errs)
+}
+
+// Exists reports whether every required backend has key, or any backend when none is required
+func (m Multi) Exists(key string) (bool, error) {
+	required, found := false, false
+	for _, b := range m {
+		ok, err := b.Exists(key)
+		if err != nil && b.Required {
+			return false, err
+		}
+		if b.Required {
+			required = true
+			if !ok {
+				return false, nil
+			}
+		}
+		found = found || ok
+	}
+	return required || found, nil
+}
+
+// List returns the objects of the first required backend, or of the first backend when none is
+func (m Multi) List(prefix string) ([]Object, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	for _, b := range m {
+		if b.Required {
+			return b.List(prefix)
+		}
+	}
+	return m[0].List(prefix)
+}
+
+// Open reads key from the first required backend, or from the first backend when none is
+func (m Multi) Open(key string) (io.ReadCloser, error) {
+	if len(m) == 0 {
+		return nil, ErrNotFound
+	}
+	for _, b := range m {
+		if b.Required {
+			return b.Open(key)
+		}
+	}
+	return m[0].Open(key)
+}
+
+// Delete removes key from every backend, it fails when a required backend fails
+func (m Multi) Delete(key string) error {
+	var errs []error
+	for _, b := range m {
+		err := b.Delete(key)
+		if err == nil {
+			continue
+		}
+		if b.Required {
+			errs = append(errs, err)
+		} else {
+			logging.Default().Warn("could not delete from a mirror", "key", key, "mirror", b.Name, "err", err)
+		}
+	}
+	return firstError(errs)
+}
+
+func firstError(errs []error) error {
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// fileStore is an append-only JSON lines file, the last line of an id wins
+type fileStore struct {
+	mu      sync.Mutex
+	file    *os.File
+	records map[string]Record
+}
+
+// OpenFile opens the store at path, creating it when missing
+func OpenFile(path string) (Store, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileStore{file: file, records: map[string]Record{}}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec Record
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		s.records[rec.ID] = rec
+	}
+	err = scanner.Err()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileStore) Get(id string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[id]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return rec, nil
+}
+
+func (s *fileStore) Put(rec Record) error {
+	if rec.ID == "" {
+		return errors.New("store: record without id")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	s.records[rec.ID] = rec
+	return nil
+}
+
+func (s *fileStore) Records() ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]Record, 0, len(s.records))
+	for _, rec := range s.records {
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (s *fileStore) Close() error {
+	return s.file.Close()
+}
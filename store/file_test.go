@@ -0,0 +1,103 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// tempFile returns a path in a new temporary directory and the function removing it
+func tempFile(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "store.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func TestFileStoreKeepsTheLastRecordOfEachID(t *testing.T) {
+	path, cleanup := tempFile(t)
+	defer cleanup()
+
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []Record{
+		{ID: "a", Path: "hori/a.png"},
+		{ID: "b", Skipped: "duplicate"},
+		{ID: "a", Path: "hori/a.png", PostedTo: []string{"twitter"}},
+	} {
+		err = s.Put(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rec, err := s.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.PostedTo) != 1 || rec.PostedTo[0] != "twitter" {
+		t.Errorf("got %+v, want the last record of a", rec)
+	}
+	_, err = s.Get("c")
+	if err != ErrNotFound {
+		t.Errorf("got %v for a missing id, want ErrNotFound", err)
+	}
+
+	records, err := s.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, rec := range records {
+		ids = append(ids, rec.ID)
+	}
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Errorf("records of %v, want a and b", ids)
+	}
+}
+
+func TestOpenFileRejectsCorruptLines(t *testing.T) {
+	path, cleanup := tempFile(t)
+	defer cleanup()
+	err := ioutil.WriteFile(path, []byte("{\"id\":\"a\"}\nnot json\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFile(path)
+	if err == nil {
+		s.Close()
+		t.Error("opened a corrupt store")
+	}
+}
+
+func TestPutRequiresAnID(t *testing.T) {
+	path, cleanup := tempFile(t)
+	defer cleanup()
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	err = s.Put(Record{Path: "hori/a.png"})
+	if err == nil {
+		t.Error("stored a record without id")
+	}
+}
@@ -0,0 +1,37 @@
+// Package store records what the bot already did with each submission, so runs skip the
+// submissions handled by a previous one.
+package store
+
+import "errors"
+
+// Record is what is known about a downloaded submission
+type Record struct {
+	// ID is the reddit id of the submission
+	ID   string `json:"id"`
+	URL  string `json:"url"`
+	Path string `json:"path"`
+	// Checksum is the hex SHA-256 of the downloaded file
+	Checksum string `json:"checksum"`
+	// PHash is the hex perceptual hash of the image, set when it was computed
+	PHash string `json:"phash,omitempty"`
+	// Posted tells whether the image was posted elsewhere, e.g. to Twitter
+	Posted bool `json:"posted,omitempty"`
+	// PostedTo names the publishers the image was posted by
+	PostedTo []string `json:"postedTo,omitempty"`
+	// Skipped is why the image was not saved, after its download, empty for the saved ones
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Store persists records by submission id, implementations must be safe for concurrent use
+type Store interface {
+	// Get returns the record of the submission id, ErrNotFound when there is none
+	Get(id string) (Record, error)
+	// Put adds or replaces the record of rec.ID
+	Put(rec Record) error
+	// Records returns every record, in no particular order
+	Records() ([]Record, error)
+	Close() error
+}
+
+// ErrNotFound is returned by Get for submissions never stored
+var ErrNotFound = errors.New("store: not found")